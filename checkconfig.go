@@ -0,0 +1,118 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/martinplaner/felix/internal/felix"
+)
+
+// checkConfigCommand reports all problems of the config file with their YAML path.
+func checkConfigCommand(args []string) int {
+	fs := flag.NewFlagSet("check-config", flag.ExitOnError)
+	configfile := fs.String("config", "config.yml", "location of the config file")
+	fs.Parse(args)
+
+	_, err := loadConfig(*configfile)
+	if errs, ok := err.(felix.ConfigErrors); ok {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stdout, "%s: %v\n", *configfile, e)
+		}
+		fmt.Fprintf(os.Stdout, "%s: %d problem(s) found\n", *configfile, len(errs))
+		return 1
+	}
+	if err != nil {
+		log.Error("could not read config file", "err", err, "configfile", *configfile)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stdout, "%s: ok\n", *configfile)
+	return 0
+}
+
+// loadConfig reads and validates the config file. Validation problems are returned as felix.ConfigErrors.
+func loadConfig(configfile string) (felix.Config, error) {
+	config, err := felix.ConfigFromFile(configfile)
+	if err != nil {
+		return config, err
+	}
+	return config, validateConfig(config)
+}
+
+// mustLoadConfig reads and validates the config file and exits after logging all problems.
+func mustLoadConfig(configfile string) felix.Config {
+	config, err := loadConfig(configfile)
+	if errs, ok := err.(felix.ConfigErrors); ok {
+		for _, e := range errs {
+			log.Error("invalid config", "path", e.Path, "err", e.Err)
+		}
+		log.Fatal("invalid config file", "configfile", configfile, "problems", len(errs))
+	}
+	if err != nil {
+		log.Fatal("could not read config file", "err", err, "configfile", configfile)
+	}
+	return config
+}
+
+// validateConfig checks the configuration including all feeds, filters and sinks, which are created
+// (but not started) for this purpose. It returns felix.ConfigErrors with all problems found.
+func validateConfig(config felix.Config) error {
+	var errs felix.ConfigErrors
+	if err := config.Validate(); err != nil {
+		errs.Add("", err)
+	}
+
+	newFetcher := newFeedFetcher(config, nil)
+	for i, fc := range config.Feeds {
+		if fc.Type == "" {
+			// Reported by Validate
+			continue
+		}
+		if _, err := newFetcher(fc); err != nil {
+			errs.Add(fmt.Sprintf("feeds[%d].type", i), err)
+		}
+	}
+
+	for i, f := range config.ItemFilters {
+		if _, err := newItemFilter(f); err != nil {
+			errs.Add(fmt.Sprintf("itemFilters[%d]", i), err)
+		}
+	}
+
+	for i, f := range config.LinkFilters {
+		if _, err := newLinkFilter(f); err != nil {
+			errs.Add(fmt.Sprintf("linkFilters[%d]", i), err)
+		}
+	}
+
+	for i, o := range config.Outputs {
+		for j, f := range o.Filters {
+			path := fmt.Sprintf("outputs[%d].filters[%d]", i, j)
+			// Stateful or fetching filters only make sense before links are stored
+			if f.Type == "duplicates" || f.Type == "expanduploadedlinks" {
+				errs.Add(path+".type", fmt.Errorf("unsupported output filter type %q", f.Type))
+				continue
+			}
+			if _, err := newLinkFilter(f); err != nil {
+				errs.Add(path, err)
+			}
+		}
+	}
+
+	for i, s := range config.Sinks {
+		if s.Type == "" {
+			// Reported by Validate
+			continue
+		}
+		if _, err := newSink(config, nil, s); err != nil {
+			errs.Add(fmt.Sprintf("sinks[%d]", i), err)
+		}
+	}
+
+	return errs.Err()
+}
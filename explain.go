@@ -0,0 +1,188 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/martinplaner/felix/internal/felix"
+)
+
+// explainCommand replays stored links or items, or the entries scanned from a URL, local file or stdin,
+// through the configured filter chains and prints the decision of every filter with its reason.
+func explainCommand(args []string) int {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	configfile := fs.String("config", "config.yml", "location of the config file")
+	datadir := fs.String("datadir", ".", "dir for auxiliary data (only used without input)")
+	items := fs.Bool("items", false, "replay the stored items instead of the stored links")
+	maxAge := fs.Duration("max-age", 0, "maximum age of the replayed entries (default: retention of the entries)")
+	url := fs.String("url", "", "only replay the stored entry with this URL")
+	scannerName := fs.String("scanner", "auto", "scanner to run on the input: rss, html or auto (detected by the content)")
+	format := fs.String("format", "table", "output format: table or json")
+	onlyDropped := fs.Bool("only-dropped", false, "only print the entries that were dropped")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: felix explain [flags] [<url|file|->]\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() > 1 || (*format != "table" && *format != "json") {
+		fs.Usage()
+		return 2
+	}
+	if _, ok := scanners[*scannerName]; !ok && *scannerName != "auto" {
+		log.Error("unknown scanner", "scanner", *scannerName)
+		return 2
+	}
+
+	config := mustLoadConfig(*configfile)
+	itemFilters := initItemFilters(config)
+	linkFilters := initLinkFilters(config.LinkFilters)
+
+	result := explainResult{Items: []felix.ItemTrace{}, Links: []felix.LinkTrace{}}
+	if fs.NArg() == 1 {
+		e, err := scanInput(fs.Arg(0), *scannerName)
+		if err != nil {
+			log.Error("could not scan input", "err", err, "input", fs.Arg(0))
+			return 1
+		}
+		result.Items = felix.TraceItems(e.items, itemFilters...)
+		result.Links = felix.TraceLinks(e.links, linkFilters...)
+	} else {
+		db, err := openDatastore(*datadir)
+		if err != nil {
+			log.Error("could not open datastore", "err", err)
+			return 1
+		}
+		defer db.Close()
+
+		retention := config.CleanupRetention()
+		if *items {
+			stored, err := db.GetItems(durationOr(*maxAge, retention.Items.MaxAge))
+			if err != nil {
+				log.Error("could not get stored items", "err", err)
+				return 1
+			}
+			var replay []felix.Item
+			for _, item := range stored {
+				if *url == "" || item.URL == *url {
+					replay = append(replay, item)
+				}
+			}
+			sort.SliceStable(replay, func(i, j int) bool { return replay[i].Added.After(replay[j].Added) })
+			result.Items = felix.TraceItems(replay, itemFilters...)
+		} else {
+			stored, err := db.GetLinks(durationOr(*maxAge, retention.Links.MaxAge))
+			if err != nil {
+				log.Error("could not get stored links", "err", err)
+				return 1
+			}
+			var replay []felix.Link
+			for _, link := range stored {
+				if *url == "" || link.URL == *url {
+					replay = append(replay, link)
+				}
+			}
+			sort.SliceStable(replay, func(i, j int) bool { return replay[i].Added.After(replay[j].Added) })
+			result.Links = felix.TraceLinks(replay, linkFilters...)
+		}
+	}
+
+	if *onlyDropped {
+		result = result.dropped()
+	}
+
+	var err error
+	if *format == "json" {
+		err = json.NewEncoder(os.Stdout).Encode(result)
+	} else {
+		err = result.writeTable(os.Stdout)
+	}
+	if err != nil {
+		log.Error("could not write output", "err", err)
+		return 1
+	}
+
+	return 0
+}
+
+// durationOr returns d or def if d is not set.
+func durationOr(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+// explainResult is the output of explainCommand.
+type explainResult struct {
+	Items []felix.ItemTrace `json:"items"`
+	Links []felix.LinkTrace `json:"links"`
+}
+
+// dropped returns the traces of the dropped entries only.
+func (r explainResult) dropped() explainResult {
+	dropped := explainResult{Items: []felix.ItemTrace{}, Links: []felix.LinkTrace{}}
+	for _, t := range r.Items {
+		if t.Dropped() {
+			dropped.Items = append(dropped.Items, t)
+		}
+	}
+	for _, t := range r.Links {
+		if t.Dropped() {
+			dropped.Links = append(dropped.Links, t)
+		}
+	}
+	return dropped
+}
+
+// writeTable writes every entry with its result, followed by the decision of each filter it reached
+// and the links that left the chain, if they differ from the entry.
+func (r explainResult) writeTable(w io.Writer) error {
+	for _, t := range r.Items {
+		if err := writeTrace(w, "item", t.Item.Title, t.Item.URL, traceResult(true, t.DroppedBy, t.DroppedAt), t.Steps, nil); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range r.Links {
+		var outputs []felix.Link
+		if len(t.Output) != 1 || t.Output[0] != t.Link {
+			outputs = t.Output
+		}
+		if err := writeTrace(w, "link", t.Link.Title, t.Link.URL, traceResult(true, t.DroppedBy, t.DroppedAt), t.Steps, outputs); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "%d items (%d dropped), %d links (%d dropped)\n",
+		len(r.Items), len(r.dropped().Items), len(r.Links), len(r.dropped().Links))
+	return err
+}
+
+func writeTrace(w io.Writer, kind, title, url, result string, steps []felix.TraceStep, outputs []felix.Link) error {
+	fmt.Fprintf(w, "%s %s %q: %s\n", kind, url, title, result)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, s := range steps {
+		fmt.Fprintf(tw, "  #%d\t%s\t%s\t%s\n", s.Position, s.Filter, s.Decision, s.Reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, out := range outputs {
+		fmt.Fprintf(w, "  -> %s %q\n", out.URL, out.Title)
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
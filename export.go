@@ -0,0 +1,106 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/martinplaner/felix/internal/felix"
+	"github.com/martinplaner/felix/internal/felix/bolt"
+)
+
+// exportCommand writes the contents of the datastore as JSON Lines to a file or stdout.
+func exportCommand(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	datadir := fs.String("datadir", ".", "dir for auxiliary data")
+	out := fs.String("out", "-", "export file (- for stdout)")
+	fs.Parse(args)
+
+	db, err := openDatastore(*datadir)
+	if err != nil {
+		log.Error("could not open datastore", "err", err)
+		return 1
+	}
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if *out != "-" {
+		f, err = os.Create(*out)
+		if err != nil {
+			log.Error("could not create export file", "err", err, "file", *out)
+			return 1
+		}
+		w = f
+	}
+
+	n, err := felix.Export(db, w)
+	if f != nil {
+		// Write errors may only be reported on close
+		if cerr := f.Close(); err == nil && cerr != nil {
+			log.Error("could not close export file", "err", cerr, "file", *out)
+			return 1
+		}
+	}
+	if err != nil {
+		log.Error("could not export datastore", "err", err)
+		return 1
+	}
+
+	log.Info("exported datastore", "records", n)
+	return 0
+}
+
+// importCommand reads JSON Lines in the format written by exportCommand into the datastore.
+func importCommand(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	datadir := fs.String("datadir", ".", "dir for auxiliary data")
+	modeName := fs.String("mode", "merge", "import mode: merge (keep existing entries) or replace (remove existing entries)")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: felix import [flags] [file]\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	mode, err := felix.ParseImportMode(*modeName)
+	if err != nil {
+		log.Error("invalid import mode", "err", err)
+		return 2
+	}
+
+	var r io.Reader = os.Stdin
+	if in := fs.Arg(0); in != "" && in != "-" {
+		f, err := os.Open(in)
+		if err != nil {
+			log.Error("could not open import file", "err", err, "file", in)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+
+	db, err := openDatastore(*datadir)
+	if err != nil {
+		log.Error("could not open datastore", "err", err)
+		return 1
+	}
+	defer db.Close()
+
+	stats, err := felix.Import(db, r, mode)
+	if err != nil {
+		log.Error("could not import datastore", "err", err)
+		return 1
+	}
+
+	log.Info("imported datastore", "mode", *modeName, "imported", stats.Imported, "skipped", stats.Skipped)
+	return 0
+}
+
+func openDatastore(datadir string) (felix.Datastore, error) {
+	return bolt.NewDatastore(filepath.Join(datadir, "felix.db"))
+}
@@ -0,0 +1,147 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AdminAuth only passes requests to h that contain the given token as "Authorization: Bearer <token>" header.
+// All other requests are rejected with 401 Unauthorized.
+func AdminAuth(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if token == "" || !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="felix"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// AdminFeedsHandler lists all feeds of the manager as JSON (GET) or adds a new feed (POST).
+// New feeds are defined by the "url", "type" (default "rss") and optional "fetchInterval" parameters.
+func AdminFeedsHandler(m *FeedManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, m.Feeds())
+			return
+		case http.MethodPost:
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		fc := FeedConfig{
+			Type: r.FormValue("type"),
+			URL:  r.FormValue("url"),
+		}
+
+		if fc.URL == "" {
+			http.Error(w, "missing url parameter", http.StatusBadRequest)
+			return
+		}
+		if fc.Type == "" {
+			fc.Type = "rss"
+		}
+		if v := r.FormValue("fetchInterval"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				http.Error(w, "invalid fetchInterval parameter", http.StatusBadRequest)
+				return
+			}
+			fc.FetchInterval = d
+		}
+
+		err := m.Add(fc)
+		switch {
+		case err == ErrFeedExists:
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Cause(err) == ErrInvalidFeed:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(fc)
+		}
+	})
+}
+
+// AdminFeedActionHandler calls action with the feed URL given by the "url" parameter,
+// e.g. FeedManager.Pause. Only POST requests are accepted.
+func AdminFeedActionHandler(action func(url string) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		url := r.FormValue("url")
+		if url == "" {
+			http.Error(w, "missing url parameter", http.StatusBadRequest)
+			return
+		}
+
+		switch err := action(url); err {
+		case nil:
+			w.WriteHeader(http.StatusNoContent)
+		case ErrNotFound:
+			http.Error(w, "feed not found", http.StatusNotFound)
+		case ErrFeedConfigured:
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// AdminFetchHandler triggers an immediate fetch of the running fetcher with the URL given by the "url" parameter.
+// The fetcher is looked up in all given registries. Only POST requests are accepted.
+// Triggered fetches do not count as attempts, e.g. of an item page fetcher (see Fetcher.Trigger).
+func AdminFetchHandler(registries ...*FetcherRegistry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		url := r.FormValue("url")
+		if url == "" {
+			http.Error(w, "missing url parameter", http.StatusBadRequest)
+			return
+		}
+
+		for _, registry := range registries {
+			f, ok := registry.Get(url)
+			if !ok {
+				continue
+			}
+
+			if !f.Trigger() {
+				http.Error(w, "fetch already pending", http.StatusConflict)
+				return
+			}
+
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		http.Error(w, "no running fetcher found", http.StatusNotFound)
+	})
+}
@@ -0,0 +1,169 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestAdminAuth(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		token  string
+		header string
+		status int
+	}{
+		{"valid token", "secret", "Bearer secret", http.StatusOK},
+		{"invalid token", "secret", "Bearer other", http.StatusUnauthorized},
+		{"missing header", "secret", "", http.StatusUnauthorized},
+		{"basic auth", "secret", "Basic c2VjcmV0", http.StatusUnauthorized},
+		{"no token configured", "", "Bearer ", http.StatusUnauthorized},
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin", nil)
+			if tC.header != "" {
+				req.Header.Set("Authorization", tC.header)
+			}
+			w := httptest.NewRecorder()
+
+			AdminAuth(tC.token, ok).ServeHTTP(w, req)
+
+			if w.Code != tC.status {
+				t.Errorf("unexpected status code. expected %v, got %v", tC.status, w.Code)
+			}
+		})
+	}
+}
+
+func TestAdminFeedsHandler(t *testing.T) {
+	ds := &mockDatastore{}
+	m := NewFeedManager(ds, NewFetcherRegistry(), newTestFeedFetcher)
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer close(quit)
+
+	if err := m.Start([]FeedConfig{{Type: "rss", URL: "http://example.com/a"}}, quit, &wg); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	testCases := []struct {
+		desc   string
+		method string
+		target string
+		status int
+	}{
+		{"list", "GET", "/admin/feeds", http.StatusOK},
+		{"add", "POST", "/admin/feeds?url=http://example.com/b&fetchInterval=30m", http.StatusCreated},
+		{"add existing", "POST", "/admin/feeds?url=http://example.com/a", http.StatusConflict},
+		{"add unknown type", "POST", "/admin/feeds?url=http://example.com/c&type=foo", http.StatusBadRequest},
+		{"add invalid interval", "POST", "/admin/feeds?url=http://example.com/c&fetchInterval=-1h", http.StatusBadRequest},
+		{"add without url", "POST", "/admin/feeds", http.StatusBadRequest},
+		{"wrong method", "DELETE", "/admin/feeds", http.StatusMethodNotAllowed},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tC.method, tC.target, nil)
+			w := httptest.NewRecorder()
+
+			AdminFeedsHandler(m).ServeHTTP(w, req)
+
+			if w.Code != tC.status {
+				t.Errorf("unexpected status code. expected %v, got %v", tC.status, w.Code)
+			}
+		})
+	}
+
+	if fc := ds.feeds["http://example.com/b"]; fc.Type != "rss" || fc.FetchInterval.Minutes() != 30 {
+		t.Errorf("added feed was not stored properly, got %+v", fc)
+	}
+}
+
+func TestAdminFeedActionHandler(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		method string
+		target string
+		err    error
+		status int
+	}{
+		{"success", "POST", "/admin/feeds/pause?url=feed", nil, http.StatusNoContent},
+		{"not found", "POST", "/admin/feeds/pause?url=feed", ErrNotFound, http.StatusNotFound},
+		{"configured", "POST", "/admin/feeds/remove?url=feed", ErrFeedConfigured, http.StatusConflict},
+		{"other error", "POST", "/admin/feeds/pause?url=feed", errors.New("error"), http.StatusInternalServerError},
+		{"missing url", "POST", "/admin/feeds/pause", nil, http.StatusBadRequest},
+		{"wrong method", "GET", "/admin/feeds/pause?url=feed", nil, http.StatusMethodNotAllowed},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			var called string
+			action := func(url string) error {
+				called = url
+				return tC.err
+			}
+
+			req := httptest.NewRequest(tC.method, tC.target, nil)
+			w := httptest.NewRecorder()
+
+			AdminFeedActionHandler(action).ServeHTTP(w, req)
+
+			if w.Code != tC.status {
+				t.Errorf("unexpected status code. expected %v, got %v", tC.status, w.Code)
+			}
+
+			if tC.status != http.StatusBadRequest && tC.status != http.StatusMethodNotAllowed && called != "feed" {
+				t.Errorf("action was not called with the feed url, got %q", called)
+			}
+		})
+	}
+}
+
+func TestAdminFetchHandler(t *testing.T) {
+	feeds := NewFetcherRegistry()
+	pages := NewFetcherRegistry()
+
+	// The fetcher is not started, so the triggered fetch stays pending
+	f := NewFetcher("http://example.com/page", nil, nil, nil, nil, nil)
+	pages.mu.Lock()
+	pages.fetchers[f.URL()] = f
+	pages.mu.Unlock()
+
+	testCases := []struct {
+		desc   string
+		method string
+		target string
+		status int
+	}{
+		{"trigger", "POST", "/admin/fetch?url=http://example.com/page", http.StatusAccepted},
+		{"already pending", "POST", "/admin/fetch?url=http://example.com/page", http.StatusConflict},
+		{"unknown fetcher", "POST", "/admin/fetch?url=http://example.com/other", http.StatusNotFound},
+		{"missing url", "POST", "/admin/fetch", http.StatusBadRequest},
+		{"wrong method", "GET", "/admin/fetch?url=http://example.com/page", http.StatusMethodNotAllowed},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tC.method, tC.target, nil)
+			w := httptest.NewRecorder()
+
+			AdminFetchHandler(feeds, pages).ServeHTTP(w, req)
+
+			if w.Code != tC.status {
+				t.Errorf("unexpected status code. expected %v, got %v", tC.status, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,522 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Default and maximum page sizes of the API.
+const (
+	DefaultAPILimit = 50
+	MaxAPILimit     = 500
+)
+
+// apiPage is a single page of a paginated API result.
+type apiPage struct {
+	Total  int         `json:"total"`
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+	Data   interface{} `json:"data"`
+}
+
+// apiQuery contains the common query parameters of the API list endpoints.
+type apiQuery struct {
+	since  time.Time
+	until  time.Time
+	host   string
+	search string
+	offset int
+	limit  int
+}
+
+// parseAPIQuery parses the common query parameters of the request:
+// "since" and "until" (RFC 3339 timestamps or durations relative to now, e.g. "6h"),
+// "host", "q" (case insensitive search in title and URL), "offset" and "limit".
+func parseAPIQuery(r *http.Request) (apiQuery, error) {
+	q := apiQuery{
+		host:   strings.ToLower(r.FormValue("host")),
+		search: strings.ToLower(r.FormValue("q")),
+		limit:  DefaultAPILimit,
+	}
+
+	var err error
+	if q.since, err = parseAPITime(r.FormValue("since")); err != nil {
+		return q, errors.Wrap(err, "invalid since parameter")
+	}
+	if q.until, err = parseAPITime(r.FormValue("until")); err != nil {
+		return q, errors.Wrap(err, "invalid until parameter")
+	}
+
+	if v := r.FormValue("offset"); v != "" {
+		if q.offset, err = strconv.Atoi(v); err != nil || q.offset < 0 {
+			return q, errors.Errorf("invalid offset parameter %q", v)
+		}
+	}
+	if v := r.FormValue("limit"); v != "" {
+		if q.limit, err = strconv.Atoi(v); err != nil || q.limit <= 0 {
+			return q, errors.Errorf("invalid limit parameter %q", v)
+		}
+		if q.limit > MaxAPILimit {
+			q.limit = MaxAPILimit
+		}
+	}
+
+	return q, nil
+}
+
+// parseAPITime parses an RFC 3339 timestamp or a duration relative to now.
+func parseAPITime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// maxAge returns the maximum age of datastore entries needed to answer the query.
+func (q apiQuery) maxAge(def time.Duration) time.Duration {
+	if q.since.IsZero() {
+		return def
+	}
+	return time.Since(q.since)
+}
+
+// match reports whether an entry with the given values matches the query.
+func (q apiQuery) match(title, rawurl string, added time.Time) bool {
+	if !q.since.IsZero() && added.Before(q.since) {
+		return false
+	}
+	if !q.until.IsZero() && added.After(q.until) {
+		return false
+	}
+	if q.host != "" && !matchHost(rawurl, q.host) {
+		return false
+	}
+	if q.search != "" && !strings.Contains(strings.ToLower(title), q.search) && !strings.Contains(strings.ToLower(rawurl), q.search) {
+		return false
+	}
+	return true
+}
+
+// page returns the requested page of n entries as slice bounds.
+func (q apiQuery) page(n int) (int, int) {
+	start := q.offset
+	if start > n {
+		start = n
+	}
+	end := start + q.limit
+	if end > n {
+		end = n
+	}
+	return start, end
+}
+
+// matchHost reports whether the host of rawurl is host or one of its subdomains.
+func matchHost(rawurl, host string) bool {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return false
+	}
+	h := strings.ToLower(u.Hostname())
+	return h == host || strings.HasSuffix(h, "."+host)
+}
+
+// APILinksHandler serves the stored links (newest first) as paginated JSON.
+// Besides the common query parameters (see parseAPIQuery), links can be filtered by "status".
+// maxAge is the maximum age of the returned links if no "since" parameter is given.
+func APILinksHandler(ds Datastore, maxAge time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseAPIQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var status LinkStatus
+		if v := r.FormValue("status"); v != "" {
+			if status, err = ParseLinkStatus(v); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		links, err := ds.GetLinks(q.maxAge(maxAge))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		matched := make([]Link, 0, len(links))
+		for _, link := range links {
+			if status != "" && link.Status != status {
+				continue
+			}
+			if q.match(link.Title, link.URL, link.Added) {
+				matched = append(matched, link)
+			}
+		}
+
+		sort.SliceStable(matched, func(i, j int) bool { return matched[i].Added.After(matched[j].Added) })
+
+		start, end := q.page(len(matched))
+		writeJSON(w, apiPage{Total: len(matched), Offset: q.offset, Limit: q.limit, Data: matched[start:end]})
+	})
+}
+
+// APIItemsHandler serves the stored items (newest first) as paginated JSON.
+// Besides the common query parameters (see parseAPIQuery), items can be filtered by fetcher "state".
+// maxAge is the maximum age of the returned items if no "since" parameter is given.
+func APIItemsHandler(ds Datastore, maxAge time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseAPIQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		state := FetcherState(r.FormValue("state"))
+
+		items, err := ds.GetItems(q.maxAge(maxAge))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		matched := make([]Item, 0, len(items))
+		for _, item := range items {
+			if state != "" && item.State != state {
+				continue
+			}
+			if q.match(item.Title, item.URL, item.Added) {
+				matched = append(matched, item)
+			}
+		}
+
+		sort.SliceStable(matched, func(i, j int) bool { return matched[i].Added.After(matched[j].Added) })
+
+		start, end := q.page(len(matched))
+		writeJSON(w, apiPage{Total: len(matched), Offset: q.offset, Limit: q.limit, Data: matched[start:end]})
+	})
+}
+
+// apiFeed is the configuration and fetch status of a single feed, as served by APIFeedsHandler.
+type apiFeed struct {
+	URL         string        `json:"url"`
+	Type        string        `json:"type,omitempty"`
+	Paused      bool          `json:"paused"`
+	Running     bool          `json:"running"`
+	NextAttempt *time.Time    `json:"nextAttempt,omitempty"`
+	LastSuccess *time.Time    `json:"lastSuccess,omitempty"`
+	LastResult  *FetchResult  `json:"lastResult,omitempty"`
+	History     []FetchResult `json:"history,omitempty"`
+}
+
+// APIFeedsHandler serves the given feeds with their current fetch status as JSON.
+// If the "url" parameter is set, the status with the complete fetch history of only this URL
+// (a feed or an item page) is served instead.
+func APIFeedsHandler(ds Datastore, feeds FeedLister, registry *FetcherRegistry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fcs := feeds.Feeds()
+
+		if url := r.FormValue("url"); url != "" {
+			fc := FeedConfig{URL: url}
+			for _, c := range fcs {
+				if c.URL == url {
+					fc = c
+				}
+			}
+
+			feed, err := newAPIFeed(ds, fc, registry)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, feed)
+			return
+		}
+
+		result := make([]apiFeed, 0, len(fcs))
+		for _, fc := range fcs {
+			feed, err := newAPIFeed(ds, fc, registry)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			feed.History = nil
+			result = append(result, feed)
+		}
+
+		writeJSON(w, result)
+	})
+}
+
+// newAPIFeed returns the status of the feed, including its complete fetch history.
+func newAPIFeed(ds Datastore, fc FeedConfig, registry *FetcherRegistry) (apiFeed, error) {
+	history, err := ds.FetchHistory(fc.URL)
+	if err != nil {
+		return apiFeed{}, err
+	}
+
+	status := newFetchStatus(fc.URL, history)
+	feed := apiFeed{
+		URL:         fc.URL,
+		Type:        fc.Type,
+		Paused:      fc.Paused,
+		LastSuccess: status.LastSuccess,
+		LastResult:  status.LastResult,
+		History:     history,
+	}
+
+	if f, ok := registry.Get(fc.URL); ok {
+		feed.Running = true
+		feed.NextAttempt = timeOrNil(f.NextAttempt())
+	}
+
+	return feed, nil
+}
+
+// apiFetcher is the state of a single item page fetcher, as served by APIFetchersHandler.
+type apiFetcher struct {
+	URL         string       `json:"url"`
+	Title       string       `json:"title"`
+	State       FetcherState `json:"state"`
+	Running     bool         `json:"running"`
+	Attempts    int          `json:"attempts"`
+	LastAttempt *time.Time   `json:"lastAttempt,omitempty"`
+	NextAttempt *time.Time   `json:"nextAttempt,omitempty"`
+}
+
+// APIFetchersHandler serves the page fetchers of the stored items (newest first) as paginated JSON,
+// with their attempts and, if they are running in the registry, their next attempt.
+// Besides the common query parameters (see parseAPIQuery), fetchers can be filtered by "state", which defaults
+// to active fetchers; "state=all" includes exhausted and failed ones.
+// maxAge is the maximum age of the items if no "since" parameter is given.
+func APIFetchersHandler(ds Datastore, registry *FetcherRegistry, maxAge time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseAPIQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		state := FetcherState(r.FormValue("state"))
+		switch state {
+		case "":
+			state = FetcherActive
+		case "all":
+			state = ""
+		}
+
+		items, err := ds.GetItems(q.maxAge(maxAge))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		matched := make([]Item, 0, len(items))
+		for _, item := range items {
+			if state != "" && item.State != state {
+				continue
+			}
+			if q.match(item.Title, item.URL, item.Added) {
+				matched = append(matched, item)
+			}
+		}
+
+		sort.SliceStable(matched, func(i, j int) bool { return matched[i].Added.After(matched[j].Added) })
+
+		start, end := q.page(len(matched))
+		fetchers := make([]apiFetcher, 0, end-start)
+		for _, item := range matched[start:end] {
+			last, attempts, err := ds.LastAttempt(item.URL)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			fetcher := apiFetcher{
+				URL:         item.URL,
+				Title:       item.Title,
+				State:       item.State,
+				Attempts:    attempts,
+				LastAttempt: timeOrNil(last),
+			}
+			if f, ok := registry.Get(item.URL); ok {
+				fetcher.Running = true
+				fetcher.NextAttempt = timeOrNil(f.NextAttempt())
+			}
+
+			fetchers = append(fetchers, fetcher)
+		}
+
+		writeJSON(w, apiPage{Total: len(matched), Offset: q.offset, Limit: q.limit, Data: fetchers})
+	})
+}
+
+// maxTraceBody is the maximum size of the entries posted to APITraceHandler.
+const maxTraceBody = 1 << 20
+
+// APITraceHandler replays entries through new filter chains and serves their traces
+// (see TraceLinks and TraceItems) as paginated JSON. The filters are created by newFilters
+// for every request, so the state of the running filters (e.g. of the duplicates filter) is not changed.
+//
+// GET replays the stored links (newest first), or the stored items with "type=items", that match the
+// common query parameters (see parseAPIQuery) and the optional "url". POST traces the JSON array of
+// links (or items) in the request body instead. With "dropped=true", only the traces of dropped entries
+// are returned. maxAge is the maximum age of the replayed entries if no "since" parameter is given.
+// The entries are traced in order up to the end of the requested page (all entries if only dropped entries
+// are requested, to count them), so stateful filters see the same preceding entries regardless of the page.
+// newFilters is called once per request, so stateful filters start empty; it should leave out filters
+// that fetch remote pages.
+func APITraceHandler(ds Datastore, maxAge time.Duration, newFilters func() ([]ItemFilter, []LinkFilter)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		q, err := parseAPIQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var onlyDropped bool
+		if v := r.FormValue("dropped"); v != "" {
+			if onlyDropped, err = strconv.ParseBool(v); err != nil {
+				http.Error(w, fmt.Sprintf("invalid dropped parameter %q", v), http.StatusBadRequest)
+				return
+			}
+		}
+
+		rawurl := r.FormValue("url")
+		match := func(title, u string, added time.Time) bool {
+			return (rawurl == "" || u == rawurl) && q.match(title, u, added)
+		}
+
+		var page apiPage
+		switch typ := r.FormValue("type"); typ {
+		case "", "links":
+			var links []Link
+			if r.Method == http.MethodPost {
+				if err := decodeTraceBody(r, &links); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			} else {
+				stored, err := ds.GetLinks(q.maxAge(maxAge))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				for _, link := range stored {
+					if match(link.Title, link.URL, link.Added) {
+						links = append(links, link)
+					}
+				}
+				sort.SliceStable(links, func(i, j int) bool { return links[i].Added.After(links[j].Added) })
+			}
+
+			_, filters := newFilters()
+			page = tracePage(len(links), q, onlyDropped, func(i int) (interface{}, bool) {
+				t := TraceLinks(links[i:i+1], filters...)[0]
+				return t, t.Dropped()
+			})
+
+		case "items":
+			var items []Item
+			if r.Method == http.MethodPost {
+				if err := decodeTraceBody(r, &items); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			} else {
+				stored, err := ds.GetItems(q.maxAge(maxAge))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				for _, item := range stored {
+					if match(item.Title, item.URL, item.Added) {
+						items = append(items, item)
+					}
+				}
+				sort.SliceStable(items, func(i, j int) bool { return items[i].Added.After(items[j].Added) })
+			}
+
+			filters, _ := newFilters()
+			page = tracePage(len(items), q, onlyDropped, func(i int) (interface{}, bool) {
+				t := TraceItems(items[i:i+1], filters...)[0]
+				return t, t.Dropped()
+			})
+
+		default:
+			http.Error(w, fmt.Sprintf("invalid type parameter %q", typ), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, page)
+	})
+}
+
+// tracePage returns the requested page of the traces of n entries. trace traces the i-th entry and reports
+// whether it was dropped. The entries before the page are traced as well, so that stateful filters see them.
+// If onlyDropped is set, the page only contains dropped entries.
+func tracePage(n int, q apiQuery, onlyDropped bool, trace func(i int) (interface{}, bool)) apiPage {
+	data := []interface{}{}
+
+	if !onlyDropped {
+		start, end := q.page(n)
+		for i := 0; i < end; i++ {
+			t, _ := trace(i)
+			if i >= start {
+				data = append(data, t)
+			}
+		}
+		return apiPage{Total: n, Offset: q.offset, Limit: q.limit, Data: data}
+	}
+
+	var total int
+	for i := 0; i < n; i++ {
+		t, dropped := trace(i)
+		if !dropped {
+			continue
+		}
+		if total >= q.offset && total < q.offset+q.limit {
+			data = append(data, t)
+		}
+		total++
+	}
+	return apiPage{Total: total, Offset: q.offset, Limit: q.limit, Data: data}
+}
+
+// decodeTraceBody decodes the JSON array of entries posted to APITraceHandler into v.
+func decodeTraceBody(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxTraceBody)).Decode(v); err != nil {
+		return errors.Wrap(err, "could not decode entries")
+	}
+	return nil
+}
+
+// timeOrNil returns a pointer to t, or nil if t is the zero time.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
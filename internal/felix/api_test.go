@@ -0,0 +1,331 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPILinksHandler(t *testing.T) {
+	now := time.Now()
+	ds := &mockDatastore{links: []Link{
+		{Title: "Show.S01E01.720p", URL: "http://example.com/1", Added: now.Add(-3 * time.Hour), Status: LinkDownloaded},
+		{Title: "Show.S01E02.1080p", URL: "http://dl.example.com/2", Added: now.Add(-2 * time.Hour), Status: LinkNew},
+		{Title: "Other.1080p", URL: "http://example.org/3", Added: now.Add(-1 * time.Hour), Status: LinkNew},
+	}}
+
+	testCases := []struct {
+		desc     string
+		query    string
+		status   int
+		total    int
+		expected []string
+	}{
+		{"all links newest first", "", http.StatusOK, 3, []string{"http://example.org/3", "http://dl.example.com/2", "http://example.com/1"}},
+		{"host with subdomains", "?host=example.com", http.StatusOK, 2, []string{"http://dl.example.com/2", "http://example.com/1"}},
+		{"search", "?q=1080P", http.StatusOK, 2, []string{"http://example.org/3", "http://dl.example.com/2"}},
+		{"status", "?status=downloaded", http.StatusOK, 1, []string{"http://example.com/1"}},
+		{"since duration", "?since=90m", http.StatusOK, 1, []string{"http://example.org/3"}},
+		{"until timestamp", "?until=" + now.Add(-150*time.Minute).Format(time.RFC3339), http.StatusOK, 1, []string{"http://example.com/1"}},
+		{"paging", "?offset=1&limit=1", http.StatusOK, 3, []string{"http://dl.example.com/2"}},
+		{"offset beyond total", "?offset=10", http.StatusOK, 3, []string{}},
+		{"invalid status", "?status=foo", http.StatusBadRequest, 0, nil},
+		{"invalid since", "?since=yesterday", http.StatusBadRequest, 0, nil},
+		{"invalid limit", "?limit=-1", http.StatusBadRequest, 0, nil},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/links"+tC.query, nil)
+			w := httptest.NewRecorder()
+
+			APILinksHandler(ds, 24*time.Hour).ServeHTTP(w, req)
+
+			if w.Code != tC.status {
+				t.Fatalf("unexpected status code. expected %v, got %v", tC.status, w.Code)
+			}
+			if tC.status != http.StatusOK {
+				return
+			}
+
+			var page struct {
+				Total int    `json:"total"`
+				Data  []Link `json:"data"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+				t.Fatal("could not decode response:", err)
+			}
+
+			urls := []string{}
+			for _, link := range page.Data {
+				urls = append(urls, link.URL)
+			}
+
+			if page.Total != tC.total || !reflect.DeepEqual(urls, tC.expected) {
+				t.Errorf("unexpected links. expected %v (%d), got %v (%d)", tC.expected, tC.total, urls, page.Total)
+			}
+		})
+	}
+}
+
+func TestAPIItemsHandler(t *testing.T) {
+	now := time.Now()
+	ds := &mockDatastore{items: []Item{
+		{Title: "item1", URL: "http://example.com/1", Added: now.Add(-1 * time.Hour), State: FetcherActive},
+		{Title: "item2", URL: "http://example.com/2", Added: now, State: FetcherExhausted},
+	}}
+
+	testCases := []struct {
+		desc     string
+		query    string
+		expected []string
+	}{
+		{"all items newest first", "", []string{"item2", "item1"}},
+		{"state", "?state=active", []string{"item1"}},
+		{"search", "?q=item2", []string{"item2"}},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/items"+tC.query, nil)
+			w := httptest.NewRecorder()
+
+			APIItemsHandler(ds, 24*time.Hour).ServeHTTP(w, req)
+
+			var page struct {
+				Data []Item `json:"data"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+				t.Fatal("could not decode response:", err)
+			}
+
+			var titles []string
+			for _, item := range page.Data {
+				titles = append(titles, item.Title)
+			}
+
+			if !reflect.DeepEqual(titles, tC.expected) {
+				t.Errorf("unexpected items. expected %v, got %v", tC.expected, titles)
+			}
+		})
+	}
+}
+
+func TestAPIFeedsHandler(t *testing.T) {
+	start := time.Now()
+	ds := &mockDatastore{history: map[string][]FetchResult{
+		"http://example.com/feed": {{Start: start, Status: http.StatusOK}},
+	}}
+	feeds := FeedList{
+		{Type: "rss", URL: "http://example.com/feed"},
+		{Type: "rss", URL: "http://example.org/feed", Paused: true},
+	}
+
+	registry := NewFetcherRegistry()
+	stop := startRegisteredFetcher(t, registry, "http://example.com/feed")
+	defer stop()
+
+	req := httptest.NewRequest("GET", "/api/feeds", nil)
+	w := httptest.NewRecorder()
+
+	APIFeedsHandler(ds, feeds, registry).ServeHTTP(w, req)
+
+	var result []apiFeed
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal("could not decode response:", err)
+	}
+
+	if len(result) != 2 {
+		t.Fatalf("unexpected number of feeds. expected %d, got %d", 2, len(result))
+	}
+
+	if !result[0].Running || result[0].NextAttempt == nil || result[0].LastSuccess == nil || result[0].LastResult.Status != http.StatusOK {
+		t.Errorf("unexpected status of running feed: %+v", result[0])
+	}
+
+	if !result[1].Paused || result[1].Running || result[1].NextAttempt != nil || result[1].LastResult != nil {
+		t.Errorf("unexpected status of stopped feed: %+v", result[1])
+	}
+
+	if result[0].History != nil {
+		t.Errorf("fetch history should only be served for a single url, got %v", result[0].History)
+	}
+
+	req = httptest.NewRequest("GET", "/api/feeds?url="+url.QueryEscape("http://example.com/feed"), nil)
+	w = httptest.NewRecorder()
+
+	APIFeedsHandler(ds, feeds, registry).ServeHTTP(w, req)
+
+	var feed apiFeed
+	if err := json.NewDecoder(w.Body).Decode(&feed); err != nil {
+		t.Fatal("could not decode response:", err)
+	}
+
+	if feed.Type != "rss" || !feed.Running || len(feed.History) != 1 {
+		t.Errorf("unexpected status of single url: %+v", feed)
+	}
+}
+
+func TestAPIFetchersHandler(t *testing.T) {
+	now := time.Now()
+	ds := &mockDatastore{items: []Item{
+		{Title: "active", URL: "http://example.com/1", State: FetcherActive, Added: now},
+		{Title: "active", URL: "http://example.com/2", State: FetcherActive, Added: now.Add(-1 * time.Minute)},
+		{Title: "active", URL: "http://example.org/3", State: FetcherActive, Added: now.Add(-2 * time.Minute)},
+		{Title: "failed", URL: "http://example.org/4", State: FetcherFailed, Added: now.Add(-3 * time.Minute)},
+	}}
+
+	registry := NewFetcherRegistry()
+	for _, url := range []string{"http://example.com/1", "http://example.com/2", "http://example.org/3"} {
+		stop := startRegisteredFetcher(t, registry, url)
+		defer stop()
+	}
+
+	testCases := []struct {
+		desc     string
+		query    string
+		total    int
+		expected []string
+	}{
+		{"active fetchers", "", 3, []string{"http://example.com/1", "http://example.com/2", "http://example.org/3"}},
+		{"all fetchers", "?state=all", 4, []string{"http://example.com/1", "http://example.com/2", "http://example.org/3", "http://example.org/4 (stopped)"}},
+		{"host", "?host=example.org&state=all", 2, []string{"http://example.org/3", "http://example.org/4 (stopped)"}},
+		{"state", "?state=failed", 1, []string{"http://example.org/4 (stopped)"}},
+		{"unknown state", "?state=foo", 0, nil},
+		{"paging", "?limit=1&offset=1", 3, []string{"http://example.com/2"}},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/fetchers"+tC.query, nil)
+			w := httptest.NewRecorder()
+
+			APIFetchersHandler(ds, registry, 0).ServeHTTP(w, req)
+
+			var page struct {
+				Total int          `json:"total"`
+				Data  []apiFetcher `json:"data"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+				t.Fatal("could not decode response:", err)
+			}
+
+			var urls []string
+			for _, f := range page.Data {
+				if f.Running != (f.NextAttempt != nil) {
+					t.Errorf("next attempt should only be set for running fetchers: %+v", f)
+				}
+				if !f.Running {
+					f.URL += " (stopped)"
+				}
+				urls = append(urls, f.URL)
+			}
+
+			if page.Total != tC.total || !reflect.DeepEqual(urls, tC.expected) {
+				t.Errorf("unexpected fetchers. expected %v (%d), got %v (%d)", tC.expected, tC.total, urls, page.Total)
+			}
+		})
+	}
+}
+
+func TestAPITraceHandler(t *testing.T) {
+	now := time.Now()
+	ds := &mockDatastore{
+		links: []Link{
+			{URL: "http://example.com/1", Added: now.Add(-2 * time.Hour)},
+			{URL: "http://example.org/2", Added: now.Add(-1 * time.Hour)},
+		},
+		items: []Item{
+			{Title: "Show S01E01", URL: "http://example.com/item1", Added: now},
+			{Title: "Other", URL: "http://example.com/item2", Added: now},
+		},
+	}
+	var traced int
+	newFilters := func() ([]ItemFilter, []LinkFilter) {
+		count := LinkFilterFunc(func(link Link, next func(Link)) {
+			traced++
+			next(link)
+		})
+		return []ItemFilter{NamedItemFilter("title", ItemTitleFilter("show"))},
+			[]LinkFilter{count, NamedLinkFilter("duplicates", LinkDuplicatesFilter(10)), NamedLinkFilter("domain", LinkDomainFilter("example.com"))}
+	}
+
+	testCases := []struct {
+		desc     string
+		method   string
+		query    string
+		body     string
+		status   int
+		total    int
+		traced   int
+		expected []string
+	}{
+		{"links", "GET", "", "", http.StatusOK, 2, 2, []string{"http://example.org/2 dropped by domain", "http://example.com/1"}},
+		{"links again", "GET", "", "", http.StatusOK, 2, 2, []string{"http://example.org/2 dropped by domain", "http://example.com/1"}},
+		{"page", "GET", "?offset=1&limit=1", "", http.StatusOK, 2, 2, []string{"http://example.com/1"}},
+		{"first page", "GET", "?limit=1", "", http.StatusOK, 2, 1, []string{"http://example.org/2 dropped by domain"}},
+		{"only dropped", "GET", "?dropped=true", "", http.StatusOK, 1, 2, []string{"http://example.org/2 dropped by domain"}},
+		{"url", "GET", "?url=http://example.com/1", "", http.StatusOK, 1, 1, []string{"http://example.com/1"}},
+		{"items", "GET", "?type=items&dropped=true", "", http.StatusOK, 1, 0, []string{"http://example.com/item2 dropped by title"}},
+		{"posted links", "POST", "", `[{"url":"http://example.com/a"},{"url":"http://example.com/a"}]`, http.StatusOK, 2, 2, []string{"http://example.com/a", "http://example.com/a dropped by duplicates"}},
+		{"posted links page", "POST", "?offset=1&limit=1", `[{"url":"http://example.com/a"},{"url":"http://example.com/a"}]`, http.StatusOK, 2, 2, []string{"http://example.com/a dropped by duplicates"}},
+		{"invalid body", "POST", "", `{`, http.StatusBadRequest, 0, 0, nil},
+		{"invalid type", "GET", "?type=feeds", "", http.StatusBadRequest, 0, 0, nil},
+		{"invalid dropped", "GET", "?dropped=maybe", "", http.StatusBadRequest, 0, 0, nil},
+		{"invalid method", "DELETE", "", "", http.StatusMethodNotAllowed, 0, 0, nil},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tC.method, "/api/trace"+tC.query, strings.NewReader(tC.body))
+			w := httptest.NewRecorder()
+			traced = 0
+
+			APITraceHandler(ds, 24*time.Hour, newFilters).ServeHTTP(w, req)
+
+			if w.Code != tC.status {
+				t.Fatalf("unexpected status code. expected %v, got %v", tC.status, w.Code)
+			}
+			if tC.status != http.StatusOK {
+				return
+			}
+
+			var page struct {
+				Total int `json:"total"`
+				Data  []struct {
+					Item      Item   `json:"item"`
+					Link      Link   `json:"link"`
+					DroppedBy string `json:"droppedBy"`
+				} `json:"data"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+				t.Fatal("could not decode response:", err)
+			}
+
+			var got []string
+			for _, trace := range page.Data {
+				s := trace.Link.URL + trace.Item.URL
+				if trace.DroppedBy != "" {
+					s += " dropped by " + trace.DroppedBy
+				}
+				got = append(got, s)
+			}
+
+			if page.Total != tC.total || !reflect.DeepEqual(got, tC.expected) {
+				t.Errorf("unexpected traces. expected %v (%d), got %v (%d)", tC.expected, tC.total, got, page.Total)
+			}
+			if traced != tC.traced {
+				t.Errorf("unexpected number of traced links. expected %v, got %v", tC.traced, traced)
+			}
+		})
+	}
+}
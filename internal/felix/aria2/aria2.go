@@ -0,0 +1,228 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package aria2 implements a felix.LinkSink that submits new links to an aria2 download daemon via JSON-RPC.
+//
+// See https://aria2.github.io/manual/en/html/aria2c.html#rpc-interface
+package aria2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/martinplaner/felix/internal/felix"
+	"github.com/pkg/errors"
+)
+
+// Default values of the aria2 configuration.
+const (
+	DefaultURL     = "http://localhost:6800/jsonrpc"
+	DefaultTimeout = 10 * time.Second
+)
+
+// Config contains the configuration of an aria2 sink.
+type Config struct {
+	// URL of the JSON-RPC interface, defaults to DefaultURL.
+	URL string `yaml:"url"`
+	// Secret is the RPC secret token of the daemon (--rpc-secret).
+	Secret string `yaml:"secret"`
+	// Dir is the download directory. The daemon's default is used if it is empty.
+	Dir string `yaml:"dir"`
+	// Options are passed to aria2.addUri, e.g. "max-connection-per-server".
+	Options map[string]string `yaml:"options"`
+	// Outputs override the download directory and options for links that pass the filters of an output feed.
+	// The first matching output is used.
+	Outputs []OutputConfig `yaml:"outputs"`
+	Timeout time.Duration  `yaml:"timeout"`
+}
+
+// OutputConfig contains the download directory and options for the links of an output feed.
+type OutputConfig struct {
+	// Name of the output feed.
+	Name    string            `yaml:"name"`
+	Dir     string            `yaml:"dir"`
+	Options map[string]string `yaml:"options"`
+}
+
+// Sink submits links to aria2.
+type Sink struct {
+	name    string
+	config  Config
+	client  *http.Client
+	ids     felix.SinkIDStore
+	filters map[string][]felix.LinkFilter
+}
+
+var _ felix.LinkSink = new(Sink)
+
+// New creates a new aria2 sink with the given name. The GIDs of all submitted links are stored in ids
+// under the sink name, so every link is only submitted once. A nil client will default to http.DefaultClient.
+func New(name string, config Config, ids felix.SinkIDStore, client *http.Client) *Sink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if config.URL == "" {
+		config.URL = DefaultURL
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	return &Sink{
+		name:    name,
+		config:  config,
+		client:  client,
+		ids:     ids,
+		filters: make(map[string][]felix.LinkFilter),
+	}
+}
+
+// SetOutputFilters sets the link filters of the output feed with the given name.
+// Outputs without filters match all links.
+func (s *Sink) SetOutputFilters(name string, filters ...felix.LinkFilter) {
+	s.filters[name] = filters
+}
+
+// Send submits the link via aria2.addUri, unless it was already submitted.
+// Errors returned by the daemon are reported as felix.PermanentError,
+// while links are retried if the daemon is not reachable.
+func (s *Sink) Send(ctx context.Context, link felix.Link) error {
+	gid, err := s.ids.SinkID(s.name, link.URL)
+	if err == nil {
+		// Already submitted
+		return nil
+	}
+	if err != felix.ErrNotFound {
+		return errors.Wrap(err, "could not get stored gid")
+	}
+
+	var params []interface{}
+	if s.config.Secret != "" {
+		params = append(params, "token:"+s.config.Secret)
+	}
+	params = append(params, []string{link.URL}, s.options(link))
+
+	if err := s.call(ctx, "aria2.addUri", params, &gid); err != nil {
+		return err
+	}
+
+	if err := s.ids.StoreSinkID(s.name, link.URL, gid); err != nil {
+		// The download was added anyway, so this is not worth a retry
+		return felix.Permanent(errors.Wrapf(err, "could not store gid %s", gid))
+	}
+
+	return nil
+}
+
+// options returns the aria2 options of the link, including the download directory.
+func (s *Sink) options(link felix.Link) map[string]string {
+	dir, options := s.config.Dir, s.config.Options
+
+	for _, o := range s.config.Outputs {
+		if len(felix.FilterLinkSlice([]felix.Link{link}, s.filters[o.Name]...)) == 0 {
+			continue
+		}
+		if o.Dir != "" {
+			dir = o.Dir
+		}
+		options = merge(options, o.Options)
+		break
+	}
+
+	options = merge(options, nil)
+	if dir != "" {
+		options["dir"] = dir
+	}
+	return options
+}
+
+// merge returns a new map with all entries of a, overridden by the entries of b.
+func merge(a, b map[string]string) map[string]string {
+	m := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		m[k] = v
+	}
+	for k, v := range b {
+		m[k] = v
+	}
+	return m
+}
+
+type request struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      string        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
+}
+
+// RPCError is an error returned by the aria2 daemon.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("aria2 error %d: %s", e.Code, e.Message)
+}
+
+// invalidURI reports whether aria2 rejected the URI of the link itself.
+// Retrying such a link will never succeed.
+func (e *RPCError) invalidURI() bool {
+	return strings.HasPrefix(e.Message, "Unrecognized URI") || strings.HasPrefix(e.Message, "No URI to download")
+}
+
+// call invokes the JSON-RPC method and decodes the result into v.
+func (s *Sink) call(ctx context.Context, method string, params []interface{}, v interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	body, err := json.Marshal(request{JSONRPC: "2.0", ID: "felix", Method: method, Params: params})
+	if err != nil {
+		return felix.Permanent(errors.Wrap(err, "could not encode request"))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return felix.Permanent(errors.Wrap(err, "could not create request"))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "could not reach aria2")
+	}
+	defer resp.Body.Close()
+
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return &felix.StatusError{Code: resp.StatusCode}
+		}
+		return errors.Wrap(err, "could not decode response")
+	}
+
+	if r.Error != nil {
+		// Other errors (e.g. "Unauthorized" after a changed secret) can be fixed on the daemon
+		if r.Error.invalidURI() {
+			return felix.Permanent(r.Error)
+		}
+		return r.Error
+	}
+
+	if err := json.Unmarshal(r.Result, v); err != nil {
+		return errors.Wrap(err, "could not decode result")
+	}
+
+	return nil
+}
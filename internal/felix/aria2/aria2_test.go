@@ -0,0 +1,169 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aria2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/martinplaner/felix/internal/felix"
+)
+
+func TestSink_Send(t *testing.T) {
+	rpc := &fakeRPC{secret: "secret"}
+	server := httptest.NewServer(rpc)
+	defer server.Close()
+
+	ids := mapIDStore{}
+	sink := New("aria2", Config{
+		URL:     server.URL,
+		Secret:  "secret",
+		Dir:     "/downloads",
+		Options: map[string]string{"split": "4"},
+		Outputs: []OutputConfig{
+			{Name: "videos", Dir: "/downloads/videos", Options: map[string]string{"split": "8"}},
+		},
+	}, ids, nil)
+
+	sink.SetOutputFilters("videos", felix.LinkFilterFunc(func(link felix.Link, next func(felix.Link)) {
+		if strings.HasSuffix(link.URL, ".mkv") {
+			next(link)
+		}
+	}))
+
+	testCases := []struct {
+		desc     string
+		url      string
+		expected map[string]string
+	}{
+		{
+			desc:     "default dir",
+			url:      "http://example.com/file.zip",
+			expected: map[string]string{"dir": "/downloads", "split": "4"},
+		},
+		{
+			desc:     "output dir",
+			url:      "http://example.com/file.mkv",
+			expected: map[string]string{"dir": "/downloads/videos", "split": "8"},
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			if err := sink.Send(context.Background(), felix.Link{URL: tC.url}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			call := rpc.calls[len(rpc.calls)-1]
+			if call.uri != tC.url {
+				t.Errorf("unexpected uri. expected %v, got %v", tC.url, call.uri)
+			}
+			if !reflect.DeepEqual(call.options, tC.expected) {
+				t.Errorf("unexpected options. expected %v, got %v", tC.expected, call.options)
+			}
+			if gid := ids["aria2|"+tC.url]; gid != fmt.Sprintf("%016d", len(rpc.calls)) {
+				t.Errorf("gid was not stored, got %q", gid)
+			}
+		})
+	}
+
+	// Already submitted links are skipped
+	if err := sink.Send(context.Background(), felix.Link{URL: "http://example.com/file.zip"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rpc.calls) != 2 {
+		t.Errorf("unexpected number of calls. expected %v, got %v", 2, len(rpc.calls))
+	}
+}
+
+func TestSink_Errors(t *testing.T) {
+	rpc := &fakeRPC{secret: "secret"}
+	server := httptest.NewServer(rpc)
+
+	sink := New("aria2", Config{URL: server.URL, Secret: "wrong"}, mapIDStore{}, nil)
+	err := sink.Send(context.Background(), felix.Link{URL: "http://example.com/file"})
+	if err == nil || felix.IsPermanent(err) {
+		t.Errorf("authentication errors should be retried, got %v", err)
+	}
+
+	sink = New("aria2", Config{URL: server.URL, Secret: "secret"}, mapIDStore{}, nil)
+	err = sink.Send(context.Background(), felix.Link{URL: "foo://example.com/file"})
+	if !felix.IsPermanent(err) {
+		t.Errorf("invalid URI errors should be permanent, got %v", err)
+	}
+
+	server.Close()
+
+	sink = New("aria2", Config{URL: server.URL, Secret: "secret"}, mapIDStore{}, nil)
+	err = sink.Send(context.Background(), felix.Link{URL: "http://example.com/file"})
+	if err == nil || felix.IsPermanent(err) {
+		t.Errorf("unreachable daemon should be retried, got %v", err)
+	}
+}
+
+type addURICall struct {
+	uri     string
+	options map[string]string
+}
+
+// fakeRPC implements aria2.addUri of the aria2 JSON-RPC interface.
+type fakeRPC struct {
+	secret string
+	calls  []addURICall
+}
+
+func (f *fakeRPC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != "aria2.addUri" || len(req.Params) != 3 {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	var token string
+	json.Unmarshal(req.Params[0], &token)
+	if token != "token:"+f.secret {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"id":"felix","jsonrpc":"2.0","error":{"code":1,"message":"Unauthorized"}}`)
+		return
+	}
+
+	var call addURICall
+	var uris []string
+	json.Unmarshal(req.Params[1], &uris)
+	json.Unmarshal(req.Params[2], &call.options)
+	call.uri = uris[0]
+	if !strings.HasPrefix(call.uri, "http") {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"id":"felix","jsonrpc":"2.0","error":{"code":1,"message":"Unrecognized URI or unsupported protocol: %s"}}`, call.uri)
+		return
+	}
+	f.calls = append(f.calls, call)
+
+	fmt.Fprintf(w, `{"id":"felix","jsonrpc":"2.0","result":"%016d"}`, len(f.calls))
+}
+
+type mapIDStore map[string]string
+
+func (m mapIDStore) StoreSinkID(sink, url, id string) error {
+	m[sink+"|"+url] = id
+	return nil
+}
+
+func (m mapIDStore) SinkID(sink, url string) (string, error) {
+	id, ok := m[sink+"|"+url]
+	if !ok {
+		return "", felix.ErrNotFound
+	}
+	return id, nil
+}
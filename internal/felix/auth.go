@@ -0,0 +1,77 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// AuthConfig contains the credentials accepted by the HTTP server.
+// If neither username nor tokens are set, authentication is disabled.
+// Basic authentication requires both username and password (see Config.Validate).
+type AuthConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Tokens are accepted as "Authorization: Bearer <token>" header or "token" query parameter.
+	Tokens []string `yaml:"tokens"`
+}
+
+// Enabled reports whether any credentials are configured.
+func (c AuthConfig) Enabled() bool {
+	return c.Username != "" || len(c.Tokens) > 0
+}
+
+// authorized reports whether the request contains valid credentials.
+func (c AuthConfig) authorized(r *http.Request) bool {
+	if user, pass, ok := r.BasicAuth(); ok && c.Username != "" && c.Password != "" {
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(c.Username)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(c.Password)) == 1
+		if userOK && passOK {
+			return true
+		}
+	}
+
+	var token string
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	} else {
+		token = r.URL.Query().Get("token")
+	}
+
+	if token == "" {
+		return false
+	}
+
+	for _, t := range c.Tokens {
+		if t != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authenticate only passes requests with valid credentials (see AuthConfig) to h.
+// All other requests are rejected with 401 Unauthorized. If no credentials are configured, all requests are passed.
+func Authenticate(c AuthConfig, h http.Handler) http.Handler {
+	if !c.Enabled() {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.authorized(r) {
+			if c.Username != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="felix"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="felix"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
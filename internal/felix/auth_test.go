@@ -0,0 +1,68 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	basic := AuthConfig{Username: "user", Password: "pass"}
+	tokens := AuthConfig{Tokens: []string{"token1", "token2"}}
+	both := AuthConfig{Username: "user", Password: "pass", Tokens: []string{"token1"}}
+
+	testCases := []struct {
+		desc   string
+		auth   AuthConfig
+		target string
+		setup  func(r *http.Request)
+		status int
+	}{
+		{"disabled", AuthConfig{}, "/", nil, http.StatusOK},
+		{"basic auth", basic, "/", func(r *http.Request) { r.SetBasicAuth("user", "pass") }, http.StatusOK},
+		{"basic auth wrong password", basic, "/", func(r *http.Request) { r.SetBasicAuth("user", "wrong") }, http.StatusUnauthorized},
+		{"basic auth missing", basic, "/", nil, http.StatusUnauthorized},
+		{"bearer token", tokens, "/", func(r *http.Request) { r.Header.Set("Authorization", "Bearer token2") }, http.StatusOK},
+		{"bearer token wrong", tokens, "/", func(r *http.Request) { r.Header.Set("Authorization", "Bearer token3") }, http.StatusUnauthorized},
+		{"query token", tokens, "/?token=token1", nil, http.StatusOK},
+		{"query token wrong", tokens, "/?token=foo", nil, http.StatusUnauthorized},
+		{"empty query token", tokens, "/?token=", nil, http.StatusUnauthorized},
+		{"token not accepted as basic auth", tokens, "/", func(r *http.Request) { r.SetBasicAuth("", "token1") }, http.StatusUnauthorized},
+		{"both with basic auth", both, "/", func(r *http.Request) { r.SetBasicAuth("user", "pass") }, http.StatusOK},
+		{"both with token", both, "/feed?token=token1", nil, http.StatusOK},
+		{"username without password", AuthConfig{Username: "user"}, "/", func(r *http.Request) { r.SetBasicAuth("user", "") }, http.StatusUnauthorized},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			called := false
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			})
+
+			req := httptest.NewRequest("GET", tC.target, nil)
+			if tC.setup != nil {
+				tC.setup(req)
+			}
+			w := httptest.NewRecorder()
+
+			Authenticate(tC.auth, h).ServeHTTP(w, req)
+
+			if w.Code != tC.status {
+				t.Errorf("unexpected status code. expected %v, got %v", tC.status, w.Code)
+			}
+
+			if called != (tC.status == http.StatusOK) {
+				t.Errorf("handler should only be called for authenticated requests, called: %v", called)
+			}
+
+			if tC.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("missing WWW-Authenticate header")
+			}
+		})
+	}
+}
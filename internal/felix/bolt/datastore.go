@@ -5,10 +5,13 @@
 package bolt
 
 import (
-	"time"
-
 	"bytes"
 	"encoding/gob"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/martinplaner/felix/internal/felix"
@@ -21,10 +24,30 @@ var (
 	attemptBucket = []byte("attempts")
 	itemBucket    = []byte("items")
 	linkBucket    = []byte("links")
+	historyBucket = []byte("history")
+	feedBucket    = []byte("feeds")
+	metaBucket    = []byte("meta")
+	queueBucket   = []byte("sinkqueue")
+	sinkIDBucket  = []byte("sinkids")
+
+	buckets = [][]byte{attemptBucket, itemBucket, linkBucket, historyBucket, feedBucket, metaBucket, queueBucket, sinkIDBucket}
+	// recordBuckets contain the entries that are exported by Walk and replaced by Restore
+	recordBuckets = [][]byte{attemptBucket, itemBucket, linkBucket, feedBucket}
+
+	// linksChangedKey is the key of the time of the latest change of the links bucket in the meta bucket
+	linksChangedKey = []byte("linksChanged")
 )
 
 type datastore struct {
-	db *bolt.DB
+	// mu guards db, which is reopened during compaction
+	mu       sync.RWMutex
+	db       *bolt.DB
+	filename string
+
+	// entries caches the number of entries per bucket for the metrics (see bucketEntries)
+	entriesMu sync.Mutex
+	entries   map[string]float64
+	counted   time.Time
 }
 
 type attemptEntity struct {
@@ -32,24 +55,87 @@ type attemptEntity struct {
 	Count int
 }
 
+type historyEntity struct {
+	// Results contains the fetch results, most recent first.
+	Results []felix.FetchResult
+}
+
+type feedEntity struct {
+	Feed felix.FeedConfig
+}
+
+type queueEntity struct {
+	Link   felix.Link
+	Queued time.Time
+}
+
+type sinkIDEntity struct {
+	ID     string
+	Stored time.Time
+}
+
 type itemEntity struct {
 	Item  felix.Item
 	Added time.Time
+	State felix.FetcherState
+}
+
+// item returns the stored item including all datastore managed fields.
+func (e itemEntity) item() felix.Item {
+	item := e.Item
+	item.Added = e.Added
+	item.State = e.State
+	if item.State == "" {
+		// Entities stored before the introduction of fetcher states.
+		// Their fetchers will quit on their own if there are no attempts left.
+		item.State = felix.FetcherActive
+	}
+	return item
 }
 
 type linkEntity struct {
-	Link  felix.Link
-	Added time.Time
+	Link   felix.Link
+	Added  time.Time
+	Status felix.LinkStatus
+}
+
+// link returns the stored link including all datastore managed fields.
+func (e linkEntity) link() felix.Link {
+	link := e.Link
+	link.Added = e.Added
+	link.Status = e.Status
+	if link.Status == "" {
+		// Entities stored before the introduction of link states
+		link.Status = felix.LinkNew
+	}
+	return link
 }
 
-func (ds datastore) Close() error {
+func (ds *datastore) Close() error {
+	unregister(ds)
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
 	return ds.db.Close()
 }
 
-func (ds datastore) LastAttempt(key string) (time.Time, int, error) {
+func (ds *datastore) view(fn func(*bolt.Tx) error) error {
+	defer observeTx("view", time.Now())
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return ds.db.View(fn)
+}
+
+func (ds *datastore) update(fn func(*bolt.Tx) error) error {
+	defer observeTx("update", time.Now())
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return ds.db.Update(fn)
+}
+
+func (ds *datastore) LastAttempt(key string) (time.Time, int, error) {
 	var attempt attemptEntity
 
-	err := ds.db.View(func(tx *bolt.Tx) error {
+	err := ds.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(attemptBucket)
 		buf := b.Get([]byte(key))
 
@@ -73,8 +159,8 @@ func (ds datastore) LastAttempt(key string) (time.Time, int, error) {
 	return attempt.Last, attempt.Count, nil
 }
 
-func (ds datastore) IncAttempt(key string) error {
-	return ds.db.Update(func(tx *bolt.Tx) error {
+func (ds *datastore) IncAttempt(key string) error {
+	return ds.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(attemptBucket)
 		buf := b.Get([]byte(key))
 
@@ -96,9 +182,9 @@ func (ds datastore) IncAttempt(key string) error {
 	})
 }
 
-func (ds datastore) StoreItem(item felix.Item) (exists bool, e error) {
+func (ds *datastore) StoreItem(item felix.Item) (exists bool, e error) {
 	exists = false
-	err := ds.db.Update(func(tx *bolt.Tx) error {
+	err := ds.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(itemBucket)
 		key := []byte(item.URL)
 		buf := b.Get(key)
@@ -112,6 +198,7 @@ func (ds datastore) StoreItem(item felix.Item) (exists bool, e error) {
 		entity := itemEntity{
 			Item:  item,
 			Added: time.Now(),
+			State: felix.FetcherActive,
 		}
 
 		if err := put(b, key, entity); err != nil {
@@ -128,9 +215,9 @@ func (ds datastore) StoreItem(item felix.Item) (exists bool, e error) {
 	return exists, nil
 }
 
-func (ds datastore) StoreLink(link felix.Link) (exists bool, e error) {
+func (ds *datastore) StoreLink(link felix.Link) (exists bool, e error) {
 	exists = false
-	err := ds.db.Update(func(tx *bolt.Tx) error {
+	err := ds.update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(linkBucket)
 		key := []byte(link.URL)
 		buf := b.Get(key)
@@ -142,15 +229,16 @@ func (ds datastore) StoreLink(link felix.Link) (exists bool, e error) {
 		}
 
 		entity := linkEntity{
-			Link:  link,
-			Added: time.Now(),
+			Link:   link,
+			Added:  time.Now(),
+			Status: felix.LinkNew,
 		}
 
 		if err := put(b, key, entity); err != nil {
 			return errors.Wrap(err, "could not store entity")
 		}
 
-		return nil
+		return setLinksChanged(tx, entity.Added)
 	})
 
 	if err != nil {
@@ -160,11 +248,11 @@ func (ds datastore) StoreLink(link felix.Link) (exists bool, e error) {
 	return exists, nil
 }
 
-func (ds datastore) GetItems(maxAge time.Duration) ([]felix.Item, error) {
+func (ds *datastore) GetItems(maxAge time.Duration) ([]felix.Item, error) {
 	var items []felix.Item
 	var cutoff = time.Now().Add(-maxAge)
 
-	err := ds.db.View(func(tx *bolt.Tx) error {
+	err := ds.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(itemBucket)
 		c := b.Cursor()
 
@@ -175,7 +263,7 @@ func (ds datastore) GetItems(maxAge time.Duration) ([]felix.Item, error) {
 			}
 
 			if entity.Added.After(cutoff) {
-				items = append(items, entity.Item)
+				items = append(items, entity.item())
 			}
 		}
 
@@ -189,11 +277,11 @@ func (ds datastore) GetItems(maxAge time.Duration) ([]felix.Item, error) {
 	return items, nil
 }
 
-func (ds datastore) GetLinks(maxAge time.Duration) ([]felix.Link, error) {
+func (ds *datastore) GetLinks(maxAge time.Duration) ([]felix.Link, error) {
 	var links []felix.Link
 	var cutoff = time.Now().Add(-maxAge)
 
-	err := ds.db.View(func(tx *bolt.Tx) error {
+	err := ds.view(func(tx *bolt.Tx) error {
 		b := tx.Bucket(linkBucket)
 		c := b.Cursor()
 
@@ -204,7 +292,7 @@ func (ds datastore) GetLinks(maxAge time.Duration) ([]felix.Link, error) {
 			}
 
 			if entity.Added.After(cutoff) {
-				links = append(links, entity.Link)
+				links = append(links, entity.link())
 			}
 		}
 
@@ -218,54 +306,664 @@ func (ds datastore) GetLinks(maxAge time.Duration) ([]felix.Link, error) {
 	return links, nil
 }
 
-func (ds datastore) Cleanup(maxAge time.Duration) error {
-	var cutoff = time.Now().Add(-maxAge)
+func (ds *datastore) SetItemState(url string, state felix.FetcherState) error {
+	return ds.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(itemBucket)
+		key := []byte(url)
+		buf := b.Get(key)
 
-	return ds.db.Update(func(tx *bolt.Tx) error {
-		itemCursor := tx.Bucket(itemBucket).Cursor()
-		for k, v := itemCursor.First(); k != nil; k, v = itemCursor.Next() {
-			var entity itemEntity
+		if buf == nil {
+			return felix.ErrNotFound
+		}
+
+		var entity itemEntity
+		if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&entity); err != nil {
+			return errors.Wrap(err, "could not decode entity")
+		}
+
+		entity.State = state
+
+		if err := put(b, key, entity); err != nil {
+			return errors.Wrap(err, "could not store entity")
+		}
+
+		return nil
+	})
+}
+
+func (ds *datastore) GetItem(url string) (felix.Item, error) {
+	var entity itemEntity
+
+	err := ds.view(func(tx *bolt.Tx) error {
+		buf := tx.Bucket(itemBucket).Get([]byte(url))
+
+		if buf == nil {
+			return felix.ErrNotFound
+		}
+
+		if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&entity); err != nil {
+			return errors.Wrap(err, "could not decode entity")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return felix.Item{}, err
+	}
+
+	return entity.item(), nil
+}
+
+func (ds *datastore) GetLink(url string) (felix.Link, error) {
+	var entity linkEntity
+
+	err := ds.view(func(tx *bolt.Tx) error {
+		buf := tx.Bucket(linkBucket).Get([]byte(url))
+
+		if buf == nil {
+			return felix.ErrNotFound
+		}
+
+		if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&entity); err != nil {
+			return errors.Wrap(err, "could not decode entity")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return felix.Link{}, err
+	}
+
+	return entity.link(), nil
+}
+
+func (ds *datastore) SetLinkStatus(url string, status felix.LinkStatus) error {
+	return ds.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(linkBucket)
+		key := []byte(url)
+		buf := b.Get(key)
+
+		if buf == nil {
+			return felix.ErrNotFound
+		}
+
+		var entity linkEntity
+		if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&entity); err != nil {
+			return errors.Wrap(err, "could not decode entity")
+		}
+
+		entity.Status = status
+
+		if err := put(b, key, entity); err != nil {
+			return errors.Wrap(err, "could not store entity")
+		}
+
+		return setLinksChanged(tx, time.Now())
+	})
+}
+
+func (ds *datastore) AddFetchResult(key string, result felix.FetchResult) error {
+	return ds.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		buf := b.Get([]byte(key))
+
+		var entity historyEntity
+		if buf != nil {
+			if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&entity); err != nil {
+				return errors.Wrap(err, "could not decode entity")
+			}
+		}
+
+		entity.Results = append([]felix.FetchResult{result}, entity.Results...)
+		if len(entity.Results) > felix.MaxFetchHistory {
+			entity.Results = entity.Results[:felix.MaxFetchHistory]
+		}
+
+		if err := put(b, []byte(key), entity); err != nil {
+			return errors.Wrap(err, "could not store entity")
+		}
+
+		return nil
+	})
+}
+
+func (ds *datastore) FetchHistory(key string) ([]felix.FetchResult, error) {
+	var entity historyEntity
+
+	err := ds.view(func(tx *bolt.Tx) error {
+		buf := tx.Bucket(historyBucket).Get([]byte(key))
+
+		if buf == nil {
+			return nil
+		}
+
+		if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&entity); err != nil {
+			return errors.Wrap(err, "could not decode entity")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return entity.Results, nil
+}
+
+func (ds *datastore) StoreFeed(fc felix.FeedConfig) error {
+	return ds.update(func(tx *bolt.Tx) error {
+		if err := put(tx.Bucket(feedBucket), []byte(fc.URL), feedEntity{Feed: fc}); err != nil {
+			return errors.Wrap(err, "could not store entity")
+		}
+		return nil
+	})
+}
+
+func (ds *datastore) GetFeeds() ([]felix.FeedConfig, error) {
+	var feeds []felix.FeedConfig
+
+	err := ds.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(feedBucket).ForEach(func(k, v []byte) error {
+			var entity feedEntity
 			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&entity); err != nil {
 				return errors.Wrap(err, "could not decode entity")
 			}
+			feeds = append(feeds, entity.Feed)
+			return nil
+		})
+	})
 
-			if entity.Added.Before(cutoff) {
-				if err := itemCursor.Delete(); err != nil {
-					return errors.Wrap(err, "could not delete item")
-				}
+	if err != nil {
+		return nil, err
+	}
+
+	return feeds, nil
+}
+
+func (ds *datastore) RemoveFeed(url string) error {
+	return ds.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(feedBucket)
+		key := []byte(url)
+
+		if b.Get(key) == nil {
+			return felix.ErrNotFound
+		}
+
+		return b.Delete(key)
+	})
+}
+
+// queueKey returns the key of a queued link or sink ID. Keys of the same sink share a common prefix.
+func queueKey(sink, url string) []byte {
+	return []byte(sink + "\x00" + url)
+}
+
+func (ds *datastore) QueueLink(sink string, link felix.Link) error {
+	return ds.update(func(tx *bolt.Tx) error {
+		entity := queueEntity{Link: link, Queued: time.Now()}
+		if err := put(tx.Bucket(queueBucket), queueKey(sink, link.URL), entity); err != nil {
+			return errors.Wrap(err, "could not store entity")
+		}
+		return nil
+	})
+}
+
+func (ds *datastore) QueuedLinks(sink string) ([]felix.Link, error) {
+	var entities []queueEntity
+
+	err := ds.view(func(tx *bolt.Tx) error {
+		prefix := queueKey(sink, "")
+		c := tx.Bucket(queueBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var entity queueEntity
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&entity); err != nil {
+				return errors.Wrap(err, "could not decode entity")
 			}
+			entities = append(entities, entity)
 		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
 
-		linkCursor := tx.Bucket(linkBucket).Cursor()
-		for k, v := linkCursor.First(); k != nil; k, v = linkCursor.Next() {
+	sort.SliceStable(entities, func(i, j int) bool { return entities[i].Queued.Before(entities[j].Queued) })
+
+	links := make([]felix.Link, 0, len(entities))
+	for _, entity := range entities {
+		links = append(links, entity.Link)
+	}
+
+	return links, nil
+}
+
+func (ds *datastore) DequeueLink(sink string, url string) error {
+	return ds.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(queueBucket).Delete(queueKey(sink, url))
+	})
+}
+
+func (ds *datastore) StoreSinkID(sink, url, id string) error {
+	return ds.update(func(tx *bolt.Tx) error {
+		entity := sinkIDEntity{ID: id, Stored: time.Now()}
+		if err := put(tx.Bucket(sinkIDBucket), queueKey(sink, url), entity); err != nil {
+			return errors.Wrap(err, "could not store entity")
+		}
+		return nil
+	})
+}
+
+func (ds *datastore) SinkID(sink, url string) (string, error) {
+	var entity sinkIDEntity
+
+	err := ds.view(func(tx *bolt.Tx) error {
+		buf := tx.Bucket(sinkIDBucket).Get(queueKey(sink, url))
+		if buf == nil {
+			return felix.ErrNotFound
+		}
+		if err := gob.NewDecoder(bytes.NewReader(buf)).Decode(&entity); err != nil {
+			return errors.Wrap(err, "could not decode entity")
+		}
+		return nil
+	})
+
+	return entity.ID, err
+}
+
+func (ds *datastore) LastChange() (time.Time, error) {
+	var t time.Time
+
+	err := ds.view(func(tx *bolt.Tx) error {
+		buf := tx.Bucket(metaBucket).Get(linksChangedKey)
+		if buf == nil {
+			return nil
+		}
+		return t.UnmarshalBinary(buf)
+	})
+
+	return t, err
+}
+
+// setLinksChanged records t as the time of the latest change of the links bucket.
+func setLinksChanged(tx *bolt.Tx, t time.Time) error {
+	buf, err := t.MarshalBinary()
+	if err != nil {
+		return errors.Wrap(err, "could not encode time")
+	}
+	return tx.Bucket(metaBucket).Put(linksChangedKey, buf)
+}
+
+func (ds *datastore) Cleanup(r felix.Retention) (felix.CleanupStats, error) {
+	var stats felix.CleanupStats
+	defer observeCleanup(time.Now(), &stats)
+
+	err := ds.update(func(tx *bolt.Tx) error {
+		var err error
+
+		stats.Items, err = cleanupBucket(tx.Bucket(itemBucket), r.Items, func(v []byte) (time.Time, error) {
+			var entity itemEntity
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&entity)
+			return entity.Added, err
+		})
+		if err != nil {
+			return errors.Wrap(err, "could not cleanup items")
+		}
+
+		stats.Links, err = cleanupBucket(tx.Bucket(linkBucket), r.Links, func(v []byte) (time.Time, error) {
 			var entity linkEntity
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&entity)
+			return entity.Added, err
+		})
+		if err != nil {
+			return errors.Wrap(err, "could not cleanup links")
+		}
+		if stats.Links > 0 {
+			if err := setLinksChanged(tx, time.Now()); err != nil {
+				return err
+			}
+		}
+
+		// Sink IDs are only needed as long as their links are stored
+		_, err = cleanupBucket(tx.Bucket(sinkIDBucket), felix.RetentionPolicy{MaxAge: r.Links.MaxAge}, func(v []byte) (time.Time, error) {
+			var entity sinkIDEntity
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&entity)
+			return entity.Stored, err
+		})
+		if err != nil {
+			return errors.Wrap(err, "could not cleanup sink ids")
+		}
+
+		stats.Attempts, err = cleanupBucket(tx.Bucket(attemptBucket), r.Attempts, func(v []byte) (time.Time, error) {
+			var entity attemptEntity
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&entity)
+			return entity.Last, err
+		})
+		if err != nil {
+			return errors.Wrap(err, "could not cleanup attempts")
+		}
+
+		stats.History, err = cleanupBucket(tx.Bucket(historyBucket), r.Attempts, func(v []byte) (time.Time, error) {
+			var entity historyEntity
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&entity); err != nil || len(entity.Results) == 0 {
+				return time.Time{}, err
+			}
+			return entity.Results[0].Start, nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "could not cleanup history")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return stats, err
+	}
+
+	ds.countEntries()
+
+	if r.Compact {
+		if err := ds.compact(); err != nil {
+			return stats, errors.Wrap(err, "could not compact datastore")
+		}
+	}
+
+	return stats, nil
+}
+
+// cleanupBucket removes all entries from b that are not retained by the policy p.
+// The timestamp of an entry is extracted from its encoded value by the given function.
+func cleanupBucket(b *bolt.Bucket, p felix.RetentionPolicy, timestamp func(v []byte) (time.Time, error)) (int, error) {
+	type entry struct {
+		key []byte
+		ts  time.Time
+	}
+
+	var cutoff = time.Now().Add(-p.MaxAge)
+	var retained []entry
+	var expired [][]byte
+
+	err := b.ForEach(func(k, v []byte) error {
+		ts, err := timestamp(v)
+		if err != nil {
+			return errors.Wrap(err, "could not decode entity")
+		}
+
+		// Keys are only valid for the life of the transaction, but have to
+		// be copied anyway, as they are deleted after the iteration.
+		key := append([]byte(nil), k...)
+
+		if ts.Before(cutoff) {
+			expired = append(expired, key)
+		} else {
+			retained = append(retained, entry{key, ts})
+		}
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	if p.MaxCount > 0 && len(retained) > p.MaxCount {
+		// Keep only the newest MaxCount entries
+		sort.Slice(retained, func(i, j int) bool { return retained[i].ts.After(retained[j].ts) })
+		for _, e := range retained[p.MaxCount:] {
+			expired = append(expired, e.key)
+		}
+	}
+
+	for _, k := range expired {
+		if err := b.Delete(k); err != nil {
+			return 0, errors.Wrap(err, "could not delete entry")
+		}
+	}
+
+	return len(expired), nil
+}
+
+// compact rewrites the datastore into a new file to reclaim the space
+// of deleted entries and replaces the original file afterwards.
+func (ds *datastore) compact() error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	// A leftover file of an interrupted compaction would fail CreateBucket
+	tmpfile := ds.filename + ".compact"
+	if err := os.Remove(tmpfile); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "could not remove temporary bolt db")
+	}
+	tmp, err := bolt.Open(tmpfile, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return errors.Wrap(err, "could not open temporary bolt db")
+	}
+
+	err = ds.db.View(func(src *bolt.Tx) error {
+		return tmp.Update(func(dst *bolt.Tx) error {
+			return src.ForEach(func(name []byte, b *bolt.Bucket) error {
+				nb, err := dst.CreateBucket(name)
+				if err != nil {
+					return errors.Wrapf(err, "could not create bucket %s", name)
+				}
+				return copyBucket(b, nb)
+			})
+		})
+	})
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(tmpfile)
+		return err
+	}
+
+	if err := ds.db.Close(); err != nil {
+		return errors.Wrap(err, "could not close bolt db")
+	}
+
+	// Always reopen the db, even if the original file could not be replaced
+	renameErr := os.Rename(tmpfile, ds.filename)
+
+	db, err := bolt.Open(ds.filename, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return errors.Wrap(err, "could not reopen bolt db")
+	}
+	ds.db = db
+
+	if renameErr != nil {
+		os.Remove(tmpfile)
+		return errors.Wrap(renameErr, "could not replace bolt db")
+	}
+
+	return nil
+}
+
+// copyBucket recursively copies all entries and nested buckets from src to dst.
+func copyBucket(src, dst *bolt.Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+
+		nested, err := dst.CreateBucket(k)
+		if err != nil {
+			return errors.Wrapf(err, "could not create bucket %s", k)
+		}
+		return copyBucket(src.Bucket(k), nested)
+	})
+}
+
+func (ds *datastore) Walk(fn func(felix.Record) error) error {
+	return ds.view(func(tx *bolt.Tx) error {
+		err := tx.Bucket(itemBucket).ForEach(func(k, v []byte) error {
+			var entity itemEntity
 			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&entity); err != nil {
 				return errors.Wrap(err, "could not decode entity")
 			}
+			item := entity.item()
+			return fn(felix.Record{Type: felix.RecordItem, Item: &item})
+		})
+		if err != nil {
+			return err
+		}
 
-			if entity.Added.Before(cutoff) {
-				if err := linkCursor.Delete(); err != nil {
-					return errors.Wrap(err, "could not delete item")
-				}
+		err = tx.Bucket(linkBucket).ForEach(func(k, v []byte) error {
+			var entity linkEntity
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&entity); err != nil {
+				return errors.Wrap(err, "could not decode entity")
 			}
+			link := entity.link()
+			return fn(felix.Record{Type: felix.RecordLink, Link: &link})
+		})
+		if err != nil {
+			return err
 		}
 
-		attemptCursor := tx.Bucket(attemptBucket).Cursor()
-		for k, v := attemptCursor.First(); k != nil; k, v = attemptCursor.Next() {
+		err = tx.Bucket(attemptBucket).ForEach(func(k, v []byte) error {
 			var entity attemptEntity
 			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&entity); err != nil {
 				return errors.Wrap(err, "could not decode entity")
 			}
+			return fn(felix.Record{Type: felix.RecordAttempt, Attempt: &felix.Attempt{
+				Key:   string(k),
+				Last:  entity.Last,
+				Count: entity.Count,
+			}})
+		})
+		if err != nil {
+			return err
+		}
 
-			if entity.Last.Before(cutoff) {
-				if err := attemptCursor.Delete(); err != nil {
-					return errors.Wrap(err, "could not delete item")
-				}
+		return tx.Bucket(feedBucket).ForEach(func(k, v []byte) error {
+			var entity feedEntity
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&entity); err != nil {
+				return errors.Wrap(err, "could not decode entity")
+			}
+			return fn(felix.Record{Type: felix.RecordFeed, Feed: &entity.Feed})
+		})
+	})
+}
+
+func (ds *datastore) Restore(next func() (felix.Record, error), replace bool) (felix.ImportStats, error) {
+	var stats felix.ImportStats
+
+	err := ds.update(func(tx *bolt.Tx) error {
+		if replace {
+			// The sink queue, sink IDs and fetch history are not exported and must survive the import
+			if err := clearBuckets(tx, recordBuckets); err != nil {
+				return err
 			}
 		}
 
-		return nil
+		for {
+			r, err := next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			exists, err := restore(tx, r)
+			if err != nil {
+				return errors.Wrapf(err, "could not restore %s record", r.Type)
+			}
+			if exists {
+				stats.Skipped++
+			} else {
+				stats.Imported++
+			}
+		}
+
+		return setLinksChanged(tx, time.Now())
 	})
+
+	if err != nil {
+		return felix.ImportStats{}, err
+	}
+
+	ds.countEntries()
+	return stats, nil
+}
+
+// restore stores the record, unless an entry with the same key does already exist.
+// It reports whether an entry with the same key did already exist.
+func restore(tx *bolt.Tx, r felix.Record) (bool, error) {
+	var bucket, key []byte
+	var entity interface{}
+
+	switch {
+	case r.Type == felix.RecordItem && r.Item != nil:
+		bucket, key = itemBucket, []byte(r.Item.URL)
+		state := r.Item.State
+		if state == "" {
+			state = felix.FetcherActive
+		}
+		entity = itemEntity{Item: *r.Item, Added: addedOrNow(r.Item.Added), State: state}
+	case r.Type == felix.RecordLink && r.Link != nil:
+		bucket, key = linkBucket, []byte(r.Link.URL)
+		status := r.Link.Status
+		if status == "" {
+			status = felix.LinkNew
+		}
+		entity = linkEntity{Link: *r.Link, Added: addedOrNow(r.Link.Added), Status: status}
+	case r.Type == felix.RecordAttempt && r.Attempt != nil:
+		bucket, key = attemptBucket, []byte(r.Attempt.Key)
+		entity = attemptEntity{Last: r.Attempt.Last, Count: r.Attempt.Count}
+	case r.Type == felix.RecordFeed && r.Feed != nil:
+		bucket, key = feedBucket, []byte(r.Feed.URL)
+		entity = feedEntity{Feed: *r.Feed}
+	default:
+		return false, errors.Errorf("unsupported record type %q", r.Type)
+	}
+
+	b := tx.Bucket(bucket)
+
+	if b.Get(key) != nil {
+		// Do not overwrite existing entries
+		return true, nil
+	}
+
+	return false, put(b, key, entity)
+}
+
+func (ds *datastore) Clear() error {
+	err := ds.update(func(tx *bolt.Tx) error {
+		if err := clearBuckets(tx, buckets); err != nil {
+			return err
+		}
+		return setLinksChanged(tx, time.Now())
+	})
+
+	ds.countEntries()
+	return err
+}
+
+// clearBuckets replaces the given buckets with empty ones.
+func clearBuckets(tx *bolt.Tx, names [][]byte) error {
+	for _, name := range names {
+		if err := tx.DeleteBucket(name); err != nil {
+			return errors.Wrapf(err, "could not delete bucket %s", name)
+		}
+		if _, err := tx.CreateBucket(name); err != nil {
+			return errors.Wrapf(err, "could not create bucket %s", name)
+		}
+	}
+	return nil
+}
+
+// addedOrNow returns t, or the current time if t is not set.
+func addedOrNow(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Now()
+	}
+	return t
 }
 
 func put(b *bolt.Bucket, key []byte, v interface{}) error {
@@ -290,7 +988,7 @@ func NewDatastore(filename string) (felix.Datastore, error) {
 	}
 
 	dbErr := db.Update(func(tx *bolt.Tx) error {
-		for _, name := range [][]byte{attemptBucket, itemBucket, linkBucket} {
+		for _, name := range buckets {
 			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
 				return errors.Wrapf(err, "could not create bucket %s", name)
 			}
@@ -302,5 +1000,8 @@ func NewDatastore(filename string) (felix.Datastore, error) {
 		return nil, dbErr
 	}
 
-	return &datastore{db}, nil
+	ds := &datastore{db: db, filename: filename}
+	ds.countEntries()
+	register(ds)
+	return ds, nil
 }
@@ -1,14 +1,19 @@
 package bolt
 
 import (
+	"bytes"
 	"testing"
 
 	"io/ioutil"
 
 	"os"
 
+	"reflect"
+	"strings"
+
 	"time"
 
+	"github.com/boltdb/bolt"
 	"github.com/martinplaner/felix/internal/felix"
 )
 
@@ -50,6 +55,20 @@ func TestDatastore_GetItems(t *testing.T) {
 		}
 	})
 
+	t.Run("should return single item by url", func(t *testing.T) {
+		got, err := ds.GetItem(item.URL)
+
+		assertNilError(t, err)
+
+		if got.Title != item.Title || got.State != felix.FetcherActive {
+			t.Errorf("unexpected item. expected %v, got %v", item, got)
+		}
+
+		if _, err := ds.GetItem("http://example.com/unknown"); err != felix.ErrNotFound {
+			t.Errorf("unexpected error. expected %v, got %v", felix.ErrNotFound, err)
+		}
+	})
+
 	t.Run("should not return items with 0 maxAge", func(t *testing.T) {
 		items, err := ds.GetItems(0 * time.Second)
 
@@ -92,6 +111,39 @@ func TestDatastore_StoreItem(t *testing.T) {
 	})
 }
 
+func TestDatastore_SetItemState(t *testing.T) {
+	ds, close := newDatastore(t)
+	defer close()
+
+	item := felix.Item{
+		URL:   "http://example.com",
+		Title: "Item Title 1",
+	}
+
+	if err := ds.SetItemState(item.URL, felix.FetcherExhausted); err != felix.ErrNotFound {
+		t.Errorf("unexpected error. expected %v, got %v", felix.ErrNotFound, err)
+	}
+
+	_, err := ds.StoreItem(item)
+	assertNilError(t, err)
+
+	items, err := ds.GetItems(1 * time.Hour)
+	assertNilError(t, err)
+
+	if len(items) != 1 || items[0].State != felix.FetcherActive {
+		t.Errorf("new item should have an active fetcher, got %+v", items)
+	}
+
+	assertNilError(t, ds.SetItemState(item.URL, felix.FetcherExhausted))
+
+	items, err = ds.GetItems(1 * time.Hour)
+	assertNilError(t, err)
+
+	if len(items) != 1 || items[0].State != felix.FetcherExhausted {
+		t.Errorf("unexpected fetcher state. expected %v, got %+v", felix.FetcherExhausted, items)
+	}
+}
+
 func TestDatastore_GetLinks(t *testing.T) {
 	ds, close := newDatastore(t)
 	defer close()
@@ -166,6 +218,49 @@ func TestDatastore_StoreLink(t *testing.T) {
 	})
 }
 
+func TestDatastore_LinkStatus(t *testing.T) {
+	ds, close := newDatastore(t)
+	defer close()
+
+	link := felix.Link{
+		URL:   "http://example.com",
+		Title: "Item Title 1",
+	}
+
+	t.Run("unknown link should not be found", func(t *testing.T) {
+		if _, err := ds.GetLink(link.URL); err != felix.ErrNotFound {
+			t.Errorf("unexpected error. expected %v, got %v", felix.ErrNotFound, err)
+		}
+
+		if err := ds.SetLinkStatus(link.URL, felix.LinkSeen); err != felix.ErrNotFound {
+			t.Errorf("unexpected error. expected %v, got %v", felix.ErrNotFound, err)
+		}
+	})
+
+	t.Run("stored link should be new", func(t *testing.T) {
+		_, err := ds.StoreLink(link)
+		assertNilError(t, err)
+
+		got, err := ds.GetLink(link.URL)
+		assertNilError(t, err)
+
+		if got.Status != felix.LinkNew || got.Added.IsZero() {
+			t.Errorf("unexpected link state. expected status %v and added timestamp, got %+v", felix.LinkNew, got)
+		}
+	})
+
+	t.Run("status should be updated", func(t *testing.T) {
+		assertNilError(t, ds.SetLinkStatus(link.URL, felix.LinkDownloaded))
+
+		links, err := ds.GetLinks(1 * time.Hour)
+		assertNilError(t, err)
+
+		if len(links) != 1 || links[0].Status != felix.LinkDownloaded {
+			t.Errorf("unexpected link status. expected %v, got %+v", felix.LinkDownloaded, links)
+		}
+	})
+}
+
 func TestDatastore_Attempts(t *testing.T) {
 	ds, close := newDatastore(t)
 	defer close()
@@ -196,6 +291,172 @@ func TestDatastore_Attempts(t *testing.T) {
 	})
 }
 
+func TestDatastore_FetchHistory(t *testing.T) {
+	ds, close := newDatastore(t)
+	defer close()
+	key := "key"
+
+	history, err := ds.FetchHistory(key)
+	assertNilError(t, err)
+
+	if len(history) != 0 {
+		t.Errorf("unexpected history length. expected %v, got %v", 0, len(history))
+	}
+
+	for i := 0; i < felix.MaxFetchHistory+5; i++ {
+		assertNilError(t, ds.AddFetchResult(key, felix.FetchResult{Start: time.Now(), Links: i}))
+	}
+
+	history, err = ds.FetchHistory(key)
+	assertNilError(t, err)
+
+	if len(history) != felix.MaxFetchHistory {
+		t.Errorf("unexpected history length. expected %v, got %v", felix.MaxFetchHistory, len(history))
+	}
+
+	if history[0].Links != felix.MaxFetchHistory+4 {
+		t.Errorf("history should start with the most recent result, got %+v", history[0])
+	}
+}
+
+func TestDatastore_Feeds(t *testing.T) {
+	ds, close := newDatastore(t)
+	defer close()
+
+	feed := felix.FeedConfig{Type: "rss", URL: "http://example.com/feed", FetchInterval: 1 * time.Hour}
+	assertNilError(t, ds.StoreFeed(feed))
+
+	feed.Paused = true
+	assertNilError(t, ds.StoreFeed(feed))
+
+	feeds, err := ds.GetFeeds()
+	assertNilError(t, err)
+
+	if len(feeds) != 1 || feeds[0] != feed {
+		t.Errorf("unexpected stored feeds. expected %v, got %v", []felix.FeedConfig{feed}, feeds)
+	}
+
+	assertNilError(t, ds.RemoveFeed(feed.URL))
+
+	if err := ds.RemoveFeed(feed.URL); err != felix.ErrNotFound {
+		t.Errorf("unexpected error. expected %v, got %v", felix.ErrNotFound, err)
+	}
+
+	feeds, err = ds.GetFeeds()
+	assertNilError(t, err)
+
+	if len(feeds) != 0 {
+		t.Errorf("unexpected number of feeds after removal. expected %v, got %v", 0, len(feeds))
+	}
+}
+
+func TestDatastore_SinkQueue(t *testing.T) {
+	ds, close := newDatastore(t)
+	defer close()
+
+	assertNilError(t, ds.QueueLink("sink", felix.Link{URL: "http://example.com/b"}))
+	assertNilError(t, ds.QueueLink("sink", felix.Link{URL: "http://example.com/a"}))
+	assertNilError(t, ds.QueueLink("sink", felix.Link{URL: "http://example.com/a", Title: "replaced"}))
+	assertNilError(t, ds.QueueLink("sink2", felix.Link{URL: "http://example.com/c"}))
+
+	links, err := ds.QueuedLinks("sink")
+	assertNilError(t, err)
+
+	expected := []felix.Link{{URL: "http://example.com/b"}, {URL: "http://example.com/a", Title: "replaced"}}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("unexpected queued links. expected %v, got %v", expected, links)
+	}
+
+	assertNilError(t, ds.DequeueLink("sink", "http://example.com/b"))
+	assertNilError(t, ds.DequeueLink("sink", "http://example.com/unknown"))
+
+	links, err = ds.QueuedLinks("sink")
+	assertNilError(t, err)
+
+	if len(links) != 1 || links[0].URL != "http://example.com/a" {
+		t.Errorf("unexpected queued links after dequeue, got %v", links)
+	}
+
+	links, err = ds.QueuedLinks("sink2")
+	assertNilError(t, err)
+
+	if len(links) != 1 || links[0].URL != "http://example.com/c" {
+		t.Errorf("unexpected queued links of other sink, got %v", links)
+	}
+}
+
+func TestDatastore_SinkID(t *testing.T) {
+	ds, close := newDatastore(t)
+	defer close()
+
+	if _, err := ds.SinkID("sink", "http://example.com/a"); err != felix.ErrNotFound {
+		t.Errorf("unexpected error. expected %v, got %v", felix.ErrNotFound, err)
+	}
+
+	assertNilError(t, ds.StoreSinkID("sink", "http://example.com/a", "id1"))
+
+	id, err := ds.SinkID("sink", "http://example.com/a")
+	assertNilError(t, err)
+	if id != "id1" {
+		t.Errorf("unexpected sink id. expected %v, got %v", "id1", id)
+	}
+
+	if _, err := ds.SinkID("other", "http://example.com/a"); err != felix.ErrNotFound {
+		t.Errorf("sink ids should be separated by sink, got %v", err)
+	}
+
+	_, err = ds.Cleanup(felix.NewRetention(-1 * time.Hour))
+	assertNilError(t, err)
+
+	if _, err := ds.SinkID("sink", "http://example.com/a"); err != felix.ErrNotFound {
+		t.Errorf("sink id should have been removed by cleanup, got %v", err)
+	}
+}
+
+func TestDatastore_LastChange(t *testing.T) {
+	ds, close := newDatastore(t)
+	defer close()
+
+	last, err := ds.LastChange()
+	assertNilError(t, err)
+	if !last.IsZero() {
+		t.Errorf("expected zero time for empty datastore, got %v", last)
+	}
+
+	_, err = ds.StoreLink(felix.Link{URL: "http://example.com/link"})
+	assertNilError(t, err)
+
+	links, err := ds.GetLinks(1 * time.Hour)
+	assertNilError(t, err)
+	last, err = ds.LastChange()
+	assertNilError(t, err)
+	if !last.Equal(links[0].Added) {
+		t.Errorf("last change should be the time the newest link was added. expected %v, got %v", links[0].Added, last)
+	}
+
+	// Existing links do not change anything
+	_, err = ds.StoreLink(felix.Link{URL: "http://example.com/link"})
+	assertNilError(t, err)
+	if unchanged, _ := ds.LastChange(); !unchanged.Equal(last) {
+		t.Errorf("last change should not be updated for existing links. expected %v, got %v", last, unchanged)
+	}
+
+	assertNilError(t, ds.SetLinkStatus("http://example.com/link", felix.LinkDownloaded))
+	changed, err := ds.LastChange()
+	assertNilError(t, err)
+	if !changed.After(last) {
+		t.Errorf("last change should be updated by status changes. expected after %v, got %v", last, changed)
+	}
+
+	_, err = ds.Cleanup(felix.NewRetention(-1 * time.Hour))
+	assertNilError(t, err)
+	cleaned, err := ds.LastChange()
+	assertNilError(t, err)
+	if !cleaned.After(changed) {
+		t.Errorf("last change should be updated by removed links. expected after %v, got %v", changed, cleaned)
+	}
+}
+
 func TestDatastore_Cleanup(t *testing.T) {
 	ds, close := newDatastore(t)
 	defer close()
@@ -209,9 +470,13 @@ func TestDatastore_Cleanup(t *testing.T) {
 	assertNilError(t, ds.IncAttempt(tryKey))
 
 	t.Run("should not remove entries in maxAge window", func(t *testing.T) {
-		err := ds.Cleanup(10 * time.Hour)
+		stats, err := ds.Cleanup(felix.NewRetention(10 * time.Hour))
 		assertNilError(t, err)
 
+		if stats != (felix.CleanupStats{}) {
+			t.Errorf("unexpected cleanup stats. expected nothing removed, got %+v", stats)
+		}
+
 		items, err := ds.GetItems(1 * time.Hour)
 		assertNilError(t, err)
 		links, err := ds.GetLinks(1 * time.Hour)
@@ -226,9 +491,13 @@ func TestDatastore_Cleanup(t *testing.T) {
 	})
 
 	t.Run("should remove entries in zero maxAge", func(t *testing.T) {
-		err := ds.Cleanup(0 * time.Second)
+		stats, err := ds.Cleanup(felix.NewRetention(0 * time.Second))
 		assertNilError(t, err)
 
+		if stats != (felix.CleanupStats{Items: 1, Links: 1, Attempts: 1}) {
+			t.Errorf("unexpected cleanup stats. expected one entry per bucket, got %+v", stats)
+		}
+
 		items, err := ds.GetItems(1 * time.Hour)
 		assertNilError(t, err)
 		links, err := ds.GetLinks(1 * time.Hour)
@@ -243,6 +512,48 @@ func TestDatastore_Cleanup(t *testing.T) {
 	})
 }
 
+func TestDatastore_CleanupRetention(t *testing.T) {
+	ds, close := newDatastore(t)
+	defer close()
+
+	for _, u := range []string{"http://example.com/1", "http://example.com/2", "http://example.com/3"} {
+		_, err := ds.StoreLink(felix.Link{URL: u})
+		assertNilError(t, err)
+		_, err = ds.StoreItem(felix.Item{URL: u})
+		assertNilError(t, err)
+	}
+	assertNilError(t, ds.IncAttempt("key"))
+
+	r := felix.Retention{
+		Items:    felix.RetentionPolicy{MaxAge: 10 * time.Hour},
+		Links:    felix.RetentionPolicy{MaxAge: 10 * time.Hour, MaxCount: 1},
+		Attempts: felix.RetentionPolicy{MaxAge: 0},
+		Compact:  true,
+	}
+
+	stats, err := ds.Cleanup(r)
+	assertNilError(t, err)
+
+	if stats != (felix.CleanupStats{Items: 0, Links: 2, Attempts: 1}) {
+		t.Errorf("unexpected cleanup stats: %+v", stats)
+	}
+
+	items, err := ds.GetItems(1 * time.Hour)
+	assertNilError(t, err)
+	links, err := ds.GetLinks(1 * time.Hour)
+	assertNilError(t, err)
+	_, attempts, err := ds.LastAttempt("key")
+	assertNilError(t, err)
+
+	if len(items) != 3 || len(links) != 1 || attempts != 0 {
+		t.Errorf("inconsistent state after cleanup: %d items, %d links, %d attempts", len(items), len(links), attempts)
+	}
+
+	if links[0].URL != "http://example.com/3" {
+		t.Errorf("cleanup should have retained the newest link, got %q", links[0].URL)
+	}
+}
+
 func assertNilError(t *testing.T, err error) {
 	t.Helper()
 	if err != nil {
@@ -276,3 +587,166 @@ func newDatastore(t *testing.T) (felix.Datastore, func()) {
 
 	return ds, close
 }
+
+func TestDatastore_ExportImport(t *testing.T) {
+	src, closeSrc := newDatastore(t)
+	defer closeSrc()
+	dst, closeDst := newDatastore(t)
+	defer closeDst()
+
+	_, err := src.StoreItem(felix.Item{URL: "http://example.com/item", Title: "Item Title 1"})
+	assertNilError(t, err)
+	_, err = src.StoreLink(felix.Link{URL: "http://example.com/link", Title: "Link Title 1"})
+	assertNilError(t, err)
+	assertNilError(t, src.IncAttempt("key"))
+	assertNilError(t, src.IncAttempt("key"))
+	assertNilError(t, src.StoreFeed(felix.FeedConfig{Type: "rss", URL: "http://example.com/feed", Paused: true}))
+
+	var buf bytes.Buffer
+	n, err := felix.Export(src, &buf)
+	assertNilError(t, err)
+	if n != 4 {
+		t.Errorf("unexpected number of exported records. expected %v, got %v", 4, n)
+	}
+
+	stats, err := felix.Import(dst, &buf, felix.ImportReplace)
+	assertNilError(t, err)
+	if stats.Imported != 4 {
+		t.Errorf("unexpected number of imported records. expected %v, got %v", 4, stats.Imported)
+	}
+
+	feeds, err := dst.GetFeeds()
+	assertNilError(t, err)
+	if len(feeds) != 1 || !feeds[0].Paused {
+		t.Errorf("feed not restored properly, got %v", feeds)
+	}
+
+	srcLinks, err := src.GetLinks(1 * time.Hour)
+	assertNilError(t, err)
+	dstLinks, err := dst.GetLinks(1 * time.Hour)
+	assertNilError(t, err)
+	if len(dstLinks) != 1 || !dstLinks[0].Added.Equal(srcLinks[0].Added) {
+		t.Errorf("link not restored properly. expected %v, got %v", srcLinks, dstLinks)
+	}
+
+	_, attempts, err := dst.LastAttempt("key")
+	assertNilError(t, err)
+	if attempts != 2 {
+		t.Errorf("unexpected number of attempts. expected %v, got %v", 2, attempts)
+	}
+}
+
+func TestDatastore_BucketEntries(t *testing.T) {
+	ds, close := newDatastore(t)
+	defer close()
+
+	_, err := ds.StoreLink(felix.Link{URL: "http://example.com"})
+	assertNilError(t, err)
+
+	// Stores do not count the entries again
+	if n := ds.(*datastore).cachedEntries()[string(linkBucket)]; n != 0 {
+		t.Errorf("unexpected number of cached entries before cleanup. expected %v, got %v", 0, n)
+	}
+
+	_, err = ds.Cleanup(felix.Retention{Links: felix.RetentionPolicy{MaxAge: 1 * time.Hour}})
+	assertNilError(t, err)
+
+	if n := ds.(*datastore).cachedEntries()[string(linkBucket)]; n != 1 {
+		t.Errorf("unexpected number of cached entries after cleanup. expected %v, got %v", 1, n)
+	}
+}
+
+func TestDatastore_CompactLeftover(t *testing.T) {
+	ds, close := newDatastore(t)
+	defer close()
+
+	_, err := ds.StoreLink(felix.Link{URL: "http://example.com"})
+	assertNilError(t, err)
+
+	// Leftover of an interrupted compaction
+	tmpfile := ds.(*datastore).filename + ".compact"
+	tmp, err := bolt.Open(tmpfile, 0600, nil)
+	if err != nil {
+		t.Fatalf("could not create leftover file: %v", err)
+	}
+	assertNilError(t, tmp.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket(linkBucket)
+		return err
+	}))
+	assertNilError(t, tmp.Close())
+	defer os.Remove(tmpfile)
+
+	_, err = ds.Cleanup(felix.Retention{Links: felix.RetentionPolicy{MaxAge: 1 * time.Hour}, Compact: true})
+	assertNilError(t, err)
+
+	links, err := ds.GetLinks(1 * time.Hour)
+	assertNilError(t, err)
+	if len(links) != 1 {
+		t.Errorf("unexpected number of links after compaction. expected %v, got %v", 1, len(links))
+	}
+}
+
+func TestDatastore_RestoreKeepsSinkData(t *testing.T) {
+	ds, close := newDatastore(t)
+	defer close()
+
+	assertNilError(t, ds.QueueLink("sink", felix.Link{URL: "http://example.com/queued"}))
+	assertNilError(t, ds.StoreSinkID("sink", "http://example.com/sent", "id"))
+
+	input := "{\"type\":\"header\",\"version\":1}\n" +
+		"{\"type\":\"link\",\"link\":{\"url\":\"http://example.com/new\"}}\n"
+	_, err := felix.Import(ds, strings.NewReader(input), felix.ImportReplace)
+	assertNilError(t, err)
+
+	queued, err := ds.QueuedLinks("sink")
+	assertNilError(t, err)
+	if len(queued) != 1 {
+		t.Errorf("queued links should survive a replace import, got %v", queued)
+	}
+
+	id, err := ds.SinkID("sink", "http://example.com/sent")
+	assertNilError(t, err)
+	if id != "id" {
+		t.Errorf("sink IDs should survive a replace import. expected %q, got %q", "id", id)
+	}
+}
+
+func TestDatastore_RestoreAtomic(t *testing.T) {
+	ds, close := newDatastore(t)
+	defer close()
+
+	_, err := ds.StoreLink(felix.Link{URL: "http://example.com/old"})
+	assertNilError(t, err)
+
+	input := "{\"type\":\"header\",\"version\":1}\n" +
+		"{\"type\":\"link\",\"link\":{\"url\":\"http://example.com/new\"}}\n" +
+		"{\"type\":\"link\"}\n"
+	if _, err := felix.Import(ds, strings.NewReader(input), felix.ImportReplace); err == nil {
+		t.Fatal("expected error for incomplete record")
+	}
+
+	links, err := ds.GetLinks(1 * time.Hour)
+	assertNilError(t, err)
+	if len(links) != 1 || links[0].URL != "http://example.com/old" {
+		t.Errorf("datastore should not be modified by a failed import, got %v", links)
+	}
+}
+
+func TestDatastore_Clear(t *testing.T) {
+	ds, close := newDatastore(t)
+	defer close()
+
+	_, err := ds.StoreLink(felix.Link{URL: "http://example.com"})
+	assertNilError(t, err)
+	assertNilError(t, ds.Clear())
+
+	var records int
+	assertNilError(t, ds.Walk(func(felix.Record) error {
+		records++
+		return nil
+	}))
+
+	if records != 0 {
+		t.Errorf("unexpected number of records after clear. expected %v, got %v", 0, records)
+	}
+}
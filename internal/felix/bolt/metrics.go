@@ -0,0 +1,108 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bolt
+
+import (
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/martinplaner/felix/internal/felix"
+	"github.com/martinplaner/felix/internal/felix/metrics"
+)
+
+var (
+	txDuration = metrics.NewHistogramVec("felix_datastore_tx_duration_seconds",
+		"Duration of datastore transactions by type (view, update).", metrics.DefBuckets, "type")
+	cleanupDuration = metrics.NewHistogram("felix_datastore_cleanup_duration_seconds",
+		"Duration of datastore cleanups, including compaction.", metrics.DefBuckets)
+	cleanupRemoved = metrics.NewCounterVec("felix_datastore_cleanup_removed_total",
+		"Number of entries removed by datastore cleanups, by bucket.", "bucket")
+
+	// open contains all open datastores, whose bucket sizes are reported at collection time
+	open   = make(map[*datastore]struct{})
+	openMu sync.Mutex
+)
+
+// entriesMaxAge is the maximum age of the cached bucket sizes of a datastore.
+// Besides, they are counted when the datastore is opened and after cleanups and imports.
+const entriesMaxAge = 10 * time.Minute
+
+func init() {
+	metrics.NewGaugeVecFunc("felix_datastore_bucket_entries",
+		"Number of entries in each datastore bucket (of all open datastores).", "bucket", bucketEntries)
+}
+
+// bucketEntries returns the number of entries per bucket of all open datastores.
+// Counting the entries reads all pages of the buckets, so it returns the cached numbers of each datastore.
+func bucketEntries() map[string]float64 {
+	openMu.Lock()
+	defer openMu.Unlock()
+
+	entries := make(map[string]float64)
+	for ds := range open {
+		for name, n := range ds.cachedEntries() {
+			entries[name] += n
+		}
+	}
+	return entries
+}
+
+// cachedEntries returns the cached number of entries per bucket, which are counted again if they are outdated.
+func (ds *datastore) cachedEntries() map[string]float64 {
+	ds.entriesMu.Lock()
+	outdated := time.Since(ds.counted) > entriesMaxAge
+	ds.entriesMu.Unlock()
+
+	if outdated {
+		ds.countEntries()
+	}
+
+	ds.entriesMu.Lock()
+	defer ds.entriesMu.Unlock()
+	return ds.entries
+}
+
+// countEntries counts the entries of all buckets and caches the result.
+func (ds *datastore) countEntries() {
+	entries := make(map[string]float64)
+	err := ds.view(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			entries[string(name)] = float64(tx.Bucket(name).Stats().KeyN)
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	ds.entriesMu.Lock()
+	ds.entries, ds.counted = entries, time.Now()
+	ds.entriesMu.Unlock()
+}
+
+func register(ds *datastore) {
+	openMu.Lock()
+	open[ds] = struct{}{}
+	openMu.Unlock()
+}
+
+func unregister(ds *datastore) {
+	openMu.Lock()
+	delete(open, ds)
+	openMu.Unlock()
+}
+
+func observeTx(typ string, start time.Time) {
+	txDuration.With(typ).Observe(time.Since(start).Seconds())
+}
+
+func observeCleanup(start time.Time, stats *felix.CleanupStats) {
+	cleanupDuration.Observe(time.Since(start).Seconds())
+	cleanupRemoved.With(string(itemBucket)).Add(float64(stats.Items))
+	cleanupRemoved.With(string(linkBucket)).Add(float64(stats.Links))
+	cleanupRemoved.With(string(attemptBucket)).Add(float64(stats.Attempts))
+	cleanupRemoved.With(string(historyBucket)).Add(float64(stats.History))
+}
@@ -0,0 +1,202 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package command implements a felix.LinkSink that runs a command for every new link.
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/martinplaner/felix/internal/felix"
+	"github.com/pkg/errors"
+)
+
+// Default values of the command configuration.
+const (
+	DefaultTimeout     = 1 * time.Minute
+	DefaultConcurrency = 1
+)
+
+// Config contains the configuration of a command sink.
+type Config struct {
+	// Command is the name or path of the executable.
+	Command string `yaml:"command"`
+	// Args are text/templates of the command arguments, see felix.SinkTemplate.
+	Args []string `yaml:"args"`
+	// Env are additional environment variables (KEY=VALUE).
+	// The variables FELIX_TITLE, FELIX_URL, FELIX_HOST and FELIX_ORIGIN (the page the link was found on)
+	// are always set, in addition to the environment of felix.
+	Env []string `yaml:"env"`
+	// Dir is the working directory of the command.
+	Dir string `yaml:"dir"`
+	// Timeout after which the command is killed (and retried).
+	Timeout time.Duration `yaml:"timeout"`
+	// Concurrency is the maximum number of commands that run at the same time.
+	Concurrency int `yaml:"concurrency"`
+	// RetryExitCodes are the exit codes after which the link is retried.
+	// The link is retried after every failure if it is empty.
+	RetryExitCodes []int `yaml:"retryExitCodes"`
+}
+
+// Sink runs a command for every link.
+type Sink struct {
+	config Config
+	args   []*template.Template
+	log    felix.Logger
+}
+
+var _ felix.ConcurrentSink = new(Sink)
+
+// New creates a new command sink. Unset config values are replaced by their defaults.
+func New(config Config) (*Sink, error) {
+	if config.Command == "" {
+		return nil, errors.New("missing command")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = DefaultConcurrency
+	}
+
+	s := &Sink{
+		config: config,
+		log:    &felix.NopLogger{},
+	}
+
+	for _, arg := range config.Args {
+		tmpl, err := felix.SinkTemplate("arg", arg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not parse argument %q", arg)
+		}
+		s.args = append(s.args, tmpl)
+	}
+
+	return s, nil
+}
+
+// SetLogger sets the logger that is used for the output of the commands.
+func (s *Sink) SetLogger(log felix.Logger) {
+	s.log = log
+}
+
+// Concurrency returns the maximum number of commands that run at the same time.
+func (s *Sink) Concurrency() int {
+	return s.config.Concurrency
+}
+
+// Send runs the command for the link. Every line of its output is logged,
+// stdout with level info and stderr with level warn.
+func (s *Sink) Send(ctx context.Context, link felix.Link) error {
+	args := make([]string, 0, len(s.args))
+	for _, tmpl := range s.args {
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, link); err != nil {
+			return felix.Permanent(errors.Wrap(err, "could not expand argument"))
+		}
+		args = append(args, b.String())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, s.config.Command, args...)
+	cmd.Dir = s.config.Dir
+	cmd.Env = append(os.Environ(), s.config.Env...)
+	cmd.Env = append(cmd.Env,
+		"FELIX_TITLE="+link.Title,
+		"FELIX_URL="+link.URL,
+		"FELIX_HOST="+felix.LinkHost(link.URL),
+		"FELIX_ORIGIN="+link.Source,
+	)
+
+	var wg sync.WaitGroup
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return errors.Wrap(err, "could not create stdout pipe")
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return errors.Wrap(err, "could not create stderr pipe")
+	}
+
+	if err := cmd.Start(); err != nil {
+		if ctx.Err() != nil {
+			return errors.Wrap(ctx.Err(), "could not start command")
+		}
+		// Most likely the command does not exist
+		return felix.Permanent(errors.Wrap(err, "could not start command"))
+	}
+
+	wg.Add(2)
+	go s.logOutput(&wg, stdout, s.log.Info, link)
+	go s.logOutput(&wg, stderr, s.log.Warn, link)
+
+	// All output has to be read before waiting for the command. If the command is killed, child processes
+	// may still hold the pipes open, so they are not read any further (Wait closes them).
+	outputDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(outputDone)
+	}()
+	select {
+	case <-outputDone:
+	case <-ctx.Done():
+	}
+
+	err = cmd.Wait()
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.Errorf("command timed out after %v", s.config.Timeout)
+	}
+	if ctx.Err() != nil {
+		// The sink is closed, the link is retried later
+		return errors.Wrap(ctx.Err(), "command canceled")
+	}
+	if err != nil {
+		return s.exitError(err)
+	}
+
+	return nil
+}
+
+// exitError returns err as a felix.PermanentError, unless its exit code should be retried.
+func (s *Sink) exitError(err error) error {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok || len(s.config.RetryExitCodes) == 0 {
+		return errors.Wrap(err, "command failed")
+	}
+
+	code := exitErr.ExitCode()
+	for _, c := range s.config.RetryExitCodes {
+		if c == code {
+			return errors.Wrap(err, "command failed")
+		}
+	}
+
+	return felix.Permanent(errors.Wrap(err, "command failed"))
+}
+
+func (s *Sink) logOutput(wg *sync.WaitGroup, r io.Reader, log func(string, ...interface{}), link felix.Link) {
+	defer wg.Done()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		log("command output", "command", s.config.Command, "url", link.URL, "output", scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		s.log.Error("could not read command output", "err", err, "command", s.config.Command, "url", link.URL)
+		// Keep reading, so the command does not block on a full pipe (e.g. after a too long line)
+		io.Copy(ioutil.Discard, r)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package command
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/martinplaner/felix/internal/felix"
+)
+
+func TestSink_Send(t *testing.T) {
+	sink, err := New(Config{
+		Command: "/bin/sh",
+		Args: []string{
+			"-c",
+			`echo "$FELIX_TITLE|$FELIX_URL|$FELIX_HOST|$FELIX_ORIGIN|$EXTRA|$1"; echo oops >&2`,
+			"sh",
+			"{{.Title}} on {{host .URL}}",
+		},
+		Env: []string{"EXTRA=extra"},
+	})
+	if err != nil {
+		t.Fatalf("could not create sink: %v", err)
+	}
+
+	var buf bytes.Buffer
+	log := felix.NewLogger()
+	log.SetOutput(&buf)
+	sink.SetLogger(log)
+
+	link := felix.Link{Title: "Title", URL: "http://example.com/file", Source: "http://example.com/page"}
+	if err := sink.Send(context.Background(), link); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, s := range []string{
+		"Title|http://example.com/file|example.com|http://example.com/page|extra|Title on example.com",
+		"oops",
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("could not find %q in log output: %s", s, buf.String())
+		}
+	}
+}
+
+func TestSink_Errors(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		config       Config
+		expectedErr  bool
+		expectedPerm bool
+		maxDuration  time.Duration
+		cancelAfter  time.Duration
+	}{
+		{
+			desc:   "success",
+			config: Config{Command: "/bin/sh", Args: []string{"-c", "exit 0"}},
+		},
+		{
+			desc:        "retry any exit code",
+			config:      Config{Command: "/bin/sh", Args: []string{"-c", "exit 3"}},
+			expectedErr: true,
+		},
+		{
+			desc:        "retry exit code",
+			config:      Config{Command: "/bin/sh", Args: []string{"-c", "exit 3"}, RetryExitCodes: []int{2, 3}},
+			expectedErr: true,
+		},
+		{
+			desc:         "permanent exit code",
+			config:       Config{Command: "/bin/sh", Args: []string{"-c", "exit 1"}, RetryExitCodes: []int{2, 3}},
+			expectedErr:  true,
+			expectedPerm: true,
+		},
+		{
+			desc:         "unknown command",
+			config:       Config{Command: "/nonexistent/command"},
+			expectedErr:  true,
+			expectedPerm: true,
+		},
+		{
+			desc:        "timeout",
+			config:      Config{Command: "/bin/sleep", Args: []string{"10"}, Timeout: 50 * time.Millisecond},
+			expectedErr: true,
+			maxDuration: 5 * time.Second,
+		},
+		{
+			desc:        "timeout with child holding the output open",
+			config:      Config{Command: "/bin/sh", Args: []string{"-c", "sleep 10 & sleep 10; echo done"}, Timeout: 50 * time.Millisecond},
+			expectedErr: true,
+			maxDuration: 5 * time.Second,
+		},
+		{
+			desc:        "output line too long",
+			config:      Config{Command: "/bin/sh", Args: []string{"-c", "head -c 300000 /dev/zero | tr '\\0' a; echo"}, Timeout: 3 * time.Second},
+			maxDuration: 2 * time.Second,
+		},
+		{
+			desc:        "canceled",
+			config:      Config{Command: "/bin/sleep", Args: []string{"10"}, RetryExitCodes: []int{2, 3}},
+			expectedErr: true,
+			maxDuration: 5 * time.Second,
+			cancelAfter: 50 * time.Millisecond,
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			sink, err := New(tC.config)
+			if err != nil {
+				t.Fatalf("could not create sink: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tC.cancelAfter > 0 {
+				time.AfterFunc(tC.cancelAfter, cancel)
+			}
+
+			start := time.Now()
+			err = sink.Send(ctx, felix.Link{URL: "http://example.com/file"})
+
+			if (err != nil) != tC.expectedErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if felix.IsPermanent(err) != tC.expectedPerm {
+				t.Errorf("unexpected permanent error. expected %v, got %v", tC.expectedPerm, felix.IsPermanent(err))
+			}
+			if tC.maxDuration > 0 && time.Since(start) > tC.maxDuration {
+				t.Errorf("command was not killed in time, took %v", time.Since(start))
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{}); err == nil {
+		t.Error("expected error for missing command")
+	}
+	if _, err := New(Config{Command: "echo", Args: []string{"{{"}}); err == nil {
+		t.Error("expected error for invalid argument template")
+	}
+
+	sink, err := New(Config{Command: "echo", Concurrency: 4})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sink.Concurrency() != 4 {
+		t.Errorf("unexpected concurrency. expected %v, got %v", 4, sink.Concurrency())
+	}
+}
@@ -6,7 +6,9 @@ package felix
 
 import (
 	"io/ioutil"
-
+	"net"
+	"reflect"
+	"strconv"
 	"time"
 
 	"github.com/mitchellh/mapstructure"
@@ -20,30 +22,138 @@ const (
 	DefaultUserAgent         = "felix"
 	DefaultPort              = 6554
 	DefaultFeedOutputMaxAge  = 6 * time.Hour
+	DefaultFeedOutputTitle   = "felix"
+	DefaultFeedOutputDesc    = "felix feed"
 	DefaultCleanupInterval   = 1 * time.Hour
 	DefaultCleanupMaxAge     = 24 * time.Hour
+	DefaultSinkRetryInterval = 5 * time.Minute
 )
 
 // Config contains the configuration
 type Config struct {
-	FetchInterval    time.Duration  `yaml:"fetchInterval"`
-	UserAgent        string         `yaml:"userAgent"`
-	Port             int            `yaml:"port"`
-	FeedOutputMaxAge time.Duration  `yaml:"feedOutputMaxAge"`
-	CleanupInterval  time.Duration  `yaml:"cleanupInterval"`
-	CleanupMaxAge    time.Duration  `yaml:"cleanupMaxAge"`
-	Feeds            []FeedConfig   `yaml:"feeds"`
-	ItemFilters      []FilterConfig `yaml:"itemFilters"`
-	LinkFilters      []FilterConfig `yaml:"linkFilters"`
+	FetchInterval         time.Duration  `yaml:"fetchInterval"`
+	UserAgent             string         `yaml:"userAgent"`
+	Host                  string         `yaml:"host"`
+	Port                  int            `yaml:"port"`
+	TLSCertFile           string         `yaml:"tlsCertFile"`
+	TLSKeyFile            string         `yaml:"tlsKeyFile"`
+	Auth                  AuthConfig     `yaml:"auth"`
+	AdminToken            string         `yaml:"adminToken"`
+	FeedOutputMaxAge      time.Duration  `yaml:"feedOutputMaxAge"`
+	FeedOutputHideHandled bool           `yaml:"feedOutputHideHandled"`
+	FeedOutputTitle       string         `yaml:"feedOutputTitle"`
+	FeedOutputLink        string         `yaml:"feedOutputLink"`
+	FeedOutputDescription string         `yaml:"feedOutputDescription"`
+	FeedOutputTTL         time.Duration  `yaml:"feedOutputTTL"`
+	CleanupInterval       time.Duration  `yaml:"cleanupInterval"`
+	CleanupMaxAge         time.Duration  `yaml:"cleanupMaxAge"`
+	Retention             Retention      `yaml:"retention"`
+	Outputs               []OutputConfig `yaml:"outputs"`
+	Feeds                 []FeedConfig   `yaml:"feeds"`
+	ItemFilters           []FilterConfig `yaml:"itemFilters"`
+	LinkFilters           []FilterConfig `yaml:"linkFilters"`
+	Sinks                 []SinkConfig   `yaml:"sinks"`
+	// SinkRetryInterval is the interval in which links that could not be delivered to a sink are retried.
+	SinkRetryInterval time.Duration `yaml:"sinkRetryInterval"`
+	// FilterTrace configures the sampled log of filter decisions, see TraceLog.
+	FilterTrace FilterTraceConfig `yaml:"filterTrace"`
+
+	// problems found while reading the config file, reported by Validate
+	problems ConfigErrors
 }
 
 var emptyConfig = Config{}
 
 // FeedConfig contains the configuration of a single feed.
 type FeedConfig struct {
-	Type          string
-	URL           string
-	FetchInterval time.Duration
+	Type          string        `json:"type" yaml:"type"`
+	URL           string        `json:"url" yaml:"url"`
+	FetchInterval time.Duration `json:"fetchInterval,omitempty" yaml:"fetchInterval"`
+	// Paused feeds are not fetched until they are resumed.
+	Paused bool `json:"paused,omitempty" yaml:"paused"`
+	// Configured marks a stored feed as the runtime state of a feed defined in the config file (see MergeFeeds).
+	Configured bool `json:"configured,omitempty" yaml:"-"`
+}
+
+// FilterTraceConfig contains the configuration of the filter decision log.
+type FilterTraceConfig struct {
+	// Rate is the fraction of items and links that are traced, from 0 (disabled) to 1 (all).
+	Rate float64 `yaml:"rate"`
+	// File is the JSON Lines file the traces are appended to. Without a file, the traces are logged.
+	File string `yaml:"file"`
+}
+
+// OutputConfig contains the configuration of an output feed.
+type OutputConfig struct {
+	Name string `yaml:"name"`
+	// Path is the path the feed is served at, defaults to "/" followed by the name.
+	Path        string `yaml:"path"`
+	Title       string `yaml:"title"`
+	Description string `yaml:"description"`
+	// Link is the website corresponding to the feed, defaults to the feed URL.
+	Link string `yaml:"link"`
+	// TTL is the suggested caching time for clients.
+	TTL         time.Duration `yaml:"ttl"`
+	MaxAge      time.Duration `yaml:"maxAge"`
+	HideHandled bool          `yaml:"hideHandled"`
+	// Limit is the maximum number of links in the feed (newest first, 0 = unlimited).
+	Limit int `yaml:"limit"`
+	// CacheMaxAge is the max-age of the Cache-Control header, defaults to the fetch interval.
+	CacheMaxAge time.Duration `yaml:"cacheMaxAge"`
+	// Filters are applied to the stored links when the feed is served.
+	Filters []FilterConfig `yaml:"filters"`
+}
+
+// FeedOutput returns the configuration of the default output feed served at "/".
+func (c Config) FeedOutput() OutputConfig {
+	return OutputConfig{
+		Path:        "/",
+		Title:       c.FeedOutputTitle,
+		Description: c.FeedOutputDescription,
+		Link:        c.FeedOutputLink,
+		TTL:         c.FeedOutputTTL,
+		MaxAge:      c.FeedOutputMaxAge,
+		HideHandled: c.FeedOutputHideHandled,
+		CacheMaxAge: c.FetchInterval,
+	}
+}
+
+// FeedOutputs returns the configurations of all output feeds with default values applied.
+// The default output feed is included, unless one of the configured outputs is served at "/".
+func (c Config) FeedOutputs() []OutputConfig {
+	var outputs []OutputConfig
+	hasRoot := false
+
+	for _, o := range c.Outputs {
+		if o.Path == "" {
+			o.Path = "/" + o.Name
+		}
+		if o.Title == "" {
+			o.Title = c.FeedOutputTitle
+			if o.Name != "" {
+				o.Title += " - " + o.Name
+			}
+		}
+		if o.Description == "" {
+			o.Description = c.FeedOutputDescription
+		}
+		if o.MaxAge <= 0 {
+			o.MaxAge = c.FeedOutputMaxAge
+		}
+		if o.CacheMaxAge <= 0 {
+			o.CacheMaxAge = c.FetchInterval
+		}
+		if o.Path == "/" {
+			hasRoot = true
+		}
+		outputs = append(outputs, o)
+	}
+
+	if !hasRoot {
+		outputs = append([]OutputConfig{c.FeedOutput()}, outputs...)
+	}
+
+	return outputs
 }
 
 // FilterConfig is the common configuration of all filter types.
@@ -74,17 +184,80 @@ func (fc *FilterConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 }
 
 // Unmarshal decodes the raw config values for a more specific config type.
+// It returns ConfigErrors for unknown keys and negative durations.
 func (fc *FilterConfig) Unmarshal(v interface{}) error {
+	return decodeRaw(fc.raw, v, "type")
+}
+
+// SinkConfig is the common configuration of all sink types.
+// See SinkConfig.Unmarshal for unmarshaling of the raw config value for more specific types.
+type SinkConfig struct {
+	Type string
+	// Name identifies the sink, e.g. its queue of undelivered links. It defaults to the type.
+	Name string
+	raw  map[string]interface{}
+}
+
+// UnmarshalYAML is a custom YAML unmarshal handler to handle the common sink config elements.
+// See https://godoc.org/gopkg.in/yaml.v2#Unmarshaler.
+func (sc *SinkConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := &struct {
+		Type string
+		Name string
+	}{}
+	if err := unmarshal(aux); err != nil {
+		return err
+	}
+
+	raw := map[string]interface{}{}
+	if err := unmarshal(raw); err != nil {
+		return err
+	}
+
+	sc.Type = aux.Type
+	sc.Name = aux.Name
+	if sc.Name == "" {
+		sc.Name = sc.Type
+	}
+	sc.raw = raw
+	return nil
+}
+
+// Unmarshal decodes the raw config values for a more specific config type.
+// It returns ConfigErrors for unknown keys and negative durations.
+func (sc *SinkConfig) Unmarshal(v interface{}) error {
+	return decodeRaw(sc.raw, v, "type", "name")
+}
+
+// decodeRaw decodes the raw config values into v, except the given common keys.
+// Durations may be given as strings, e.g. "10s".
+func decodeRaw(raw map[string]interface{}, v interface{}, common ...string) error {
+	values := make(map[string]interface{}, len(raw))
+	for key, value := range raw {
+		values[key] = value
+	}
+	for _, key := range common {
+		delete(values, key)
+	}
+
+	var errs ConfigErrors
+	checkNode(&errs, "", values, reflect.TypeOf(v), true)
+	if len(errs) > 0 {
+		return errs
+	}
+
 	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
-		TagName: "yaml", // Re-use 'yaml' field tags instead of 'mapstructure'
-		Result:  v,
+		TagName:     "yaml", // Re-use 'yaml' field tags instead of 'mapstructure'
+		DecodeHook:  mapstructure.StringToTimeDurationHookFunc(),
+		ErrorUnused: true,
+		Result:      v,
 	})
 
 	if err != nil {
 		return err
 	}
 
-	return dec.Decode(fc.raw)
+	return dec.Decode(values)
 }
 
 // ItemTitleFilterConfig contains the configuration of a ItemTitleFilter.
@@ -113,20 +286,61 @@ type LinkDuplicatesFilterConfig struct {
 	Size int
 }
 
+// Addr returns the address the HTTP server listens on.
+func (c Config) Addr() string {
+	return net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+}
+
+// TLS reports whether the HTTP server is configured to use TLS.
+// It returns an error if only one of the certificate and key files is set.
+func (c Config) TLS() (bool, error) {
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return false, errors.New("both tlsCertFile and tlsKeyFile must be set")
+	}
+	return c.TLSCertFile != "", nil
+}
+
+// HTTPAuth returns the credentials accepted by the HTTP server.
+// If authentication is enabled, the admin token is accepted as well,
+// so admin requests do not need to pass separate credentials.
+func (c Config) HTTPAuth() AuthConfig {
+	auth := c.Auth
+	if auth.Enabled() && c.AdminToken != "" {
+		auth.Tokens = append(append([]string(nil), auth.Tokens...), c.AdminToken)
+	}
+	return auth
+}
+
+// CleanupRetention returns the configured retention policies.
+// Unset maximum ages default to CleanupMaxAge.
+func (c Config) CleanupRetention() Retention {
+	r := c.Retention
+	for _, p := range []*RetentionPolicy{&r.Items, &r.Links, &r.Attempts} {
+		if p.MaxAge <= 0 {
+			p.MaxAge = c.CleanupMaxAge
+		}
+	}
+	return r
+}
+
 // NewConfig returns a new configuration with default values.
 func NewConfig() Config {
 	// TODO: return value or pointer?
 	return Config{
-		UserAgent:        DefaultUserAgent,
-		Port:             DefaultPort,
-		FetchInterval:    DefaultFeedFetchInterval,
-		FeedOutputMaxAge: DefaultFeedOutputMaxAge,
-		CleanupInterval:  DefaultCleanupInterval,
-		CleanupMaxAge:    DefaultCleanupMaxAge,
+		UserAgent:             DefaultUserAgent,
+		Port:                  DefaultPort,
+		FetchInterval:         DefaultFeedFetchInterval,
+		FeedOutputMaxAge:      DefaultFeedOutputMaxAge,
+		FeedOutputTitle:       DefaultFeedOutputTitle,
+		FeedOutputDescription: DefaultFeedOutputDesc,
+		CleanupInterval:       DefaultCleanupInterval,
+		CleanupMaxAge:         DefaultCleanupMaxAge,
+		SinkRetryInterval:     DefaultSinkRetryInterval,
 	}
 }
 
 // ConfigFromFile returns a configuration parsed from the given file.
+// Unknown keys and invalid durations are reported by Config.Validate.
 func ConfigFromFile(filename string) (Config, error) {
 	b, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -138,5 +352,13 @@ func ConfigFromFile(filename string) (Config, error) {
 		return emptyConfig, errors.Wrap(err, "could not unmarshal config")
 	}
 
+	// Unknown keys are ignored by yaml.Unmarshal and zero durations are indistinguishable from unset ones
+	// after decoding, so both are checked on the plain YAML values.
+	var node interface{}
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return emptyConfig, errors.Wrap(err, "could not unmarshal config")
+	}
+	checkNode(&config.problems, "", node, reflect.TypeOf(config), false)
+
 	return config, nil
 }
@@ -1,7 +1,11 @@
 package felix
 
 import (
+	"io/ioutil"
+	"os"
+	"reflect"
 	"testing"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -66,4 +70,312 @@ func TestConfigFromFile(t *testing.T) {
 	if config.Port != DefaultPort {
 		t.Error("invalid default config")
 	}
+
+	if err := config.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+
+	if len(config.Feeds) != 2 || config.Feeds[1].FetchInterval != 90*time.Minute {
+		t.Errorf("unexpected feed configs: %+v", config.Feeds)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		yaml     string
+		expected string
+	}{
+		{
+			desc: "valid",
+			yaml: `
+fetchInterval: 1h
+feeds:
+  - type: rss
+    url: http://example.com/feed
+    fetchInterval: 30m
+`,
+		},
+		{
+			desc: "unknown keys",
+			yaml: `
+fetchInterval: 1h
+fetchintervall: 1h
+feeds:
+  - type: rss
+    url: http://example.com/feed
+    fetchinterval: 30m
+retention:
+  links:
+    maxage: 1h
+`,
+			expected: "feeds[0].fetchinterval: unknown key\nfetchintervall: unknown key\nretention.links.maxage: unknown key",
+		},
+		{
+			desc: "durations",
+			yaml: `
+fetchInterval: 0
+cleanupInterval: -1h
+feedOutputTTL: 0s
+outputs:
+  - name: test
+    ttl: -5m
+    maxAge: 0
+`,
+			expected: "cleanupInterval: duration must not be negative, got -1h\nfetchInterval: duration must be positive, got 0\noutputs[0].ttl: duration must not be negative, got -5m",
+		},
+		{
+			desc: "urls and duplicates",
+			yaml: `
+feedOutputLink: example.com
+feeds:
+  - url: ftp://example.com/feed
+    type: rss
+  - type: rss
+    url: http://example.com/feed
+  - type: rss
+    url: http://example.com/feed
+outputs:
+  - name: a
+    path: /
+  - name: b
+    path: /
+sinks:
+  - type: webhook
+  - type: webhook
+`,
+			expected: `feedOutputLink: invalid URL "example.com", expected an absolute http or https URL
+feeds[0].url: invalid URL "ftp://example.com/feed", expected an absolute http or https URL
+feeds[2].url: duplicate feed "http://example.com/feed"
+outputs[1].path: duplicate output path "/"
+sinks[1].name: duplicate sink name "webhook"`,
+		},
+		{
+			desc: "username without password",
+			yaml: `
+auth:
+  username: user
+`,
+			expected: "auth.password: missing password for username",
+		},
+		{
+			desc: "filter trace rate",
+			yaml: `
+filterTrace:
+  rate: 1.5
+`,
+			expected: "filterTrace.rate: rate must be between 0 and 1, got 1.5",
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "config")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			f.WriteString(tC.yaml)
+			f.Close()
+
+			config, err := ConfigFromFile(f.Name())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var got string
+			if err := config.Validate(); err != nil {
+				got = err.Error()
+			}
+			if got != tC.expected {
+				t.Errorf("unexpected validation errors. expected\n%v\ngot\n%v", tC.expected, got)
+			}
+		})
+	}
+}
+
+func TestCheckNode_Order(t *testing.T) {
+	var node interface{}
+	if err := yaml.Unmarshal([]byte("zz: 1\naa: 1\nmm: 1\nfetchInterval: -1h\nbb: 1\n"), &node); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "aa: unknown key\nbb: unknown key\nfetchInterval: duration must not be negative, got -1h\nmm: unknown key\nzz: unknown key"
+	for i := 0; i < 20; i++ {
+		var errs ConfigErrors
+		checkNode(&errs, "", node, reflect.TypeOf(Config{}), false)
+		if errs.Error() != expected {
+			t.Fatalf("unexpected problems. expected\n%v\ngot\n%v", expected, errs.Error())
+		}
+	}
+}
+
+func TestFilterConfig_UnmarshalErrors(t *testing.T) {
+	var config Config
+	err := yaml.Unmarshal([]byte(`
+linkFilters:
+  - type: regex
+    exprs: [a]
+    exp: [b]
+    window: -1s
+    timeout: 0s
+`), &config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var fc struct {
+		Exprs   []string
+		Window  time.Duration `yaml:"window"`
+		Timeout time.Duration `yaml:"timeout"`
+	}
+	err = config.LinkFilters[0].Unmarshal(&fc)
+
+	expected := "exp: unknown key\nwindow: duration must not be negative, got -1s"
+	if err == nil || err.Error() != expected {
+		t.Errorf("unexpected error. expected %v, got %v", expected, err)
+	}
+
+	var errs ConfigErrors
+	errs.Add("linkFilters[0]", err)
+	if errs[0].Path != "linkFilters[0].exp" {
+		t.Errorf("unexpected error path. expected %v, got %v", "linkFilters[0].exp", errs[0].Path)
+	}
+}
+
+func TestSinkConfig(t *testing.T) {
+	var config Config
+	err := yaml.Unmarshal([]byte(`
+sinks:
+  - type: webhook
+    url: http://example.com
+    timeout: 5s
+  - type: webhook
+    name: other
+`), &config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(config.Sinks) != 2 || config.Sinks[0].Name != "webhook" || config.Sinks[1].Name != "other" {
+		t.Fatalf("unexpected sink configs: %+v", config.Sinks)
+	}
+
+	var sc struct {
+		URL     string        `yaml:"url"`
+		Timeout time.Duration `yaml:"timeout"`
+	}
+	if err := config.Sinks[0].Unmarshal(&sc); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if sc.URL != "http://example.com" || sc.Timeout != 5*time.Second {
+		t.Errorf("unexpected decoded sink config: %+v", sc)
+	}
+}
+
+func TestConfig_CleanupRetention(t *testing.T) {
+	config := NewConfig()
+	config.Retention.Links = RetentionPolicy{MaxAge: 1 * time.Hour, MaxCount: 10}
+
+	r := config.CleanupRetention()
+
+	if r.Items.MaxAge != DefaultCleanupMaxAge || r.Attempts.MaxAge != DefaultCleanupMaxAge {
+		t.Error("unset maximum ages should default to CleanupMaxAge")
+	}
+
+	if r.Links.MaxAge != 1*time.Hour || r.Links.MaxCount != 10 {
+		t.Error("configured retention policy should not be changed")
+	}
+}
+
+func TestConfig_FeedOutputs(t *testing.T) {
+	config := NewConfig()
+	config.Outputs = []OutputConfig{
+		{Name: "hd", Limit: 10},
+		{Name: "all", Path: "/feeds/all", Title: "All", MaxAge: 1 * time.Hour},
+	}
+
+	outputs := config.FeedOutputs()
+
+	if len(outputs) != 3 || outputs[0].Path != "/" {
+		t.Fatalf("expected default output followed by configured outputs, got %+v", outputs)
+	}
+
+	if outputs[1].Path != "/hd" || outputs[1].Title != "felix - hd" || outputs[1].MaxAge != DefaultFeedOutputMaxAge || outputs[1].Limit != 10 {
+		t.Errorf("unexpected defaults for output: %+v", outputs[1])
+	}
+
+	if outputs[2].Path != "/feeds/all" || outputs[2].Title != "All" || outputs[2].MaxAge != 1*time.Hour {
+		t.Errorf("configured values should not be changed: %+v", outputs[2])
+	}
+
+	config.Outputs = []OutputConfig{{Name: "root", Path: "/"}}
+	if outputs := config.FeedOutputs(); len(outputs) != 1 || outputs[0].Name != "root" {
+		t.Errorf("configured output at / should replace the default output, got %+v", outputs)
+	}
+}
+
+func TestConfig_Addr(t *testing.T) {
+	config := NewConfig()
+
+	if addr := config.Addr(); addr != ":6554" {
+		t.Errorf("unexpected default address. expected %q, got %q", ":6554", addr)
+	}
+
+	config.Host = "127.0.0.1"
+	config.Port = 8080
+
+	if addr := config.Addr(); addr != "127.0.0.1:8080" {
+		t.Errorf("unexpected address. expected %q, got %q", "127.0.0.1:8080", addr)
+	}
+}
+
+func TestConfig_TLS(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		cert    string
+		key     string
+		enabled bool
+		err     bool
+	}{
+		{"disabled", "", "", false, false},
+		{"enabled", "cert.pem", "key.pem", true, false},
+		{"missing key", "cert.pem", "", false, true},
+		{"missing cert", "", "key.pem", false, true},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			config := NewConfig()
+			config.TLSCertFile = tC.cert
+			config.TLSKeyFile = tC.key
+
+			enabled, err := config.TLS()
+
+			if enabled != tC.enabled || (err != nil) != tC.err {
+				t.Errorf("unexpected result. expected %v (error: %v), got %v (%v)", tC.enabled, tC.err, enabled, err)
+			}
+		})
+	}
+}
+
+func TestConfig_HTTPAuth(t *testing.T) {
+	config := NewConfig()
+	config.AdminToken = "admin"
+
+	if config.HTTPAuth().Enabled() {
+		t.Error("admin token alone should not enable authentication")
+	}
+
+	config.Auth = AuthConfig{Tokens: []string{"token"}}
+	auth := config.HTTPAuth()
+
+	if len(auth.Tokens) != 2 || auth.Tokens[1] != "admin" {
+		t.Errorf("admin token should be accepted, got %v", auth.Tokens)
+	}
+
+	if len(config.Auth.Tokens) != 1 {
+		t.Error("configured tokens should not be changed")
+	}
 }
@@ -0,0 +1,252 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DashboardPageSize is the number of links per page of the dashboard.
+const DashboardPageSize = 50
+
+// dashboardLink is a link with additional display information, as shown by the dashboard.
+type dashboardLink struct {
+	Link
+	Host      string
+	ItemTitle string
+}
+
+// dashboardFetcher is a running item page fetcher, as shown by the dashboard.
+type dashboardFetcher struct {
+	URL         string
+	Attempts    int
+	LastAttempt time.Time
+	NextAttempt time.Time
+}
+
+type dashboardData struct {
+	Query    string
+	Page     int
+	Pages    int
+	Total    int
+	Links    []dashboardLink
+	Feeds    []fetchStatus
+	Fetchers []dashboardFetcher
+	Filters  string
+}
+
+// PrevPage returns the number of the previous page, or 0 if there is none.
+func (d dashboardData) PrevPage() int {
+	if d.Page <= 1 {
+		return 0
+	}
+	return d.Page - 1
+}
+
+// NextPage returns the number of the next page, or 0 if there is none.
+func (d dashboardData) NextPage() int {
+	if d.Page >= d.Pages {
+		return 0
+	}
+	return d.Page + 1
+}
+
+// DashboardHandler serves a simple HTML overview of the stored links (up to maxAge), the fetch status of
+// the given feeds, the running item page fetchers of the registry and the configured filters.
+// The links can be searched with the "q" parameter and are paginated with the "page" parameter.
+func DashboardHandler(ds Datastore, feeds FeedLister, registry *FetcherRegistry, filters string, maxAge time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := dashboardData{
+			Query:   r.FormValue("q"),
+			Page:    1,
+			Filters: filters,
+		}
+
+		if v := r.FormValue("page"); v != "" {
+			page, err := strconv.Atoi(v)
+			if err != nil || page < 1 {
+				http.Error(w, "invalid page parameter", http.StatusBadRequest)
+				return
+			}
+			data.Page = page
+		}
+
+		links, err := ds.GetLinks(maxAge)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		items, err := ds.GetItems(maxAge)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		itemTitles := make(map[string]string, len(items))
+		for _, item := range items {
+			itemTitles[item.URL] = item.Title
+		}
+
+		q := apiQuery{search: strings.ToLower(data.Query)}
+		for _, link := range links {
+			if q.match(link.Title, link.URL, link.Added) {
+				data.Links = append(data.Links, dashboardLink{
+					Link:      link,
+					Host:      LinkHost(link.URL),
+					ItemTitle: itemTitles[link.Source],
+				})
+			}
+		}
+
+		// Newest links first
+		sort.SliceStable(data.Links, func(i, j int) bool { return data.Links[i].Added.After(data.Links[j].Added) })
+
+		data.Total = len(data.Links)
+		data.Pages = (data.Total + DashboardPageSize - 1) / DashboardPageSize
+		start := (data.Page - 1) * DashboardPageSize
+		if start > data.Total {
+			start = data.Total
+		}
+		end := start + DashboardPageSize
+		if end > data.Total {
+			end = data.Total
+		}
+		data.Links = data.Links[start:end]
+
+		for _, fc := range feeds.Feeds() {
+			history, err := ds.FetchHistory(fc.URL)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			data.Feeds = append(data.Feeds, newFetchStatus(fc.URL, history))
+		}
+
+		for _, f := range registry.Fetchers() {
+			last, attempts, err := ds.LastAttempt(f.URL())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			data.Fetchers = append(data.Fetchers, dashboardFetcher{
+				URL:         f.URL(),
+				Attempts:    attempts,
+				LastAttempt: last,
+				NextAttempt: f.NextAttempt(),
+			})
+		}
+
+		var buf bytes.Buffer
+		if err := dashboardTemplate.Execute(&buf, data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+}
+
+// LinkHost returns the host name of the given URL, or an empty string if it is invalid.
+func LinkHost(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format("2006-01-02 15:04:05")
+	},
+}).Parse(dashboardTemplateString))
+
+const dashboardTemplateString = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>felix</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; margin: 1em 2em; color: #222; }
+h1 { font-size: 1.5em; }
+h2 { font-size: 1.2em; margin-top: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 0.3em 0.6em; border-bottom: 1px solid #ddd; vertical-align: top; }
+th { background: #f4f4f4; }
+td.url { word-break: break-all; }
+.error { color: #b00; }
+.ok { color: #080; }
+.muted { color: #888; }
+nav { margin: 0.8em 0; }
+pre { background: #f4f4f4; padding: 0.8em; }
+</style>
+</head>
+<body>
+<h1>felix</h1>
+
+<h2>Links ({{.Total}})</h2>
+<form method="get">
+<input type="search" name="q" value="{{.Query}}" placeholder="Search title or URL">
+<button type="submit">Search</button>
+</form>
+<table>
+<tr><th>Added</th><th>Title</th><th>Host</th><th>Source item</th><th>Status</th></tr>
+{{range .Links}}<tr>
+<td>{{time .Added}}</td>
+<td><a href="{{.URL}}">{{.Title}}</a></td>
+<td>{{.Host}}</td>
+<td>{{if .Source}}<a href="{{.Source}}">{{if .ItemTitle}}{{.ItemTitle}}{{else}}{{.Source}}{{end}}</a>{{end}}</td>
+<td>{{.Status}}</td>
+</tr>
+{{else}}<tr><td colspan="5" class="muted">No links found</td></tr>
+{{end}}</table>
+{{if gt .Pages 1}}<nav>
+{{with .PrevPage}}<a href="?q={{$.Query}}&amp;page={{.}}">&laquo; previous</a>{{end}}
+page {{.Page}} of {{.Pages}}
+{{with .NextPage}}<a href="?q={{$.Query}}&amp;page={{.}}">next &raquo;</a>{{end}}
+</nav>{{end}}
+
+<h2>Feeds</h2>
+<table>
+<tr><th>URL</th><th>Last fetch</th><th>Result</th><th>Last success</th></tr>
+{{range .Feeds}}<tr>
+<td class="url">{{.URL}}</td>
+{{with .LastResult}}<td>{{time .Start}}</td>
+<td>{{if .Success}}<span class="ok">{{.Status}}: {{.Items}} items, {{.Links}} links</span>{{else}}<span class="error">{{.Error}}</span>{{end}}</td>
+{{else}}<td class="muted">never</td><td></td>
+{{end}}<td>{{with .LastSuccess}}{{time .}}{{else}}<span class="muted">never</span>{{end}}</td>
+</tr>
+{{else}}<tr><td colspan="4" class="muted">No feeds configured</td></tr>
+{{end}}</table>
+
+<h2>Item fetchers ({{len .Fetchers}})</h2>
+<table>
+<tr><th>URL</th><th>Attempts</th><th>Last attempt</th><th>Next attempt</th></tr>
+{{range .Fetchers}}<tr>
+<td class="url"><a href="{{.URL}}">{{.URL}}</a></td>
+<td>{{.Attempts}}</td>
+<td>{{time .LastAttempt}}</td>
+<td>{{time .NextAttempt}}</td>
+</tr>
+{{else}}<tr><td colspan="4" class="muted">No active item fetchers</td></tr>
+{{end}}</table>
+
+<h2>Filters</h2>
+<pre>{{.Filters}}</pre>
+</body>
+</html>
+`
@@ -0,0 +1,126 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboardHandler(t *testing.T) {
+	now := time.Now()
+	ds := &mockDatastore{
+		items: []Item{
+			{Title: "Show S01E01", URL: "http://example.com/item/1"},
+		},
+		links: []Link{
+			{Title: "Tom & Jerry <1080p>", URL: "http://dl.example.com/1", Source: "http://example.com/item/1", Added: now},
+			{Title: "other.mkv", URL: "http://example.org/2", Added: now.Add(-1 * time.Hour)},
+		},
+		history: map[string][]FetchResult{
+			"http://example.com/feed": {{Start: now, Status: http.StatusOK, Items: 3, Links: 7}},
+			"http://example.org/feed": {{Start: now, Error: "connection refused"}},
+		},
+	}
+	feeds := FeedList{
+		{Type: "rss", URL: "http://example.com/feed"},
+		{Type: "rss", URL: "http://example.org/feed"},
+	}
+
+	registry := NewFetcherRegistry()
+	stop := startRegisteredFetcher(t, registry, "http://example.com/item/2")
+	defer stop()
+
+	testCases := []struct {
+		desc     string
+		query    string
+		status   int
+		contains []string
+		excludes []string
+	}{
+		{
+			desc:   "overview",
+			status: http.StatusOK,
+			contains: []string{
+				"Tom &amp; Jerry &lt;1080p&gt;",
+				"dl.example.com",
+				"Show S01E01",
+				"other.mkv",
+				"200: 3 items, 7 links",
+				"connection refused",
+				"http://example.com/item/2",
+				"ITEM_TITLE:test",
+			},
+			excludes: []string{"<1080p>"},
+		},
+		{
+			desc:     "search",
+			query:    "?q=jerry",
+			status:   http.StatusOK,
+			contains: []string{"Links (1)", "Tom &amp; Jerry"},
+			excludes: []string{"other.mkv"},
+		},
+		{
+			desc:     "page beyond last",
+			query:    "?page=5",
+			status:   http.StatusOK,
+			contains: []string{"No links found"},
+		},
+		{
+			desc:   "invalid page",
+			query:  "?page=0",
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/dashboard"+tC.query, nil)
+			w := httptest.NewRecorder()
+
+			DashboardHandler(ds, feeds, registry, "ITEM_TITLE:test\n", 24*time.Hour).ServeHTTP(w, req)
+			body := w.Body.String()
+
+			if w.Code != tC.status {
+				t.Fatalf("unexpected status code. expected %v, got %v", tC.status, w.Code)
+			}
+
+			for _, s := range tC.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("expected %q in dashboard output", s)
+				}
+			}
+
+			for _, s := range tC.excludes {
+				if strings.Contains(body, s) {
+					t.Errorf("unexpected %q in dashboard output", s)
+				}
+			}
+		})
+	}
+}
+
+func TestDashboardHandler_Paging(t *testing.T) {
+	var links []Link
+	for i := 0; i < DashboardPageSize+1; i++ {
+		links = append(links, Link{Title: fmt.Sprintf("link%03d", i), URL: fmt.Sprintf("http://example.com/%d", i), Added: time.Now().Add(time.Duration(-i) * time.Minute)})
+	}
+
+	for page, expected := range map[int]string{1: "link000", 2: fmt.Sprintf("link%03d", DashboardPageSize)} {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/dashboard?page=%d", page), nil)
+		w := httptest.NewRecorder()
+
+		DashboardHandler(&mockDatastore{links: links}, FeedList(nil), NewFetcherRegistry(), "", 24*time.Hour).ServeHTTP(w, req)
+		body := w.Body.String()
+
+		if !strings.Contains(body, expected) || !strings.Contains(body, fmt.Sprintf("page %d of 2", page)) {
+			t.Errorf("page %d: expected %q on page", page, expected)
+		}
+	}
+}
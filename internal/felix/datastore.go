@@ -6,6 +6,9 @@ package felix
 
 import "time"
 
+// MaxFetchHistory is the maximum number of fetch results kept per key.
+const MaxFetchHistory = 20
+
 // Datastore is used to store and retrieve items, links, etc.
 type Datastore interface {
 	LastAttempt(key string) (time.Time, int, error)
@@ -14,6 +17,79 @@ type Datastore interface {
 	StoreLink(link Link) (bool, error)
 	GetItems(maxAge time.Duration) ([]Item, error)
 	GetLinks(maxAge time.Duration) ([]Link, error)
-	Cleanup(maxAge time.Duration) error
+	// SetItemState updates the fetcher state of the stored item with the given URL.
+	// It returns ErrNotFound if no such item exists.
+	SetItemState(url string, state FetcherState) error
+	// GetItem returns the stored item with the given URL or ErrNotFound.
+	GetItem(url string) (Item, error)
+	// GetLink returns the stored link with the given URL or ErrNotFound.
+	GetLink(url string) (Link, error)
+	// SetLinkStatus updates the status of the stored link with the given URL.
+	// It returns ErrNotFound if no such link exists.
+	SetLinkStatus(url string, status LinkStatus) error
+	// AddFetchResult adds the result to the fetch history of the given key.
+	// Only the most recent MaxFetchHistory results are kept.
+	AddFetchResult(key string, result FetchResult) error
+	// FetchHistory returns the fetch history of the given key, most recent first.
+	FetchHistory(key string) ([]FetchResult, error)
+	// LastChange returns the time of the latest change of the stored links, i.e. a new link,
+	// a status change or the removal of links. It returns the zero time if it is unknown.
+	LastChange() (time.Time, error)
+	// StoreFeed stores the feed configuration, replacing an existing one with the same URL.
+	StoreFeed(fc FeedConfig) error
+	// GetFeeds returns all stored feed configurations.
+	GetFeeds() ([]FeedConfig, error)
+	// RemoveFeed removes the stored feed configuration with the given URL.
+	// It returns ErrNotFound if no such feed exists.
+	RemoveFeed(url string) error
+	// SinkQueue persists links that could not be delivered to a sink.
+	SinkQueue
+	// SinkIDStore stores the IDs of links delivered to sinks.
+	// Stored IDs are removed according to the retention policy of links.
+	SinkIDStore
+	// Cleanup removes all entries that are not retained by the given policies.
+	Cleanup(r Retention) (CleanupStats, error)
+	// Walk calls fn for every stored record, stopping at the first error.
+	// fn must not modify the datastore it is called from.
+	Walk(fn func(Record) error) error
+	// Restore stores the records returned by next with all their timestamps intact, until next returns io.EOF.
+	// Records are skipped if an entry with the same key does already exist. If replace is set, all existing
+	// entries of the record types are removed first; other data (e.g. sink queues) is kept. All records are restored atomically: if next or storing a record fails,
+	// the datastore is left unchanged.
+	Restore(next func() (Record, error), replace bool) (ImportStats, error)
+	// Clear removes all entries from the datastore.
+	Clear() error
 	Close() error
 }
+
+// Retention contains the retention policies of all entity types in a Datastore.
+type Retention struct {
+	Items RetentionPolicy `yaml:"items"`
+	Links RetentionPolicy `yaml:"links"`
+	// Attempts also applies to the fetch history of the attempt's key.
+	Attempts RetentionPolicy `yaml:"attempts"`
+	// Compact rewrites the datastore after cleanup to reclaim unused space, if supported.
+	Compact bool `yaml:"compact"`
+}
+
+// RetentionPolicy defines which entries of an entity type are retained during cleanup.
+// Entries older than MaxAge are removed. If MaxCount is greater than 0,
+// only the MaxCount newest entries are retained.
+type RetentionPolicy struct {
+	MaxAge   time.Duration `yaml:"maxAge"`
+	MaxCount int           `yaml:"maxCount"`
+}
+
+// NewRetention returns a Retention with the same maximum age for all entity types.
+func NewRetention(maxAge time.Duration) Retention {
+	p := RetentionPolicy{MaxAge: maxAge}
+	return Retention{Items: p, Links: p, Attempts: p}
+}
+
+// CleanupStats contains the number of entries removed from the datastore per entity type.
+type CleanupStats struct {
+	Items    int
+	Links    int
+	Attempts int
+	History  int
+}
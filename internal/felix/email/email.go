@@ -0,0 +1,430 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package email implements a felix.LinkSink that sends digests of new links via SMTP.
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/martinplaner/felix/internal/felix"
+	"github.com/pkg/errors"
+)
+
+// Default values of the email configuration.
+const (
+	DefaultPort    = 587
+	DefaultWindow  = 1 * time.Hour
+	DefaultTimeout = 1 * time.Minute
+	DefaultSubject = "felix: {{.Count}} new link{{if ne .Count 1}}s{{end}}"
+)
+
+// Config contains the configuration of an email digest sink.
+type Config struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// StartTLS requires the connection to be upgraded via STARTTLS before authentication.
+	StartTLS bool     `yaml:"startTLS"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	// Window is the time span of a digest. A digest is sent when its oldest link is older than the window.
+	Window time.Duration `yaml:"window"`
+	// Timeout is the maximum duration of sending a digest, including connecting to the server.
+	Timeout time.Duration `yaml:"timeout"`
+	// Subject, Text and HTML are templates of the subject and the plain-text and HTML body,
+	// executed with a Digest. Unset templates default to the built-in ones.
+	Subject string `yaml:"subject"`
+	Text    string `yaml:"text"`
+	HTML    string `yaml:"html"`
+}
+
+// Digest contains the links of a digest, grouped by the item (or feed) they were found on.
+type Digest struct {
+	Count  int
+	Groups []Group
+}
+
+// Group contains all links of a digest that were found on the same item page or feed.
+type Group struct {
+	// Title of the source item, or its URL if the item is not known.
+	Title string
+	URL   string
+	Links []felix.Link
+}
+
+// ItemGetter returns the stored item with the given URL, e.g. from a felix.Datastore.
+type ItemGetter interface {
+	GetItem(url string) (felix.Item, error)
+}
+
+// Sink collects links in a queue and sends them as digest when the window has passed.
+type Sink struct {
+	name   string
+	config Config
+	queue  felix.SinkQueue
+	items  ItemGetter
+	log    felix.Logger
+
+	subject *template.Template
+	text    *template.Template
+	html    *htmltemplate.Template
+
+	// tlsConfig is used for STARTTLS, it is only overridden in tests
+	tlsConfig *tls.Config
+
+	mu   sync.Mutex
+	quit chan struct{}
+	done chan struct{}
+}
+
+var _ felix.LinkSink = new(Sink)
+
+// New creates a new email digest sink with the given name. The links of the current digest are stored in
+// the queue under the name, so they are not lost on restarts. Source item titles are looked up in items.
+func New(name string, config Config, queue felix.SinkQueue, items ItemGetter) (*Sink, error) {
+	if config.Host == "" {
+		return nil, errors.New("missing host")
+	}
+	if config.From == "" || len(config.To) == 0 {
+		return nil, errors.New("missing from or to address")
+	}
+	if config.Port == 0 {
+		config.Port = DefaultPort
+	}
+	if config.Window <= 0 {
+		config.Window = DefaultWindow
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.Subject == "" {
+		config.Subject = DefaultSubject
+	}
+	if config.Text == "" {
+		config.Text = defaultText
+	}
+	if config.HTML == "" {
+		config.HTML = defaultHTML
+	}
+
+	s := &Sink{
+		name:      name,
+		config:    config,
+		queue:     queue,
+		items:     items,
+		log:       &felix.NopLogger{},
+		tlsConfig: &tls.Config{ServerName: config.Host},
+	}
+
+	var err error
+	if s.subject, err = template.New("subject").Parse(config.Subject); err != nil {
+		return nil, errors.Wrap(err, "could not parse subject template")
+	}
+	if s.text, err = template.New("text").Parse(config.Text); err != nil {
+		return nil, errors.Wrap(err, "could not parse text template")
+	}
+	if s.html, err = htmltemplate.New("html").Parse(config.HTML); err != nil {
+		return nil, errors.Wrap(err, "could not parse html template")
+	}
+
+	return s, nil
+}
+
+// SetLogger sets the logger that is used by the sink.
+func (s *Sink) SetLogger(log felix.Logger) {
+	s.log = log
+}
+
+// queueName is the name of the queue of the current digest.
+// It is separate from the retry queue of the sink itself.
+func (s *Sink) queueName() string {
+	return s.name + "/digest"
+}
+
+// Send adds the link to the current digest. The digest is sent by a background
+// goroutine, which is started on the first call.
+func (s *Sink) Send(ctx context.Context, link felix.Link) error {
+	if link.Added.IsZero() {
+		link.Added = time.Now()
+	}
+	if err := s.queue.QueueLink(s.queueName(), link); err != nil {
+		return errors.Wrap(err, "could not add link to digest")
+	}
+
+	s.Start()
+	return nil
+}
+
+// Start starts the background goroutine that sends the digests, if it is not running yet.
+// Digests that were not sent before a restart are sent once their window has passed.
+func (s *Sink) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.quit != nil {
+		return
+	}
+	s.quit = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go s.run(s.quit, s.done)
+}
+
+// Close stops the background goroutine and waits for a digest that is being sent,
+// at most for the timeout. Unsent links stay in the queue.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	quit, done := s.quit, s.done
+	s.quit, s.done = nil, nil
+	s.mu.Unlock()
+
+	if quit != nil {
+		close(quit)
+		<-done
+	}
+	return nil
+}
+
+func (s *Sink) run(quit <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	// Check regularly, so the digest is sent close to the end of its window
+	interval := s.config.Window / 10
+	if interval < 1*time.Second {
+		interval = 1 * time.Second
+	} else if interval > 1*time.Minute {
+		interval = 1 * time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Flush(false); err != nil {
+			s.log.Error("could not send digest", "err", err, "sink", s.name)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// Flush sends the current digest if its window has passed (or if force is set) and reports whether it was sent.
+// Sent links are removed from the queue.
+func (s *Sink) Flush(force bool) (bool, error) {
+	links, err := s.queue.QueuedLinks(s.queueName())
+	if err != nil {
+		return false, errors.Wrap(err, "could not get digest links")
+	}
+
+	if len(links) == 0 {
+		return false, nil
+	}
+
+	if !force && time.Since(links[0].Added) < s.config.Window {
+		return false, nil
+	}
+
+	if err := s.send(s.digest(links)); err != nil {
+		return false, err
+	}
+
+	s.log.Info("sent digest", "sink", s.name, "links", len(links), "to", strings.Join(s.config.To, ","))
+
+	for _, link := range links {
+		if err := s.queue.DequeueLink(s.queueName(), link.URL); err != nil {
+			return true, errors.Wrap(err, "could not remove link from digest")
+		}
+	}
+	return true, nil
+}
+
+// digest groups the links by their source, keeping the order of the first link of each group.
+func (s *Sink) digest(links []felix.Link) Digest {
+	d := Digest{Count: len(links)}
+	index := make(map[string]int)
+
+	for _, link := range links {
+		i, ok := index[link.Source]
+		if !ok {
+			i = len(d.Groups)
+			index[link.Source] = i
+			d.Groups = append(d.Groups, Group{Title: s.sourceTitle(link.Source), URL: link.Source})
+		}
+		d.Groups[i].Links = append(d.Groups[i].Links, link)
+	}
+
+	return d
+}
+
+func (s *Sink) sourceTitle(url string) string {
+	if s.items != nil && url != "" {
+		if item, err := s.items.GetItem(url); err == nil && item.Title != "" {
+			return item.Title
+		}
+	}
+	if url == "" {
+		return "Unknown source"
+	}
+	return url
+}
+
+// send sends the digest as multipart/alternative message.
+func (s *Sink) send(d Digest) error {
+	msg, err := s.message(d)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(s.config.Host, strconv.Itoa(s.config.Port))
+	dialer := net.Dialer{Timeout: s.config.Timeout}
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "could not connect to smtp server")
+	}
+	// A stalled server must not block the sink (and shutdown) forever
+	if err := conn.SetDeadline(time.Now().Add(s.config.Timeout)); err != nil {
+		conn.Close()
+		return errors.Wrap(err, "could not set deadline")
+	}
+
+	c, err := smtp.NewClient(conn, s.config.Host)
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "could not connect to smtp server")
+	}
+	defer c.Close()
+
+	if s.config.StartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server does not support STARTTLS")
+		}
+		if err := c.StartTLS(s.tlsConfig); err != nil {
+			return errors.Wrap(err, "could not start TLS")
+		}
+	}
+
+	if s.config.Username != "" {
+		auth := smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)
+		if err := c.Auth(auth); err != nil {
+			return errors.Wrap(err, "could not authenticate")
+		}
+	}
+
+	if err := c.Mail(s.config.From); err != nil {
+		return errors.Wrap(err, "could not set sender")
+	}
+	for _, to := range s.config.To {
+		if err := c.Rcpt(to); err != nil {
+			return errors.Wrapf(err, "could not set recipient %s", to)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return errors.Wrap(err, "could not start message")
+	}
+	if _, err := w.Write(msg); err != nil {
+		return errors.Wrap(err, "could not write message")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "could not send message")
+	}
+
+	return c.Quit()
+}
+
+// message renders the complete message, including all headers.
+func (s *Sink) message(d Digest) ([]byte, error) {
+	var subject, text, html bytes.Buffer
+	if err := s.subject.Execute(&subject, d); err != nil {
+		return nil, errors.Wrap(err, "could not render subject")
+	}
+	if err := s.text.Execute(&text, d); err != nil {
+		return nil, errors.Wrap(err, "could not render text body")
+	}
+	if err := s.html.Execute(&html, d); err != nil {
+		return nil, errors.Wrap(err, "could not render html body")
+	}
+
+	boundary, err := randomBoundary()
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.config.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.config.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+
+	for _, part := range []struct {
+		contentType string
+		body        []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		fmt.Fprintf(&b, "--%s\r\n", boundary)
+		fmt.Fprintf(&b, "Content-Type: %s\r\n", part.contentType)
+		fmt.Fprintf(&b, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		qp := quotedprintable.NewWriter(&b)
+		qp.Write(part.body)
+		qp.Close()
+		b.WriteString("\r\n")
+	}
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+
+	return b.Bytes(), nil
+}
+
+func randomBoundary() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "could not generate boundary")
+	}
+	return "felix-" + hex.EncodeToString(b), nil
+}
+
+const defaultText = `{{.Count}} new link{{if ne .Count 1}}s{{end}}:
+{{range .Groups}}
+{{.Title}}{{if and .URL (ne .URL .Title)}} ({{.URL}}){{end}}
+{{range .Links}}  - {{if .Title}}{{.Title}}: {{end}}{{.URL}}
+{{end}}{{end}}`
+
+const defaultHTML = `<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif">
+<p>{{.Count}} new link{{if ne .Count 1}}s{{end}}:</p>
+{{range .Groups}}
+<h3>{{if .URL}}<a href="{{.URL}}">{{.Title}}</a>{{else}}{{.Title}}{{end}}</h3>
+<ul>
+{{range .Links}}<li><a href="{{.URL}}">{{if .Title}}{{.Title}}{{else}}{{.URL}}{{end}}</a></li>
+{{end}}</ul>
+{{end}}
+</body>
+</html>
+`
@@ -0,0 +1,436 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package email
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/martinplaner/felix/internal/felix"
+)
+
+func TestSink_Flush(t *testing.T) {
+	server := newFakeSMTP(t)
+	defer server.Close()
+
+	queue := &memQueue{}
+	items := itemMap{"http://example.com/item": {Title: "Some Item"}}
+	sink := newTestSink(t, server, queue, items)
+
+	links := []felix.Link{
+		{URL: "http://example.com/a", Title: "A", Source: "http://example.com/item"},
+		{URL: "http://example.com/b", Title: "B & more", Source: "http://example.com/feed"},
+		{URL: "http://example.com/c", Title: "C", Source: "http://example.com/item"},
+	}
+	for _, link := range links {
+		if err := sink.Send(context.Background(), link); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	sink.Close()
+
+	if sent, err := sink.Flush(false); sent || err != nil {
+		t.Fatalf("digest should not be sent before the window has passed. sent %v, err %v", sent, err)
+	}
+
+	if sent, err := sink.Flush(true); !sent || err != nil {
+		t.Fatalf("digest was not sent. sent %v, err %v", sent, err)
+	}
+
+	msgs := server.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("unexpected number of messages. expected %v, got %v", 1, len(msgs))
+	}
+
+	msg := msgs[0]
+	if !msg.tls || msg.auth != "user:pass" {
+		t.Errorf("message was not sent via authenticated TLS connection: %+v", msg)
+	}
+	if msg.from != "felix@example.com" || strings.Join(msg.to, ",") != "a@example.com,b@example.com" {
+		t.Errorf("unexpected envelope: %v -> %v", msg.from, msg.to)
+	}
+
+	parts := parseMessage(t, msg.data)
+	if parts["subject"] != "felix: 3 new links" {
+		t.Errorf("unexpected subject: %q", parts["subject"])
+	}
+
+	expectedText := `3 new links:
+
+Some Item (http://example.com/item)
+  - A: http://example.com/a
+  - C: http://example.com/c
+
+http://example.com/feed
+  - B & more: http://example.com/b
+`
+	if parts["text/plain"] != expectedText {
+		t.Errorf("unexpected text body. expected:\n%s\ngot:\n%s", expectedText, parts["text/plain"])
+	}
+
+	for _, s := range []string{
+		`<a href="http://example.com/item">Some Item</a>`,
+		`<a href="http://example.com/b">B &amp; more</a>`,
+	} {
+		if !strings.Contains(parts["text/html"], s) {
+			t.Errorf("could not find %q in html body: %s", s, parts["text/html"])
+		}
+	}
+
+	if links := queue.links(sink.queueName()); len(links) != 0 {
+		t.Errorf("sent links should be removed from the queue, got %v", links)
+	}
+}
+
+func TestSink_Failure(t *testing.T) {
+	server := newFakeSMTP(t)
+	defer server.Close()
+
+	queue := &memQueue{}
+	sink := newTestSink(t, server, queue, nil)
+	sink.config.Password = "wrong"
+
+	if err := sink.Send(context.Background(), felix.Link{URL: "http://example.com/a"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sink.Close()
+
+	if _, err := sink.Flush(true); err == nil {
+		t.Error("expected authentication error")
+	}
+
+	if links := queue.links(sink.queueName()); len(links) != 1 {
+		t.Errorf("unsent links should stay in the queue, got %v", links)
+	}
+}
+
+func TestSink_Restart(t *testing.T) {
+	server := newFakeSMTP(t)
+	defer server.Close()
+
+	// Links of a digest that was not sent before the restart
+	queue := &memQueue{}
+	queue.QueueLink("email/digest", felix.Link{URL: "http://example.com/a", Added: time.Now().Add(-2 * time.Hour)})
+
+	sink := newTestSink(t, server, queue, nil)
+	sink.Start()
+	defer sink.Close()
+
+	deadline := time.Now().Add(1 * time.Second)
+	for len(server.Messages()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("pending digest was not sent after start")
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+}
+
+func TestSink_Timeout(t *testing.T) {
+	// Server that accepts connections but never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	queue := &memQueue{}
+	queue.QueueLink("email/digest", felix.Link{URL: "http://example.com/a", Added: time.Now().Add(-2 * time.Hour)})
+
+	sink, err := New("email", Config{
+		Host:    host,
+		Port:    p,
+		From:    "felix@example.com",
+		To:      []string{"a@example.com"},
+		Window:  1 * time.Hour,
+		Timeout: 100 * time.Millisecond,
+	}, queue, nil)
+	if err != nil {
+		t.Fatalf("could not create sink: %v", err)
+	}
+
+	// The background goroutine is stuck in sending the digest
+	sink.Start()
+	time.Sleep(10 * time.Millisecond)
+
+	start := time.Now()
+	sink.Close()
+	if d := time.Since(start); d > 1*time.Second {
+		t.Errorf("close should not wait longer than the timeout, took %v", d)
+	}
+
+	if links := queue.links(sink.queueName()); len(links) != 1 {
+		t.Errorf("unsent links should stay in the queue, got %v", links)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("email", Config{From: "a@example.com", To: []string{"b@example.com"}}, nil, nil); err == nil {
+		t.Error("expected error for missing host")
+	}
+	if _, err := New("email", Config{Host: "localhost"}, nil, nil); err == nil {
+		t.Error("expected error for missing addresses")
+	}
+	if _, err := New("email", Config{Host: "localhost", From: "a@example.com", To: []string{"b@example.com"}, HTML: "{{"}, nil, nil); err == nil {
+		t.Error("expected error for invalid template")
+	}
+}
+
+func newTestSink(t *testing.T, server *fakeSMTP, queue felix.SinkQueue, items ItemGetter) *Sink {
+	host, port, _ := net.SplitHostPort(server.Addr())
+	p, _ := strconv.Atoi(port)
+
+	sink, err := New("email", Config{
+		Host:     host,
+		Port:     p,
+		StartTLS: true,
+		Username: "user",
+		Password: "pass",
+		From:     "felix@example.com",
+		To:       []string{"a@example.com", "b@example.com"},
+		Window:   1 * time.Hour,
+	}, queue, items)
+	if err != nil {
+		t.Fatalf("could not create sink: %v", err)
+	}
+	sink.tlsConfig = &tls.Config{InsecureSkipVerify: true}
+	return sink
+}
+
+// parseMessage returns the decoded subject and body parts (by content type) of the message.
+func parseMessage(t *testing.T, data string) map[string]string {
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("could not parse message: %v", err)
+	}
+
+	parts := make(map[string]string)
+	parts["subject"], _ = new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("could not parse content type: %v", err)
+	}
+
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := r.NextRawPart()
+		if err != nil {
+			break
+		}
+		mediaType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		b, _ := ioutil.ReadAll(quotedprintable.NewReader(p))
+		parts[mediaType] = strings.Replace(string(b), "\r\n", "\n", -1)
+	}
+	return parts
+}
+
+type message struct {
+	from string
+	to   []string
+	data string
+	tls  bool
+	auth string
+}
+
+// fakeSMTP is a minimal SMTP server that supports STARTTLS and AUTH PLAIN.
+type fakeSMTP struct {
+	ln   net.Listener
+	cert tls.Certificate
+
+	mu       sync.Mutex
+	messages []message
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+
+	// Borrow the self-signed certificate of the httptest package
+	ts := httptest.NewTLSServer(nil)
+	cert := ts.TLS.Certificates[0]
+	ts.Close()
+
+	s := &fakeSMTP{ln: ln, cert: cert}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) Addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *fakeSMTP) Close() {
+	s.ln.Close()
+}
+
+func (s *fakeSMTP) Messages() []message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]message(nil), s.messages...)
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	var msg message
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 localhost ESMTP fake")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch cmd {
+		case "EHLO", "HELO":
+			if !msg.tls {
+				reply("250-localhost")
+				reply("250-STARTTLS")
+			} else {
+				reply("250-localhost")
+			}
+			reply("250 AUTH PLAIN")
+		case "STARTTLS":
+			reply("220 ready to start TLS")
+			tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{s.cert}})
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			r = bufio.NewReader(conn)
+			msg.tls = true
+		case "AUTH":
+			fields := strings.Fields(line)
+			b, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+			creds := strings.Split(string(b), "\x00")
+			if len(creds) != 3 || creds[1] != "user" || creds[2] != "pass" {
+				reply("535 authentication failed")
+				continue
+			}
+			msg.auth = creds[1] + ":" + creds[2]
+			reply("235 authenticated")
+		case "MAIL":
+			msg.from = strings.Trim(line[strings.Index(line, ":")+1:], "<> ")
+			reply("250 ok")
+		case "RCPT":
+			msg.to = append(msg.to, strings.Trim(line[strings.Index(line, ":")+1:], "<> "))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			msg.data = data.String()
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+type itemMap map[string]felix.Item
+
+func (m itemMap) GetItem(url string) (felix.Item, error) {
+	item, ok := m[url]
+	if !ok {
+		return felix.Item{}, felix.ErrNotFound
+	}
+	return item, nil
+}
+
+// memQueue is an in-memory felix.SinkQueue.
+type memQueue struct {
+	mu    sync.Mutex
+	queue map[string][]felix.Link
+}
+
+func (q *memQueue) links(sink string) []felix.Link {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]felix.Link(nil), q.queue[sink]...)
+}
+
+func (q *memQueue) QueueLink(sink string, link felix.Link) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.queue == nil {
+		q.queue = make(map[string][]felix.Link)
+	}
+	q.queue[sink] = append(q.queue[sink], link)
+	return nil
+}
+
+func (q *memQueue) QueuedLinks(sink string) ([]felix.Link, error) {
+	return q.links(sink), nil
+}
+
+func (q *memQueue) DequeueLink(sink string, url string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var links []felix.Link
+	for _, l := range q.queue[sink] {
+		if l.URL != url {
+			links = append(links, l)
+		}
+	}
+	q.queue[sink] = links
+	return nil
+}
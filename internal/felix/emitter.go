@@ -15,15 +15,26 @@ type emitter struct {
 	items   chan<- Item
 	links   chan<- Link
 	follows []string
+	// source is set as the source of all emitted links
+	source string
+
+	// number of emitted items and links
+	itemCount int
+	linkCount int
 }
 
 // EmitItem emits an Item to be processed.
 func (e *emitter) EmitItem(item Item) {
+	e.itemCount++
 	e.items <- item
 }
 
 // EmitLink emits an Link to be processed.
 func (e *emitter) EmitLink(link Link) {
+	if link.Source == "" {
+		link.Source = e.source
+	}
+	e.linkCount++
 	e.links <- link
 }
 
@@ -10,14 +10,16 @@ func TestEmitter(t *testing.T) {
 	var testTitle = "title"
 	var testURL = "http://example.com"
 	var testFollow = []string{"http://example.com", "http://example.org"}
+	var testSource = "http://example.com/source"
 
 	// Initialize with capacity to not deadlock tests
 	links := make(chan Link, 10)
 	items := make(chan Item, 10)
 
 	e := emitter{
-		links: links,
-		items: items,
+		links:  links,
+		items:  items,
+		source: testSource,
 	}
 
 	// EmitItem
@@ -38,6 +40,8 @@ func TestEmitter(t *testing.T) {
 
 	if l, ok := <-links; !ok || l.URL != testURL {
 		t.Errorf("invalid link URL received: expected %q, got %q", testURL, l.URL)
+	} else if l.Source != testSource {
+		t.Errorf("invalid link source received: expected %q, got %q", testSource, l.Source)
 	}
 
 	// EmitFollow
@@ -0,0 +1,231 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventsKeepAlive is the interval of keep-alive comments sent to idle event stream clients.
+const EventsKeepAlive = 30 * time.Second
+
+// eventsBuffer is the number of links buffered per subscriber.
+// Subscribers that fall behind further are dropped and have to reconnect.
+const eventsBuffer = 64
+
+// LinkBroker distributes newly stored links to all subscribers.
+type LinkBroker struct {
+	mu     sync.Mutex
+	subs   map[chan Link]struct{}
+	closed bool
+}
+
+// NewLinkBroker creates a new LinkBroker.
+func NewLinkBroker() *LinkBroker {
+	return &LinkBroker{
+		subs: make(map[chan Link]struct{}),
+	}
+}
+
+// Subscribe returns a channel that receives all links published from now on and a function to unsubscribe.
+// The channel is closed when the subscriber is unsubscribed, falls too far behind or the broker is closed.
+func (b *LinkBroker) Subscribe() (<-chan Link, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Link, eventsBuffer)
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	b.subs[ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(ch)
+	}
+}
+
+// Publish sends the link to all subscribers without blocking.
+func (b *LinkBroker) Publish(link Link) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- link:
+		default:
+			// Slow subscriber, will catch up by replay after reconnecting
+			b.remove(ch)
+		}
+	}
+}
+
+// Close closes all subscriber channels. Links published afterwards are discarded.
+func (b *LinkBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		b.remove(ch)
+	}
+	b.closed = true
+}
+
+// remove closes and removes the subscriber channel, if it is still subscribed. b.mu must be held.
+func (b *LinkBroker) remove(ch chan Link) {
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// linkMatcher matches links against the filters of an event stream client.
+type linkMatcher struct {
+	host  string
+	regex *regexp.Regexp
+}
+
+func (m linkMatcher) match(link Link) bool {
+	if m.host != "" && !matchHost(link.URL, m.host) {
+		return false
+	}
+	if m.regex != nil && !m.regex.MatchString(link.URL) && !m.regex.MatchString(link.Title) {
+		return false
+	}
+	return true
+}
+
+// EventsHandler streams newly stored links as Server-Sent Events (event type "link", JSON data).
+// The event ID is the time the link was stored (in nanoseconds since the epoch). Clients reconnecting
+// with a Last-Event-ID header (or "lastEventId" parameter) first receive all links stored since,
+// up to maxAge. The stream can be limited to links of a "host" (including subdomains) or links
+// whose URL or title match a "regex".
+func EventsHandler(ds Datastore, broker *LinkBroker, maxAge time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		m := linkMatcher{host: strings.ToLower(r.FormValue("host"))}
+		if expr := r.FormValue("regex"); expr != "" {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				http.Error(w, "invalid regex parameter: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			m.regex = re
+		}
+
+		lastID := r.Header.Get("Last-Event-ID")
+		if lastID == "" {
+			lastID = r.FormValue("lastEventId")
+		}
+
+		var last time.Time
+		if lastID != "" {
+			ns, err := strconv.ParseInt(lastID, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid last event id", http.StatusBadRequest)
+				return
+			}
+			last = time.Unix(0, ns)
+		}
+
+		// Subscribe before replaying, so no links get lost in between
+		links, unsubscribe := broker.Subscribe()
+		defer unsubscribe()
+
+		var replay []Link
+		if !last.IsZero() {
+			age := time.Since(last)
+			if age > maxAge {
+				age = maxAge
+			}
+			stored, err := ds.GetLinks(age)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			for _, link := range stored {
+				if link.Added.After(last) {
+					replay = append(replay, link)
+				}
+			}
+			sort.SliceStable(replay, func(i, j int) bool { return replay[i].Added.Before(replay[j].Added) })
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, ": felix link events\n\n")
+		flusher.Flush()
+
+		send := func(link Link) error {
+			if !link.Added.After(last) {
+				// Already sent by replay
+				return nil
+			}
+			last = link.Added
+			if !m.match(link) {
+				return nil
+			}
+			if err := writeLinkEvent(w, link); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		}
+
+		for _, link := range replay {
+			if err := send(link); err != nil {
+				return
+			}
+		}
+
+		keepAlive := time.NewTicker(EventsKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case link, ok := <-links:
+				if !ok {
+					return
+				}
+				if err := send(link); err != nil {
+					return
+				}
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
+
+func writeLinkEvent(w http.ResponseWriter, link Link) error {
+	data, err := json.Marshal(link)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: link\ndata: %s\n\n", link.Added.UnixNano(), data)
+	return err
+}
@@ -0,0 +1,179 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLinkBroker(t *testing.T) {
+	b := NewLinkBroker()
+
+	ch1, unsubscribe1 := b.Subscribe()
+	ch2, _ := b.Subscribe()
+
+	b.Publish(Link{URL: "link1"})
+
+	for i, ch := range []<-chan Link{ch1, ch2} {
+		if link := <-ch; link.URL != "link1" {
+			t.Errorf("subscriber %d: unexpected link %v", i, link)
+		}
+	}
+
+	unsubscribe1()
+	if _, ok := <-ch1; ok {
+		t.Error("channel should be closed after unsubscribe")
+	}
+	unsubscribe1()
+
+	// Slow subscribers are dropped
+	for i := 0; i <= eventsBuffer; i++ {
+		b.Publish(Link{URL: fmt.Sprint(i)})
+	}
+	received := 0
+	for range ch2 {
+		received++
+	}
+	if received != eventsBuffer {
+		t.Errorf("unexpected number of buffered links. expected %d, got %d", eventsBuffer, received)
+	}
+
+	ch3, _ := b.Subscribe()
+	b.Close()
+	if _, ok := <-ch3; ok {
+		t.Error("channel should be closed after broker is closed")
+	}
+
+	ch4, _ := b.Subscribe()
+	if _, ok := <-ch4; ok {
+		t.Error("subscriptions of closed broker should be closed")
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	now := time.Now()
+	stored := []Link{
+		{Title: "old", URL: "http://example.com/old", Added: now.Add(-2 * time.Hour)},
+		{Title: "missed", URL: "http://example.com/missed", Added: now.Add(-1 * time.Hour)},
+		{Title: "other host", URL: "http://example.org/missed", Added: now.Add(-30 * time.Minute)},
+	}
+	broker := NewLinkBroker()
+	defer broker.Close()
+
+	server := httptest.NewServer(EventsHandler(&mockDatastore{links: stored}, broker, 24*time.Hour))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"?host=example.com", nil)
+	req.Header.Set("Last-Event-ID", fmt.Sprint(stored[0].Added.UnixNano()))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("could not connect:", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	events := readEvents(resp)
+
+	// Replayed link
+	expectEvent(t, events, "http://example.com/missed", stored[1].Added)
+
+	broker.Publish(Link{Title: "filtered", URL: "http://example.org/new", Added: now.Add(1 * time.Second)})
+	broker.Publish(Link{Title: "new", URL: "http://sub.example.com/new", Added: now.Add(2 * time.Second)})
+
+	expectEvent(t, events, "http://sub.example.com/new", now.Add(2*time.Second))
+}
+
+func TestEventsHandler_InvalidParameters(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		target string
+		header string
+	}{
+		{"invalid regex", "/events?regex=(", ""},
+		{"invalid last event id", "/events", "foo"},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tC.target, nil)
+			if tC.header != "" {
+				req.Header.Set("Last-Event-ID", tC.header)
+			}
+			w := httptest.NewRecorder()
+
+			EventsHandler(&mockDatastore{}, NewLinkBroker(), time.Hour).ServeHTTP(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("unexpected status code. expected %v, got %v", http.StatusBadRequest, w.Code)
+			}
+		})
+	}
+}
+
+type event struct {
+	id   string
+	typ  string
+	data string
+}
+
+// readEvents parses the Server-Sent Events of the response body.
+func readEvents(resp *http.Response) <-chan event {
+	events := make(chan event)
+	go func() {
+		defer close(events)
+		var e event
+		s := bufio.NewScanner(resp.Body)
+		for s.Scan() {
+			line := s.Text()
+			switch {
+			case line == "":
+				if e.data != "" {
+					events <- e
+				}
+				e = event{}
+			case strings.HasPrefix(line, "id: "):
+				e.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				e.typ = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				e.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return events
+}
+
+func expectEvent(t *testing.T, events <-chan event, url string, added time.Time) {
+	t.Helper()
+
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("event stream closed")
+		}
+
+		var link Link
+		if err := json.Unmarshal([]byte(e.data), &link); err != nil {
+			t.Fatal("invalid event data:", err)
+		}
+
+		if e.typ != "link" || e.id != fmt.Sprint(added.UnixNano()) || link.URL != url {
+			t.Errorf("unexpected event. expected link %q (id %d), got %+v", url, added.UnixNano(), e)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("timeout waiting for event %q", url)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ExportVersion is the current version of the export format.
+// Imports only accept exports up to this version.
+const ExportVersion = 1
+
+// Record types of the export format.
+const (
+	RecordHeader  = "header"
+	RecordItem    = "item"
+	RecordLink    = "link"
+	RecordAttempt = "attempt"
+	RecordFeed    = "feed"
+)
+
+// Record is a single line of the JSON Lines export format.
+// Depending on the type, exactly one of Item, Link, Attempt or Feed is set.
+// The first line of every export is a header record containing the format version.
+type Record struct {
+	Type    string      `json:"type"`
+	Version int         `json:"version,omitempty"`
+	Created *time.Time  `json:"created,omitempty"`
+	Item    *Item       `json:"item,omitempty"`
+	Link    *Link       `json:"link,omitempty"`
+	Attempt *Attempt    `json:"attempt,omitempty"`
+	Feed    *FeedConfig `json:"feed,omitempty"`
+}
+
+// ImportMode defines how imported records are merged with existing datastore entries.
+type ImportMode int
+
+const (
+	// ImportMerge keeps all existing entries and only adds new ones.
+	ImportMerge ImportMode = iota
+	// ImportReplace removes all existing entries before importing.
+	ImportReplace
+)
+
+// ParseImportMode returns the ImportMode for the given name ("merge" or "replace").
+func ParseImportMode(mode string) (ImportMode, error) {
+	switch mode {
+	case "merge":
+		return ImportMerge, nil
+	case "replace":
+		return ImportReplace, nil
+	}
+	return ImportMerge, errors.Errorf("unknown import mode %q", mode)
+}
+
+// Export writes all records of the datastore to w as versioned JSON Lines.
+// It returns the number of exported records (excluding the header).
+func Export(ds Datastore, w io.Writer) (int, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	now := time.Now().UTC()
+	header := Record{
+		Type:    RecordHeader,
+		Version: ExportVersion,
+		Created: &now,
+	}
+	if err := enc.Encode(header); err != nil {
+		return 0, errors.Wrap(err, "could not write header")
+	}
+
+	count := 0
+	err := ds.Walk(func(r Record) error {
+		if err := enc.Encode(r); err != nil {
+			return errors.Wrap(err, "could not write record")
+		}
+		count++
+		return nil
+	})
+
+	if err != nil {
+		return count, err
+	}
+
+	return count, bw.Flush()
+}
+
+// ImportStats contains the number of imported and skipped records.
+type ImportStats struct {
+	Imported int
+	Skipped  int
+}
+
+// Import reads records in the format written by Export from r and stores them in the datastore.
+// The records are decoded one at a time while they are restored (see Datastore.Restore),
+// so a malformed export does not leave a partially replaced datastore behind.
+func Import(ds Datastore, r io.Reader, mode ImportMode) (ImportStats, error) {
+	next, err := decodeRecords(r)
+	if err != nil {
+		return ImportStats{}, err
+	}
+
+	return ds.Restore(next, mode == ImportReplace)
+}
+
+// decodeRecords reads the header and returns a function that decodes and validates the next record.
+// It returns io.EOF after the last record.
+func decodeRecords(r io.Reader) (func() (Record, error), error) {
+	dec := json.NewDecoder(r)
+
+	var header Record
+	if err := dec.Decode(&header); err != nil {
+		return nil, errors.Wrap(err, "could not read header")
+	}
+	if header.Type != RecordHeader {
+		return nil, errors.New("missing export header")
+	}
+	if header.Version < 1 || header.Version > ExportVersion {
+		return nil, errors.Errorf("unsupported export version %d", header.Version)
+	}
+
+	line := 1
+	return func() (Record, error) {
+		line++
+
+		var rec Record
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			return rec, err
+		}
+		if err != nil {
+			return rec, errors.Wrapf(err, "could not decode record %d", line)
+		}
+		if err := validateRecord(rec); err != nil {
+			return rec, errors.Wrapf(err, "invalid record %d", line)
+		}
+		return rec, nil
+	}, nil
+}
+
+func validateRecord(r Record) error {
+	switch {
+	case r.Type == RecordItem && r.Item != nil && r.Item.URL != "":
+	case r.Type == RecordLink && r.Link != nil && r.Link.URL != "":
+	case r.Type == RecordAttempt && r.Attempt != nil && r.Attempt.Key != "":
+	case r.Type == RecordFeed && r.Feed != nil && r.Feed.URL != "":
+	default:
+		return errors.Errorf("unknown or incomplete record of type %q", r.Type)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	added := time.Date(2018, 8, 1, 12, 0, 0, 0, time.UTC)
+	src := &recordDatastore{records: []Record{
+		{Type: RecordItem, Item: &Item{Title: "item", URL: "http://example.com/item", Added: added}},
+		{Type: RecordLink, Link: &Link{Title: "link", URL: "http://example.com/link", Added: added}},
+		{Type: RecordAttempt, Attempt: &Attempt{Key: "http://example.com/item", Last: added, Count: 3}},
+	}}
+
+	var buf bytes.Buffer
+	n, err := Export(src, &buf)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if n != len(src.records) {
+		t.Errorf("unexpected number of exported records. expected %d, got %d", len(src.records), n)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != n+1 {
+		t.Errorf("unexpected number of lines. expected %d, got %d", n+1, lines)
+	}
+
+	t.Run("merge into empty datastore", func(t *testing.T) {
+		dst := &recordDatastore{}
+		stats, err := Import(dst, bytes.NewReader(buf.Bytes()), ImportMerge)
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if stats.Imported != 3 || stats.Skipped != 0 {
+			t.Errorf("unexpected import stats: %+v", stats)
+		}
+		if !dst.records[1].Link.Added.Equal(added) {
+			t.Errorf("added timestamp not preserved. expected %v, got %v", added, dst.records[1].Link.Added)
+		}
+	})
+
+	t.Run("merge skips existing entries", func(t *testing.T) {
+		dst := &recordDatastore{records: src.records[:1]}
+		stats, err := Import(dst, bytes.NewReader(buf.Bytes()), ImportMerge)
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if stats.Imported != 2 || stats.Skipped != 1 {
+			t.Errorf("unexpected import stats: %+v", stats)
+		}
+	})
+
+	t.Run("replace clears existing entries", func(t *testing.T) {
+		dst := &recordDatastore{records: []Record{{Type: RecordLink, Link: &Link{URL: "http://example.org"}}}}
+		stats, err := Import(dst, bytes.NewReader(buf.Bytes()), ImportReplace)
+		if err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if stats.Imported != 3 || len(dst.records) != 3 {
+			t.Errorf("unexpected import result: %+v, %d records", stats, len(dst.records))
+		}
+	})
+}
+
+func TestImport_Invalid(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		input string
+	}{
+		{"empty input", ""},
+		{"missing header", `{"type":"link","link":{"url":"http://example.com"}}`},
+		{"unsupported version", `{"type":"header","version":99}`},
+		{"unknown record type", "{\"type\":\"header\",\"version\":1}\n{\"type\":\"foo\"}"},
+		{"incomplete record", "{\"type\":\"header\",\"version\":1}\n{\"type\":\"link\"}"},
+		{"malformed record", "{\"type\":\"header\",\"version\":1}\n{\"type\":"},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			ds := &recordDatastore{records: []Record{{Type: RecordLink, Link: &Link{URL: "http://example.org"}}}}
+			if _, err := Import(ds, strings.NewReader(tC.input), ImportReplace); err == nil {
+				t.Error("expected error, got nil")
+			}
+			if len(ds.records) != 1 {
+				t.Error("datastore should not be modified on invalid input")
+			}
+		})
+	}
+}
+
+func TestParseImportMode(t *testing.T) {
+	if m, err := ParseImportMode("merge"); err != nil || m != ImportMerge {
+		t.Errorf("unexpected result for merge: %v, %v", m, err)
+	}
+	if m, err := ParseImportMode("replace"); err != nil || m != ImportReplace {
+		t.Errorf("unexpected result for replace: %v, %v", m, err)
+	}
+	if _, err := ParseImportMode("foo"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+// recordDatastore is a minimal in-memory Datastore for testing exports and imports.
+type recordDatastore struct {
+	Datastore
+
+	records []Record
+}
+
+func (ds *recordDatastore) Walk(fn func(Record) error) error {
+	for _, r := range ds.records {
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ds *recordDatastore) Restore(next func() (Record, error), replace bool) (ImportStats, error) {
+	var stats ImportStats
+	var records []Record
+	if !replace {
+		records = append(records, ds.records...)
+	}
+
+	for {
+		r, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ImportStats{}, err
+		}
+		if containsRecord(records, r) {
+			stats.Skipped++
+			continue
+		}
+		records = append(records, r)
+		stats.Imported++
+	}
+
+	ds.records = records
+	return stats, nil
+}
+
+func containsRecord(records []Record, r Record) bool {
+	for _, e := range records {
+		if e.Type == r.Type && recordKey(e) == recordKey(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func recordKey(r Record) string {
+	switch {
+	case r.Item != nil:
+		return r.Item.URL
+	case r.Link != nil:
+		return r.Link.URL
+	case r.Attempt != nil:
+		return r.Attempt.Key
+	}
+	return ""
+}
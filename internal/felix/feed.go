@@ -0,0 +1,322 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// feed is the format independent representation of the output feed.
+type feed struct {
+	Title       string
+	Description string
+	// Link is the website corresponding to the feed, defaults to URL
+	Link string
+	// URL is the URL the feed is served at
+	URL     string
+	TTL     time.Duration
+	PubDate time.Time
+	Links   []Link
+}
+
+// updated returns the time of the most recently added link or PubDate, if there are no links.
+func (f *feed) updated() time.Time {
+	var updated time.Time
+	for _, link := range f.Links {
+		if link.Added.After(updated) {
+			updated = link.Added
+		}
+	}
+	if updated.IsZero() {
+		return f.PubDate
+	}
+	return updated
+}
+
+// feedFormat is an output format supported by FeedHandler.
+type feedFormat struct {
+	name        string
+	contentType string
+	encode      func(w io.Writer, f *feed) error
+}
+
+var (
+	rssFormat  = feedFormat{"rss", "application/rss+xml", encodeRSS}
+	atomFormat = feedFormat{"atom", "application/atom+xml", encodeAtom}
+	jsonFormat = feedFormat{"json", "application/feed+json", encodeJSONFeed}
+
+	feedFormats = []feedFormat{rssFormat, atomFormat, jsonFormat}
+)
+
+// feedFormatAliases maps path suffixes and media types to their feed formats.
+var feedFormatAliases = map[string]feedFormat{
+	".rss":  rssFormat,
+	".xml":  rssFormat,
+	".atom": atomFormat,
+	".json": jsonFormat,
+
+	"application/rss+xml":   rssFormat,
+	"application/atom+xml":  atomFormat,
+	"application/feed+json": jsonFormat,
+	"application/json":      jsonFormat,
+}
+
+// FeedPaths returns the paths a feed served at the given path should be registered at,
+// i.e. the path itself and the path with each of the supported format suffixes.
+func FeedPaths(p string) []string {
+	paths := []string{p}
+	if strings.HasSuffix(p, "/") {
+		// Covered by the subtree pattern
+		return paths
+	}
+	for _, ext := range []string{".rss", ".xml", ".atom", ".json"} {
+		paths = append(paths, p+ext)
+	}
+	return paths
+}
+
+// negotiateFeedFormat returns the requested feed format. The format is selected by
+// the "format" parameter, the path suffix (e.g. "/feed.atom") or the Accept header,
+// in that order. It defaults to RSS and reports false for unknown "format" parameters.
+func negotiateFeedFormat(r *http.Request) (feedFormat, bool) {
+	if name := r.FormValue("format"); name != "" {
+		for _, f := range feedFormats {
+			if f.name == name {
+				return f, true
+			}
+		}
+		return rssFormat, false
+	}
+
+	if f, ok := feedFormatAliases[strings.ToLower(path.Ext(r.URL.Path))]; ok {
+		return f, true
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		if f, ok := feedFormatAliases[mediaType]; ok {
+			return f, true
+		}
+	}
+
+	return rssFormat, true
+}
+
+// RSS 2.0, see http://www.rssboard.org/rss-specification
+
+const rssTimeFormat = time.RFC1123Z
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	PubDate     string    `xml:"pubDate"`
+	TTL         int       `xml:"ttl,omitempty"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string     `xml:"title"`
+	GUID        string     `xml:"guid"`
+	Link        string     `xml:"link"`
+	Description string     `xml:"description"`
+	PubDate     string     `xml:"pubDate"`
+	Source      *rssSource `xml:"source,omitempty"`
+}
+
+type rssSource struct {
+	URL   string `xml:"url,attr"`
+	Title string `xml:",chardata"`
+}
+
+func encodeRSS(w io.Writer, f *feed) error {
+	rf := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       f.Title,
+			Link:        f.Link,
+			Description: f.Description,
+			PubDate:     f.updated().Format(rssTimeFormat),
+			TTL:         int(f.TTL / time.Minute),
+		},
+	}
+
+	for _, link := range f.Links {
+		item := rssItem{
+			Title:       link.Title,
+			GUID:        link.URL,
+			Link:        link.URL,
+			Description: link.Title,
+			PubDate:     addedOr(link.Added, f.PubDate).Format(rssTimeFormat),
+		}
+		if link.Source != "" {
+			// Provenance of the link, i.e. the page it was found on
+			item.Source = &rssSource{URL: link.Source, Title: link.Source}
+		}
+		rf.Channel.Items = append(rf.Channel.Items, item)
+	}
+
+	return encodeXML(w, rf)
+}
+
+// Atom 1.0, see https://tools.ietf.org/html/rfc4287
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Author   atomPerson  `xml:"author"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID      string     `xml:"id"`
+	Title   string     `xml:"title"`
+	Updated string     `xml:"updated"`
+	Links   []atomLink `xml:"link"`
+}
+
+func encodeAtom(w io.Writer, f *feed) error {
+	af := atomFeed{
+		ID:       f.URL,
+		Title:    f.Title,
+		Subtitle: f.Description,
+		Updated:  f.updated().Format(time.RFC3339),
+		Author:   atomPerson{Name: "felix"},
+		Links:    []atomLink{{Href: f.URL, Rel: "self"}},
+	}
+
+	if f.Link != f.URL {
+		af.Links = append(af.Links, atomLink{Href: f.Link, Rel: "alternate"})
+	}
+
+	for _, link := range f.Links {
+		entry := atomEntry{
+			ID:      link.URL,
+			Title:   link.Title,
+			Updated: addedOr(link.Added, f.PubDate).Format(time.RFC3339),
+			Links:   []atomLink{{Href: link.URL, Rel: "alternate"}},
+		}
+		if link.Source != "" {
+			// Provenance of the link, i.e. the page it was found on
+			entry.Links = append(entry.Links, atomLink{Href: link.Source, Rel: "via"})
+		}
+		af.Entries = append(af.Entries, entry)
+	}
+
+	return encodeXML(w, af)
+}
+
+// encodeXML writes v as an indented XML document to w.
+func encodeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// JSON Feed 1.1, see https://jsonfeed.org/version/1.1
+
+const jsonFeedVersion = "https://jsonfeed.org/version/1.1"
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	HomePageURL string         `json:"home_page_url,omitempty"`
+	FeedURL     string         `json:"feed_url,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string                `json:"id"`
+	URL           string                `json:"url"`
+	Title         string                `json:"title,omitempty"`
+	ContentText   string                `json:"content_text"`
+	DatePublished string                `json:"date_published,omitempty"`
+	Felix         jsonFeedItemExtension `json:"_felix"`
+}
+
+// jsonFeedItemExtension is the custom JSON Feed extension for felix specific link data.
+type jsonFeedItemExtension struct {
+	About  string     `json:"about"`
+	Source string     `json:"source,omitempty"`
+	Status LinkStatus `json:"status,omitempty"`
+}
+
+func encodeJSONFeed(w io.Writer, f *feed) error {
+	jf := jsonFeed{
+		Version:     jsonFeedVersion,
+		Title:       f.Title,
+		Description: f.Description,
+		HomePageURL: f.Link,
+		FeedURL:     f.URL,
+		Items:       make([]jsonFeedItem, 0, len(f.Links)),
+	}
+
+	for _, link := range f.Links {
+		jf.Items = append(jf.Items, jsonFeedItem{
+			ID:            link.URL,
+			URL:           link.URL,
+			Title:         link.Title,
+			ContentText:   link.Title,
+			DatePublished: addedOr(link.Added, f.PubDate).Format(time.RFC3339),
+			Felix: jsonFeedItemExtension{
+				About:  "https://github.com/martinplaner/felix",
+				Source: link.Source,
+				Status: link.Status,
+			},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	return enc.Encode(jf)
+}
+
+// addedOr returns added, or t if added is not set.
+func addedOr(added, t time.Time) time.Time {
+	if added.IsZero() {
+		return t
+	}
+	return added
+}
@@ -0,0 +1,274 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+var feedTestLinks = []Link{
+	{Title: "title1", URL: "http://example.com/1", Source: "http://example.com/item", Added: time.Now().Add(-1 * time.Hour)},
+	{Title: "title2", URL: "http://example.org/2", Added: time.Now()},
+}
+
+var feedTestLinksEscaping = []Link{
+	{Title: "Tom & Jerry <1080p>", URL: "http://example.com/dl?a=1&b=2", Source: "http://example.com/item?id=1&p=2"},
+}
+
+// feedValidators validate the encoded output of each feed format against its spec.
+var feedValidators = map[string]func(t *testing.T, body []byte, links []Link){
+	"rss":  validateRSS,
+	"atom": validateAtom,
+	"json": validateJSONFeed,
+}
+
+func TestFeedFormats(t *testing.T) {
+	testCases := []struct {
+		desc  string
+		links []Link
+	}{
+		{"no links", nil},
+		{"two links", feedTestLinks},
+		{"special characters", feedTestLinksEscaping},
+	}
+
+	for _, tC := range testCases {
+		for _, format := range feedFormats {
+			t.Run(format.name+" "+tC.desc, func(t *testing.T) {
+				f := &feed{
+					Title:       "felix & friends",
+					Description: "<links>",
+					Link:        "http://localhost:6554/?a=1&b=2",
+					URL:         "http://localhost:6554/",
+					PubDate:     time.Now(),
+					Links:       tC.links,
+				}
+
+				var buf bytes.Buffer
+				if err := format.encode(&buf, f); err != nil {
+					t.Fatal("could not encode feed:", err)
+				}
+
+				feedValidators[format.name](t, buf.Bytes(), tC.links)
+			})
+		}
+	}
+}
+
+func TestNegotiateFeedFormat(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		target   string
+		accept   string
+		expected string
+		ok       bool
+	}{
+		{"default", "/", "", "rss", true},
+		{"format parameter", "/?format=atom", "", "atom", true},
+		{"unknown format parameter", "/?format=foo", "", "rss", false},
+		{"format parameter before path suffix", "/feed.json?format=atom", "", "atom", true},
+		{"path suffix atom", "/feed.atom", "", "atom", true},
+		{"path suffix json", "/feed.json", "", "json", true},
+		{"path suffix rss", "/feed.rss", "", "rss", true},
+		{"path suffix before accept header", "/feed.rss", "application/atom+xml", "rss", true},
+		{"accept header atom", "/", "application/atom+xml", "atom", true},
+		{"accept header json feed", "/", "application/feed+json, application/json;q=0.9", "json", true},
+		{"accept header unknown", "/", "text/html, */*", "rss", true},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tC.target, nil)
+			if tC.accept != "" {
+				req.Header.Set("Accept", tC.accept)
+			}
+
+			format, ok := negotiateFeedFormat(req)
+
+			if format.name != tC.expected || ok != tC.ok {
+				t.Errorf("unexpected format. expected %v (%v), got %v (%v)", tC.expected, tC.ok, format.name, ok)
+			}
+		})
+	}
+}
+
+func validateRSS(t *testing.T, body []byte, links []Link) {
+	t.Helper()
+
+	var rss struct {
+		XMLName xml.Name `xml:"rss"`
+		Version string   `xml:"version,attr"`
+		Channel struct {
+			Title       string `xml:"title"`
+			Link        string `xml:"link"`
+			Description string `xml:"description"`
+			Items       []struct {
+				Title   string `xml:"title"`
+				Link    string `xml:"link"`
+				PubDate string `xml:"pubDate"`
+				Source  struct {
+					URL string `xml:"url,attr"`
+				} `xml:"source"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+
+	if err := xml.Unmarshal(body, &rss); err != nil {
+		t.Fatal("invalid XML:", err)
+	}
+
+	if rss.Version != "2.0" {
+		t.Errorf("unexpected RSS version %q", rss.Version)
+	}
+
+	if rss.Channel.Title == "" || rss.Channel.Link == "" || rss.Channel.Description == "" {
+		t.Error("channel is missing required elements (title, link, description)")
+	}
+
+	if len(rss.Channel.Items) != len(links) {
+		t.Fatalf("unexpected number of items. expected %d, got %d", len(links), len(rss.Channel.Items))
+	}
+
+	for i, item := range rss.Channel.Items {
+		if item.Title != links[i].Title || item.Link != links[i].URL || item.Source.URL != links[i].Source {
+			t.Errorf("item %d: expected %q (%q), got %q (%q)", i, links[i].Title, links[i].URL, item.Title, item.Link)
+		}
+
+		pubDate, err := time.Parse(time.RFC1123Z, item.PubDate)
+		if err != nil {
+			t.Errorf("item %d: invalid pubDate: %v", i, err)
+		}
+
+		if !links[i].Added.IsZero() && !pubDate.Equal(links[i].Added.Truncate(time.Second)) {
+			t.Errorf("item %d: pubDate should be the time the link was added. expected %v, got %v", i, links[i].Added, pubDate)
+		}
+	}
+
+	validateWithParser(t, body, links)
+}
+
+func validateAtom(t *testing.T, body []byte, links []Link) {
+	t.Helper()
+
+	var atom atomFeed
+	if err := xml.Unmarshal(body, &atom); err != nil {
+		t.Fatal("invalid XML:", err)
+	}
+
+	if atom.XMLName.Space != "http://www.w3.org/2005/Atom" {
+		t.Errorf("unexpected namespace %q", atom.XMLName.Space)
+	}
+
+	if atom.ID == "" || atom.Title == "" || atom.Author.Name == "" {
+		t.Error("feed is missing required elements (id, title, author)")
+	}
+
+	if _, err := time.Parse(time.RFC3339, atom.Updated); err != nil {
+		t.Error("invalid feed updated date:", err)
+	}
+
+	if len(atom.Entries) != len(links) {
+		t.Fatalf("unexpected number of entries. expected %d, got %d", len(links), len(atom.Entries))
+	}
+
+	for i, entry := range atom.Entries {
+		if entry.ID == "" || entry.Title != links[i].Title {
+			t.Errorf("entry %d: missing id or unexpected title %q", i, entry.Title)
+		}
+
+		if _, err := time.Parse(time.RFC3339, entry.Updated); err != nil {
+			t.Errorf("entry %d: invalid updated date: %v", i, err)
+		}
+
+		rels := make(map[string]string)
+		for _, l := range entry.Links {
+			rels[l.Rel] = l.Href
+		}
+
+		if rels["alternate"] != links[i].URL {
+			t.Errorf("entry %d: unexpected alternate link. expected %q, got %q", i, links[i].URL, rels["alternate"])
+		}
+
+		if rels["via"] != links[i].Source {
+			t.Errorf("entry %d: unexpected via link. expected %q, got %q", i, links[i].Source, rels["via"])
+		}
+	}
+
+	validateWithParser(t, body, links)
+}
+
+func validateJSONFeed(t *testing.T, body []byte, links []Link) {
+	t.Helper()
+
+	var jf struct {
+		Version string `json:"version"`
+		Title   string `json:"title"`
+		Items   []struct {
+			ID          *string `json:"id"`
+			URL         string  `json:"url"`
+			Title       string  `json:"title"`
+			ContentText *string `json:"content_text"`
+			ContentHTML *string `json:"content_html"`
+			Date        string  `json:"date_published"`
+			Felix       struct {
+				Source string `json:"source"`
+			} `json:"_felix"`
+		} `json:"items"`
+	}
+
+	if err := json.Unmarshal(body, &jf); err != nil {
+		t.Fatal("invalid JSON:", err)
+	}
+
+	if jf.Version != jsonFeedVersion || jf.Title == "" {
+		t.Error("feed is missing required elements (version, title)")
+	}
+
+	if jf.Items == nil || len(jf.Items) != len(links) {
+		t.Fatalf("unexpected items. expected %d, got %v", len(links), jf.Items)
+	}
+
+	for i, item := range jf.Items {
+		if item.ID == nil || (item.ContentText == nil && item.ContentHTML == nil) {
+			t.Errorf("item %d: missing required id or content", i)
+		}
+
+		if item.Title != links[i].Title || item.URL != links[i].URL || item.Felix.Source != links[i].Source {
+			t.Errorf("item %d: unexpected link data %+v", i, item)
+		}
+
+		if _, err := time.Parse(time.RFC3339, item.Date); err != nil {
+			t.Errorf("item %d: invalid date_published: %v", i, err)
+		}
+	}
+}
+
+// validateWithParser checks that the output can be read by common feed readers.
+func validateWithParser(t *testing.T, body []byte, links []Link) {
+	t.Helper()
+
+	feed, err := gofeed.NewParser().Parse(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal("could not parse feed:", err)
+	}
+
+	if len(feed.Items) != len(links) {
+		t.Fatalf("unexpected number of parsed items. expected %d, got %d", len(links), len(feed.Items))
+	}
+
+	for i, item := range feed.Items {
+		if strings.TrimSpace(item.Title) != links[i].Title {
+			t.Errorf("parsed item %d: unexpected title. expected %q, got %q", i, links[i].Title, item.Title)
+		}
+	}
+}
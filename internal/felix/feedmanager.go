@@ -0,0 +1,345 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"net/url"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrFeedExists is returned when adding a feed with the URL of an existing feed.
+	ErrFeedExists = errors.New("feed already exists")
+	// ErrFeedConfigured is returned when removing a feed that is defined in the config file.
+	ErrFeedConfigured = errors.New("feed is defined in the config file")
+	// ErrInvalidFeed is the cause of all errors caused by invalid feed configurations.
+	ErrInvalidFeed = errors.New("invalid feed")
+)
+
+// FeedLister wraps the Feeds method, which returns the configuration of all feeds.
+type FeedLister interface {
+	Feeds() []FeedConfig
+}
+
+// FeedList is a static FeedLister.
+type FeedList []FeedConfig
+
+// Feeds returns the list of feeds.
+func (l FeedList) Feeds() []FeedConfig {
+	return l
+}
+
+// FeedManager runs the fetchers of all feeds and allows to add, remove, pause and resume feeds at runtime.
+// Changes made at runtime are persisted in the Datastore. For feeds defined in the config file,
+// only the paused state is persisted; all other settings are taken from the config file on restart.
+type FeedManager struct {
+	mu         sync.Mutex
+	ds         Datastore
+	registry   *FetcherRegistry
+	newFetcher func(FeedConfig) (*Fetcher, error)
+	log        Logger
+	quit       <-chan struct{}
+	wg         *sync.WaitGroup
+	feeds      []*managedFeed
+}
+
+type managedFeed struct {
+	config FeedConfig
+	// configured is true for feeds defined in the config file
+	configured bool
+	// stop stops the running fetcher, nil if it is not running
+	stop chan struct{}
+}
+
+// NewFeedManager creates a new FeedManager. The fetchers of all feeds are created by newFetcher
+// and registered in the given registry while they are running.
+func NewFeedManager(ds Datastore, registry *FetcherRegistry, newFetcher func(FeedConfig) (*Fetcher, error)) *FeedManager {
+	return &FeedManager{
+		ds:         ds,
+		registry:   registry,
+		newFetcher: newFetcher,
+		log:        &NopLogger{},
+	}
+}
+
+// SetLogger sets the logger that is used by the feed manager.
+// FeedManager does not log when no Logger is set.
+func (m *FeedManager) SetLogger(log Logger) {
+	m.log = log
+}
+
+// Start starts the fetchers of the configured and the stored feeds, unless they are paused.
+// The fetchers run until quit is closed; wg can be used to wait for all of them to finish.
+func (m *FeedManager) Start(configured []FeedConfig, quit <-chan struct{}, wg *sync.WaitGroup) error {
+	stored, err := m.ds.GetFeeds()
+	if err != nil {
+		return errors.Wrap(err, "could not get stored feeds")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.quit = quit
+	m.wg = wg
+
+	for _, url := range StaleFeeds(configured, stored) {
+		if err := m.ds.RemoveFeed(url); err != nil && err != ErrNotFound {
+			return errors.Wrapf(err, "could not remove state of feed %s", url)
+		}
+		m.log.Info("removed state of feed that is no longer configured", "url", url)
+	}
+
+	for i, fc := range MergeFeeds(configured, stored) {
+		m.feeds = append(m.feeds, &managedFeed{config: fc, configured: i < len(configured)})
+	}
+
+	for _, mf := range m.feeds {
+		if _, err := m.newFetcher(mf.config); err != nil {
+			return errors.Wrapf(err, "invalid feed %s", mf.config.URL)
+		}
+	}
+
+	for _, mf := range m.feeds {
+		if !mf.config.Paused {
+			m.start(mf)
+		}
+	}
+
+	return nil
+}
+
+// MergeFeeds returns the configured feeds with their paused state from the stored feeds,
+// followed by the stored feeds that were added at runtime. The config file is authoritative
+// for all other settings. Stored states of feeds that are no longer configured are ignored (see StaleFeeds).
+func MergeFeeds(configured, stored []FeedConfig) []FeedConfig {
+	feeds := append([]FeedConfig(nil), configured...)
+
+	index := make(map[string]int, len(feeds))
+	for i, fc := range feeds {
+		index[fc.URL] = i
+	}
+
+	for _, fc := range stored {
+		if i, ok := index[fc.URL]; ok {
+			feeds[i].Paused = fc.Paused
+			continue
+		}
+		if fc.Configured {
+			continue
+		}
+		index[fc.URL] = len(feeds)
+		feeds = append(feeds, fc)
+	}
+
+	return feeds
+}
+
+// StaleFeeds returns the URLs of the stored states of feeds that are no longer defined in the config file.
+func StaleFeeds(configured, stored []FeedConfig) []string {
+	urls := make(map[string]bool, len(configured))
+	for _, fc := range configured {
+		urls[fc.URL] = true
+	}
+
+	var stale []string
+	for _, fc := range stored {
+		if fc.Configured && !urls[fc.URL] {
+			stale = append(stale, fc.URL)
+		}
+	}
+	return stale
+}
+
+// Feeds returns the configuration of all feeds.
+func (m *FeedManager) Feeds() []FeedConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	feeds := make([]FeedConfig, 0, len(m.feeds))
+	for _, mf := range m.feeds {
+		feeds = append(feeds, mf.config)
+	}
+	return feeds
+}
+
+// Add adds and starts a new feed. It returns ErrFeedExists if a feed with the same URL does already exist.
+func (m *FeedManager) Add(fc FeedConfig) error {
+	if u, err := url.Parse(fc.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return errors.Wrapf(ErrInvalidFeed, "invalid URL %q", fc.URL)
+	}
+	if _, err := m.newFetcher(fc); err != nil {
+		return errors.Wrap(ErrInvalidFeed, err.Error())
+	}
+	fc.Configured = false
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.find(fc.URL) != nil {
+		return ErrFeedExists
+	}
+
+	if err := m.ds.StoreFeed(fc); err != nil {
+		return errors.Wrap(err, "could not store feed")
+	}
+
+	mf := &managedFeed{config: fc}
+	m.feeds = append(m.feeds, mf)
+	if !fc.Paused {
+		m.start(mf)
+	}
+
+	m.log.Info("added feed", "url", fc.URL)
+	return nil
+}
+
+// Remove stops and removes the feed with the given URL. Feeds defined in the config file
+// cannot be removed at runtime (ErrFeedConfigured), but they can be paused instead.
+func (m *FeedManager) Remove(url string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mf := m.find(url)
+	if mf == nil {
+		return ErrNotFound
+	}
+	if mf.configured {
+		return ErrFeedConfigured
+	}
+
+	if err := m.ds.RemoveFeed(url); err != nil && err != ErrNotFound {
+		return errors.Wrap(err, "could not remove feed")
+	}
+
+	m.stop(mf)
+	for i := range m.feeds {
+		if m.feeds[i] == mf {
+			m.feeds = append(m.feeds[:i], m.feeds[i+1:]...)
+			break
+		}
+	}
+
+	m.log.Info("removed feed", "url", url)
+	return nil
+}
+
+// Pause stops fetching the feed with the given URL until it is resumed.
+func (m *FeedManager) Pause(url string) error {
+	return m.setPaused(url, true)
+}
+
+// Resume restarts fetching the paused feed with the given URL.
+func (m *FeedManager) Resume(url string) error {
+	return m.setPaused(url, false)
+}
+
+func (m *FeedManager) setPaused(url string, paused bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mf := m.find(url)
+	if mf == nil {
+		return ErrNotFound
+	}
+
+	if mf.config.Paused == paused {
+		return nil
+	}
+
+	fc := mf.config
+	fc.Paused = paused
+	if err := m.storeState(mf, fc); err != nil {
+		return err
+	}
+	mf.config = fc
+
+	if paused {
+		m.stop(mf)
+		m.log.Info("paused feed", "url", url)
+	} else {
+		m.start(mf)
+		m.log.Info("resumed feed", "url", url)
+	}
+
+	return nil
+}
+
+// storeState persists the changed configuration of the feed. Feeds added at runtime are stored as a whole.
+// For configured feeds only the paused state is stored, so later changes of the config file are not overridden.
+// m.mu must be held.
+func (m *FeedManager) storeState(mf *managedFeed, fc FeedConfig) error {
+	if !mf.configured {
+		if err := m.ds.StoreFeed(fc); err != nil {
+			return errors.Wrap(err, "could not store feed")
+		}
+		return nil
+	}
+
+	if !fc.Paused {
+		if err := m.ds.RemoveFeed(fc.URL); err != nil && err != ErrNotFound {
+			return errors.Wrap(err, "could not remove feed state")
+		}
+		return nil
+	}
+
+	if err := m.ds.StoreFeed(FeedConfig{URL: fc.URL, Paused: true, Configured: true}); err != nil {
+		return errors.Wrap(err, "could not store feed state")
+	}
+	return nil
+}
+
+// find returns the managed feed with the given URL or nil. m.mu must be held.
+func (m *FeedManager) find(url string) *managedFeed {
+	for _, mf := range m.feeds {
+		if mf.config.URL == url {
+			return mf
+		}
+	}
+	return nil
+}
+
+// start starts the fetcher of the feed, unless the manager is shutting down. m.mu must be held.
+func (m *FeedManager) start(mf *managedFeed) {
+	select {
+	case <-m.quit:
+		return
+	default:
+	}
+
+	f, err := m.newFetcher(mf.config)
+	if err != nil {
+		m.log.Error("could not create fetcher", "err", err, "url", mf.config.URL)
+		return
+	}
+
+	stop := make(chan struct{})
+	mf.stop = stop
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		quit := make(chan struct{})
+		go func() {
+			select {
+			case <-m.quit:
+			case <-stop:
+			}
+			close(quit)
+		}()
+
+		m.registry.Run(f, quit)
+	}()
+}
+
+// stop stops the fetcher of the feed, if it is running. m.mu must be held.
+func (m *FeedManager) stop(mf *managedFeed) {
+	if mf.stop != nil {
+		close(mf.stop)
+		mf.stop = nil
+	}
+}
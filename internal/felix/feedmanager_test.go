@@ -0,0 +1,195 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestFeedManager(t *testing.T) {
+	ds := &mockDatastore{feeds: map[string]FeedConfig{
+		"http://example.com/a":   {Type: "unknown", URL: "http://example.com/a", Paused: true, Configured: true},
+		"http://example.com/b":   {Type: "rss", URL: "http://example.com/b"},
+		"http://example.com/old": {URL: "http://example.com/old", Paused: true, Configured: true},
+	}}
+	registry := NewFetcherRegistry()
+	m := NewFeedManager(ds, registry, newTestFeedFetcher)
+
+	quit := make(chan struct{})
+	var wg sync.WaitGroup
+
+	configured := []FeedConfig{
+		{Type: "rss", URL: "http://example.com/a"},
+		{Type: "rss", URL: "http://example.com/c"},
+	}
+	if err := m.Start(configured, quit, &wg); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if feeds := m.Feeds(); len(feeds) != 3 || !feeds[0].Paused || feeds[0].Type != "rss" {
+		t.Errorf("stored feeds should be added and only override the paused state of configured feeds, got %v", feeds)
+	}
+	if _, ok := ds.feeds["http://example.com/old"]; ok {
+		t.Error("state of feed that is no longer configured was not removed")
+	}
+	waitForFetchers(t, registry, "http://example.com/b", "http://example.com/c")
+
+	t.Run("add", func(t *testing.T) {
+		if err := m.Add(FeedConfig{Type: "rss", URL: "http://example.com/d"}); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		waitForFetchers(t, registry, "http://example.com/b", "http://example.com/c", "http://example.com/d")
+
+		if _, ok := ds.feeds["http://example.com/d"]; !ok {
+			t.Error("added feed was not stored")
+		}
+
+		if err := m.Add(FeedConfig{Type: "rss", URL: "http://example.com/d"}); err != ErrFeedExists {
+			t.Errorf("unexpected error. expected %v, got %v", ErrFeedExists, err)
+		}
+
+		if err := m.Add(FeedConfig{Type: "unknown", URL: "http://example.com/e"}); errors.Cause(err) != ErrInvalidFeed {
+			t.Errorf("unexpected error. expected %v, got %v", ErrInvalidFeed, err)
+		}
+
+		if err := m.Add(FeedConfig{Type: "rss", URL: "example.com/e"}); errors.Cause(err) != ErrInvalidFeed {
+			t.Errorf("unexpected error. expected %v, got %v", ErrInvalidFeed, err)
+		}
+	})
+
+	t.Run("pause and resume", func(t *testing.T) {
+		if err := m.Pause("http://example.com/c"); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		waitForFetchers(t, registry, "http://example.com/b", "http://example.com/d")
+
+		expected := FeedConfig{URL: "http://example.com/c", Paused: true, Configured: true}
+		if ds.feeds["http://example.com/c"] != expected {
+			t.Errorf("unexpected stored state of configured feed. expected %v, got %v", expected, ds.feeds["http://example.com/c"])
+		}
+
+		if err := m.Resume("http://example.com/a"); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		waitForFetchers(t, registry, "http://example.com/a", "http://example.com/b", "http://example.com/d")
+
+		if _, ok := ds.feeds["http://example.com/a"]; ok {
+			t.Error("state of resumed configured feed is still stored")
+		}
+
+		if err := m.Pause("http://example.com/b"); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		if fc := ds.feeds["http://example.com/b"]; fc.Type != "rss" || !fc.Paused || fc.Configured {
+			t.Errorf("unexpected stored feed added at runtime: %v", fc)
+		}
+		if err := m.Resume("http://example.com/b"); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		waitForFetchers(t, registry, "http://example.com/a", "http://example.com/b", "http://example.com/d")
+
+		if err := m.Pause("http://example.com/x"); err != ErrNotFound {
+			t.Errorf("unexpected error. expected %v, got %v", ErrNotFound, err)
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		if err := m.Remove("http://example.com/a"); err != ErrFeedConfigured {
+			t.Errorf("unexpected error. expected %v, got %v", ErrFeedConfigured, err)
+		}
+
+		if err := m.Remove("http://example.com/d"); err != nil {
+			t.Fatal("unexpected error:", err)
+		}
+		waitForFetchers(t, registry, "http://example.com/a", "http://example.com/b")
+
+		if _, ok := ds.feeds["http://example.com/d"]; ok {
+			t.Error("removed feed is still stored")
+		}
+
+		if len(m.Feeds()) != 3 {
+			t.Errorf("unexpected number of feeds. expected %d, got %d", 3, len(m.Feeds()))
+		}
+	})
+
+	close(quit)
+	wg.Wait()
+
+	if registry.Len() != 0 {
+		t.Errorf("all fetchers should be stopped, got %d", registry.Len())
+	}
+}
+
+func TestFeedManager_StartInvalid(t *testing.T) {
+	m := NewFeedManager(&mockDatastore{}, NewFetcherRegistry(), newTestFeedFetcher)
+
+	err := m.Start([]FeedConfig{{Type: "unknown", URL: "http://example.com"}}, make(chan struct{}), &sync.WaitGroup{})
+	if err == nil {
+		t.Error("expected error for invalid feed type")
+	}
+}
+
+func TestMergeFeeds(t *testing.T) {
+	configured := []FeedConfig{
+		{Type: "rss", URL: "http://example.com/a"},
+		{Type: "rss", URL: "http://example.com/b", FetchInterval: 1 * time.Hour},
+	}
+	stored := []FeedConfig{
+		{URL: "http://example.com/b", Paused: true, Configured: true},
+		{Type: "rss", URL: "http://example.com/c"},
+		{URL: "http://example.com/removed", Paused: true, Configured: true},
+	}
+
+	expected := []FeedConfig{
+		{Type: "rss", URL: "http://example.com/a"},
+		{Type: "rss", URL: "http://example.com/b", FetchInterval: 1 * time.Hour, Paused: true},
+		{Type: "rss", URL: "http://example.com/c"},
+	}
+
+	if got := MergeFeeds(configured, stored); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected feeds. expected %v, got %v", expected, got)
+	}
+
+	if got := StaleFeeds(configured, stored); !reflect.DeepEqual(got, []string{"http://example.com/removed"}) {
+		t.Errorf("unexpected stale feeds. expected %v, got %v", []string{"http://example.com/removed"}, got)
+	}
+}
+
+func newTestFeedFetcher(fc FeedConfig) (*Fetcher, error) {
+	if fc.Type != "rss" {
+		return nil, fmt.Errorf("unknown feed type %q", fc.Type)
+	}
+	return NewFetcher(fc.URL, nil, nil, waitAttempter(1*time.Hour), nil, nil), nil
+}
+
+// waitForFetchers waits until exactly the fetchers for the given URLs are running in the registry.
+func waitForFetchers(t *testing.T, registry *FetcherRegistry, urls ...string) {
+	t.Helper()
+
+	deadline := time.Now().Add(1 * time.Second)
+	for {
+		var running []string
+		for _, f := range registry.Fetchers() {
+			if !f.NextAttempt().IsZero() {
+				running = append(running, f.URL())
+			}
+		}
+
+		if fmt.Sprint(running) == fmt.Sprint(urls) && registry.Len() == len(urls) {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected running fetchers. expected %v, got %v", urls, running)
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+}
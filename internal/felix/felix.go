@@ -6,17 +6,96 @@ package felix
 
 import (
 	"time"
+
+	"github.com/pkg/errors"
 )
 
+// ErrNotFound is returned when a requested entry does not exist.
+var ErrNotFound = errors.New("not found")
+
 // Item is a feed item that should be scraped for links.
 type Item struct {
-	Title   string
-	URL     string
-	PubDate time.Time
+	Title   string    `json:"title"`
+	URL     string    `json:"url"`
+	PubDate time.Time `json:"pubDate"`
+	// Added is the time the item was first stored. It is set by the Datastore.
+	Added time.Time `json:"added"`
+	// State is the state of the item's page fetcher. It is set by the Datastore.
+	State FetcherState `json:"state"`
 }
 
+// FetcherState is the persistent state of an item page fetcher.
+type FetcherState string
+
+// Available fetcher states. Fetchers of new items always start as FetcherActive.
+const (
+	// FetcherActive fetchers are still trying to fetch the page and are restored on startup.
+	FetcherActive FetcherState = "active"
+	// FetcherExhausted fetchers have used up all their attempts.
+	FetcherExhausted FetcherState = "exhausted"
+	// FetcherFailed fetchers have stopped due to a permanent error, e.g. a page that is gone.
+	FetcherFailed FetcherState = "failed"
+)
+
 // Link is a link that was found in a feed or scraped from a page (Item)
 type Link struct {
-	Title string
-	URL   string
+	Title string `json:"title"`
+	URL   string `json:"url"`
+	// Source is the URL of the feed or page (Item) the link was found on.
+	Source string `json:"source,omitempty"`
+	// Added is the time the link was first stored. It is set by the Datastore.
+	Added time.Time `json:"added"`
+	// Status is the processing status of a stored link. It is set by the Datastore.
+	Status LinkStatus `json:"status"`
+}
+
+// LinkStatus is the processing status of a stored link, as reported by clients.
+type LinkStatus string
+
+// Available link states. New links always start as LinkNew.
+const (
+	LinkNew        LinkStatus = "new"
+	LinkSeen       LinkStatus = "seen"
+	LinkDownloaded LinkStatus = "downloaded"
+	LinkRejected   LinkStatus = "rejected"
+)
+
+// ParseLinkStatus returns the LinkStatus with the given name.
+func ParseLinkStatus(s string) (LinkStatus, error) {
+	switch status := LinkStatus(s); status {
+	case LinkNew, LinkSeen, LinkDownloaded, LinkRejected:
+		return status, nil
+	}
+	return "", errors.Errorf("unknown link status %q", s)
+}
+
+// Handled reports whether a link with this status was already handled by a client,
+// i.e. it was either downloaded or rejected.
+func (s LinkStatus) Handled() bool {
+	return s == LinkDownloaded || s == LinkRejected
+}
+
+// Attempt is the number and time of the last (fetch) attempt for the given key.
+type Attempt struct {
+	Key   string    `json:"key"`
+	Last  time.Time `json:"last"`
+	Count int       `json:"count"`
+}
+
+// FetchResult is the outcome of a single fetch attempt, including all followed URLs.
+type FetchResult struct {
+	Start time.Time `json:"start"`
+	// Duration of the whole attempt in nanoseconds.
+	Duration time.Duration `json:"duration"`
+	Bytes    int64         `json:"bytes"`
+	// Status is the last HTTP status code received, or 0 if not available.
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Items  int    `json:"items"`
+	Links  int    `json:"links"`
+}
+
+// Success reports whether the attempt completed without errors.
+func (r FetchResult) Success() bool {
+	return r.Error == ""
 }
@@ -6,7 +6,10 @@ package felix
 
 import (
 	"context"
+	"io"
 	"net"
+	"net/http"
+	"sync"
 	"time"
 )
 
@@ -19,6 +22,24 @@ type Fetcher struct {
 	items   chan<- Item
 	links   chan<- Link
 	log     Logger
+	record  FetchRecorder
+	state   StateRecorder
+	metrics string
+
+	trigger chan struct{}
+
+	mu   sync.RWMutex
+	next time.Time
+}
+
+// FetchRecorder records the results of all fetch attempts for the given key, e.g. in a Datastore.
+type FetchRecorder interface {
+	AddFetchResult(key string, result FetchResult) error
+}
+
+// StateRecorder persists the state of item page fetchers, e.g. in a Datastore.
+type StateRecorder interface {
+	SetItemState(url string, state FetcherState) error
 }
 
 // Attempter is used by Fetcher to determine if and when the next fetch attempt should be made.
@@ -42,6 +63,8 @@ func NewFetcher(url string, source Source, scanner Scanner, attempt Attempter, i
 		items:   items,
 		links:   links,
 		log:     &NopLogger{},
+		metrics: url,
+		trigger: make(chan struct{}, 1),
 	}
 }
 
@@ -52,17 +75,60 @@ func (f *Fetcher) SetLogger(log Logger) {
 	f.log = log
 }
 
+// SetRecorder sets the recorder for the results of all fetch attempts.
+// Fetcher does not record results when no FetchRecorder is set.
+func (f *Fetcher) SetRecorder(r FetchRecorder) {
+	f.record = r
+}
+
+// SetStateRecorder sets the recorder for state changes of the fetcher (see FetcherState).
+// Fetcher does not record state changes when no StateRecorder is set.
+func (f *Fetcher) SetStateRecorder(s StateRecorder) {
+	f.state = s
+}
+
+// SetMetricsName sets the value of the "feed" label of all fetch metrics. It defaults to the fetcher URL.
+// Fetchers with many distinct URLs (e.g. item pages) should share a common name to limit the number of time series.
+func (f *Fetcher) SetMetricsName(name string) {
+	f.metrics = name
+}
+
+// URL returns the URL of the fetcher.
+func (f *Fetcher) URL() string {
+	return f.url
+}
+
+// NextAttempt returns the time of the next scheduled fetch attempt.
+// It returns the zero time if no attempt is scheduled.
+func (f *Fetcher) NextAttempt() time.Time {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.next
+}
+
+func (f *Fetcher) setNextAttempt(next time.Time) {
+	f.mu.Lock()
+	f.next = next
+	f.mu.Unlock()
+}
+
+// Trigger requests an immediate fetch attempt, regardless of the wait time determined by the Attempter.
+// Triggered attempts are not counted by the Attempter, so they neither use up attempts nor move the
+// scheduled attempt. It reports false if a triggered attempt is already pending.
+func (f *Fetcher) Trigger() bool {
+	select {
+	case f.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
 // Start starts the fetching.
 func (f *Fetcher) Start(quit <-chan struct{}) {
 
 	f.log.Info("started fetcher", "url", f.url)
 
-	bg := context.Background()
-	e := &emitter{
-		items: f.items,
-		links: f.links,
-	}
-
 L:
 	for {
 		shouldContinue, nextFetch, err := f.attempt.Next(f.url)
@@ -72,53 +138,143 @@ L:
 		}
 		if !shouldContinue {
 			f.log.Info("will not try to continue. quitting.", "url", f.url)
+			f.setState(FetcherExhausted)
 			return
 		}
 		f.log.Info("waiting", "nextFetch", nextFetch, "url", f.url)
+		f.setNextAttempt(time.Now().Add(nextFetch))
 
+		triggered := false
 		select {
 		// TODO: abstract time stdlib away into clock, etc. for testing
 		case <-time.After(nextFetch):
+		case <-f.trigger:
+			f.log.Info("fetch triggered", "url", f.url)
+			triggered = true
+		case <-quit:
+			break L
+		}
+
+		f.setNextAttempt(time.Time{})
+		if !triggered {
 			if err := f.attempt.Inc(f.url); err != nil {
 				f.log.Error("could not get increment attempt count", "err", err)
 				return
 			}
-			ctx, cancel := context.WithTimeout(bg, 15*time.Second)
-			e.EmitFollow(f.url)
-
-			for e.HasFollow() {
-				followURL := e.NextFollow()
-
-				r, err := f.source.Get(ctx, followURL)
-
-				if nerr, ok := err.(net.Error); ok && nerr.Temporary() {
-					// TODO: handle temporary errors (backoff, retry)
-					f.log.Error("temporary net error", "err", err, "url", f.url, "follow", followURL)
-					cancel()
-					continue
-				}
-
-				if err != nil {
-					f.log.Error("could not get resource", "err", err, "url", f.url, "follow", followURL)
-					cancel()
-					continue
-				}
-
-				err = f.scanner.Scan(ctx, r, e)
-
-				if err != nil {
-					f.log.Error("could not scan content", "err", err, "url", f.url, "follow", followURL)
-					cancel()
-					continue
-				}
+		}
+		if _, permanent := f.fetch(); permanent {
+			f.log.Info("permanent fetch error. quitting.", "url", f.url)
+			f.setState(FetcherFailed)
+			return
+		}
+	}
+}
+
+// FetchOnce performs a single fetch attempt right away, regardless of the Attempter, which is neither
+// consulted nor updated. The result is recorded and returned; the found items and links are emitted as usual.
+func (f *Fetcher) FetchOnce() FetchResult {
+	result, permanent := f.fetch()
+	if permanent {
+		f.setState(FetcherFailed)
+	}
+	return result
+}
+
+func (f *Fetcher) setState(state FetcherState) {
+	if f.state == nil {
+		return
+	}
+	if err := f.state.SetItemState(f.url, state); err != nil {
+		f.log.Error("could not record fetcher state", "err", err, "url", f.url, "state", state)
+	}
+}
+
+// fetch performs a single fetch attempt of the fetcher URL, including all emitted follow URLs,
+// and records the result. It also reports whether the fetcher URL itself failed permanently.
+func (f *Fetcher) fetch() (result FetchResult, permanent bool) {
+	result.Start = time.Now()
+	e := &emitter{
+		items:  f.items,
+		links:  f.links,
+		source: f.url,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	e.EmitFollow(f.url)
+
+	for e.HasFollow() {
+		followURL := e.NextFollow()
+
+		r, err := f.source.Get(ctx, followURL)
+
+		if code := statusCode(err); code != 0 {
+			result.Status = code
+		}
+
+		if nerr, ok := err.(net.Error); ok && nerr.Temporary() {
+			// TODO: handle temporary errors (backoff, retry)
+			f.log.Error("temporary net error", "err", err, "url", f.url, "follow", followURL)
+			result.Error = err.Error()
+			continue
+		}
+
+		if err != nil {
+			f.log.Error("could not get resource", "err", err, "url", f.url, "follow", followURL)
+			result.Error = err.Error()
+			if followURL == f.url && isPermanentError(err) {
+				permanent = true
 			}
+			continue
+		}
 
-			cancel()
+		if code := statusCode(r); code != 0 {
+			result.Status = code
+		}
 
-		case <-quit:
-			break L
+		cr := &countingReader{r: r}
+		err = f.scanner.Scan(ctx, cr, e)
+		result.Bytes += cr.n
+
+		if err != nil {
+			f.log.Error("could not scan content", "err", err, "url", f.url, "follow", followURL)
+			result.Error = err.Error()
+			continue
 		}
 	}
+
+	result.Duration = time.Since(result.Start)
+	result.Items = e.itemCount
+	result.Links = e.linkCount
+
+	observeFetch(f.metrics, result)
+
+	if f.record != nil {
+		if err := f.record.AddFetchResult(f.url, result); err != nil {
+			f.log.Error("could not record fetch result", "err", err, "url", f.url)
+		}
+	}
+
+	return result, permanent
+}
+
+// isPermanentError reports whether err indicates that the requested resource is gone for good.
+func isPermanentError(err error) bool {
+	code := statusCode(err)
+	return code == http.StatusNotFound || code == http.StatusGone
+}
+
+// countingReader counts the number of bytes read from the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
 
 // attempt is the default Datastore-backed Attempter
@@ -9,6 +9,7 @@ import (
 	"context"
 	"errors"
 	"io"
+	"net/http"
 	"strings"
 	"sync"
 	"testing"
@@ -74,6 +75,193 @@ func TestFetcher(t *testing.T) {
 	}
 }
 
+func TestFetcher_fetch(t *testing.T) {
+	links := make(chan Link, 10)
+	recorder := &mockRecorder{}
+
+	fetcher := NewFetcher("baseURL", &mockScanSource{}, ScanFunc(func(ctx context.Context, r io.Reader, e Emitter) error {
+		e.EmitLink(Link{URL: "link1"})
+		e.EmitLink(Link{URL: "link2"})
+		return nil
+	}), nil, nil, links)
+	fetcher.SetRecorder(recorder)
+
+	result, _ := fetcher.fetch()
+
+	if !result.Success() || result.Links != 2 || result.Items != 0 {
+		t.Errorf("unexpected fetch result: %+v", result)
+	}
+
+	if len(recorder.results["baseURL"]) != 1 {
+		t.Fatalf("fetch result was not recorded")
+	}
+
+	// second call to mockScanSource.Get fails
+	result, _ = fetcher.fetch()
+
+	if result.Success() || result.Error != "tempSourceError" {
+		t.Errorf("unexpected fetch result: %+v", result)
+	}
+}
+
+func TestFetcher_Trigger(t *testing.T) {
+	links := make(chan Link, 1)
+	source := sourceFunc(func(ctx context.Context, url string) (io.Reader, error) {
+		return strings.NewReader(""), nil
+	})
+	scanner := ScanFunc(func(ctx context.Context, r io.Reader, e Emitter) error {
+		e.EmitLink(Link{URL: "link"})
+		return nil
+	})
+
+	attempt := &countAttempter{wait: 1 * time.Hour}
+	fetcher := NewFetcher("baseURL", source, scanner, attempt, nil, links)
+
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		fetcher.Start(quit)
+		close(done)
+	}()
+
+	if !fetcher.Trigger() {
+		t.Error("first trigger should not be pending")
+	}
+
+	select {
+	case <-links:
+	case <-time.After(1 * time.Second):
+		t.Error("triggered fetch did not happen")
+	}
+
+	close(quit)
+	<-done
+
+	if n := attempt.count(); n != 0 {
+		t.Errorf("triggered fetch should not count as attempt. expected %v attempts, got %v", 0, n)
+	}
+}
+
+// countAttempter always waits for the same duration and counts the attempts.
+type countAttempter struct {
+	wait time.Duration
+
+	mu   sync.Mutex
+	incs int
+}
+
+func (a *countAttempter) Next(key string) (bool, time.Duration, error) {
+	return true, a.wait, nil
+}
+
+func (a *countAttempter) Inc(key string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.incs++
+	return nil
+}
+
+func (a *countAttempter) count() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.incs
+}
+
+func TestFetcher_FetchOnce(t *testing.T) {
+	source := sourceFunc(func(ctx context.Context, url string) (io.Reader, error) {
+		return nil, &StatusError{Code: http.StatusNotFound}
+	})
+	recorder := &mockRecorder{}
+
+	// The Attempter must not be used
+	fetcher := NewFetcher("baseURL", source, nil, nil, nil, nil)
+	fetcher.SetRecorder(recorder)
+	fetcher.SetStateRecorder(recorder)
+
+	result := fetcher.FetchOnce()
+
+	if result.Success() || result.Status != http.StatusNotFound {
+		t.Errorf("unexpected fetch result: %+v", result)
+	}
+	if len(recorder.results["baseURL"]) != 1 {
+		t.Errorf("fetch result was not recorded")
+	}
+	if recorder.state["baseURL"] != FetcherFailed {
+		t.Errorf("unexpected fetcher state. expected %v, got %v", FetcherFailed, recorder.state["baseURL"])
+	}
+}
+
+func TestFetcher_State(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		attempts int
+		err      error
+		expected FetcherState
+	}{
+		{
+			desc:     "exhausted attempts",
+			attempts: 0,
+			expected: FetcherExhausted,
+		},
+		{
+			desc:     "page gone",
+			attempts: 1,
+			err:      &StatusError{Code: http.StatusGone},
+			expected: FetcherFailed,
+		},
+		{
+			desc:     "other error",
+			attempts: 1,
+			err:      &StatusError{Code: http.StatusInternalServerError},
+			expected: FetcherExhausted,
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			source := sourceFunc(func(ctx context.Context, url string) (io.Reader, error) {
+				return nil, tC.err
+			})
+			recorder := &mockRecorder{}
+
+			fetcher := NewFetcher("baseURL", source, nil, &mockAttempter{tC.attempts}, nil, nil)
+			fetcher.SetStateRecorder(recorder)
+			fetcher.Start(make(chan struct{}))
+
+			if recorder.state["baseURL"] != tC.expected {
+				t.Errorf("unexpected fetcher state. expected %v, got %v", tC.expected, recorder.state["baseURL"])
+			}
+		})
+	}
+}
+
+type sourceFunc func(ctx context.Context, url string) (io.Reader, error)
+
+func (f sourceFunc) Get(ctx context.Context, url string) (io.Reader, error) {
+	return f(ctx, url)
+}
+
+type mockRecorder struct {
+	results map[string][]FetchResult
+	state   map[string]FetcherState
+}
+
+func (r *mockRecorder) SetItemState(url string, state FetcherState) error {
+	if r.state == nil {
+		r.state = make(map[string]FetcherState)
+	}
+	r.state[url] = state
+	return nil
+}
+
+func (r *mockRecorder) AddFetchResult(key string, result FetchResult) error {
+	if r.results == nil {
+		r.results = make(map[string][]FetchResult)
+	}
+	r.results[key] = append(r.results[key], result)
+	return nil
+}
+
 type mockScanSource struct {
 	sourceCalls int // number of times Source.Get was called
 	scanCalls   int // number of times Scanner.Scan was called
@@ -66,28 +66,60 @@ func (f ItemFilterFunc) Filter(item Item, next func(Item)) {
 	f(item, next)
 }
 
-// internal helper type to provide an additional Stringer implementation
+// ItemExplainer is an optional interface for item filters to explain why an item was not passed
+// to the next filter (see TraceItems). ExplainItem must not change the state of the filter.
+type ItemExplainer interface {
+	ExplainItem(item Item) string
+}
+
+// internal helper type to provide an additional Stringer and ItemExplainer implementation
 type itemFilter struct {
 	ItemFilter
-	s string
+	s       string
+	explain func(Item) string
 }
 
 func (f itemFilter) String() string {
 	return f.s
 }
 
+func (f itemFilter) ExplainItem(item Item) string {
+	if f.explain == nil {
+		return ""
+	}
+	return f.explain(item)
+}
+
 // FilterItems should just filter until in-Channel is closed? Or is quit channel needed?
 func FilterItems(in <-chan Item, out chan<- Item, filters ...ItemFilter) {
+	FilterItemsTraced(in, out, nil, filters...)
+}
+
+// FilterItemsTraced works like FilterItems and additionally passes the traces (see TraceItems)
+// of the items selected by the tracer to it. Tracing is disabled if tracer is nil.
+func FilterItemsTraced(in <-chan Item, out chan<- Item, tracer FilterTracer, filters ...ItemFilter) {
 
 	// final filter chain output
 	var final = func(item Item) {
 		out <- item
 	}
 
-	chain := buildItemFilterChain(filters...)
+	rec := &itemRecorder{}
+	chain := buildItemFilterChain(rec.wrap(instrumentItemFilters(filters))...)
 
 	for item := range in {
-		chain.Filter(item, final)
+		if tracer == nil || !tracer.Sample() {
+			chain.Filter(item, final)
+			continue
+		}
+
+		var output []Item
+		rec.start(len(filters))
+		chain.Filter(item, func(item Item) {
+			output = append(output, item)
+			final(item)
+		})
+		tracer.TraceItem(rec.stop(item, output, filters))
 	}
 
 	close(out)
@@ -136,7 +168,11 @@ func ItemTitleFilter(titles ...string) ItemFilter {
 		}
 	})
 
-	return itemFilter{filter, b.String()}
+	explain := func(item Item) string {
+		return fmt.Sprintf("title %q does not contain all words of any of the %d titles", item.Title, len(titles))
+	}
+
+	return itemFilter{filter, b.String(), explain}
 }
 
 // sanitizeTitle strips all non-alphanumeric characters from a string
@@ -180,23 +216,72 @@ func (f LinkFilterFunc) Filter(link Link, next func(Link)) {
 	f(link, next)
 }
 
+// LinkExplainer is an optional interface for link filters to explain why a link was not passed
+// to the next filter (see TraceLinks). ExplainLink must not change the state of the filter.
+type LinkExplainer interface {
+	ExplainLink(link Link) string
+}
+
+// internal helper type to provide an additional LinkExplainer implementation
+type linkFilter struct {
+	LinkFilter
+	explain func(Link) string
+}
+
+func (f linkFilter) ExplainLink(link Link) string {
+	return f.explain(link)
+}
+
 // FilterLinks should just filter until in-Channel is closed? Or is quit channel needed?
 func FilterLinks(in <-chan Link, out chan<- Link, filters ...LinkFilter) {
+	FilterLinksTraced(in, out, nil, filters...)
+}
+
+// FilterLinksTraced works like FilterLinks and additionally passes the traces (see TraceLinks)
+// of the links selected by the tracer to it. Tracing is disabled if tracer is nil.
+func FilterLinksTraced(in <-chan Link, out chan<- Link, tracer FilterTracer, filters ...LinkFilter) {
 
 	// final filter chain output
 	var final = func(link Link) {
 		out <- link
 	}
 
-	chain := buildLinkFilterChain(filters...)
+	rec := &linkRecorder{}
+	chain := buildLinkFilterChain(rec.wrap(instrumentLinkFilters(filters))...)
 
 	for link := range in {
-		chain.Filter(link, final)
+		if tracer == nil || !tracer.Sample() {
+			chain.Filter(link, final)
+			continue
+		}
+
+		var output []Link
+		rec.start(len(filters))
+		chain.Filter(link, func(link Link) {
+			output = append(output, link)
+			final(link)
+		})
+		tracer.TraceLink(rec.stop(link, output, filters))
 	}
 
 	close(out)
 }
 
+// FilterLinkSlice passes all links through the filter chain and returns the links that made it through.
+func FilterLinkSlice(links []Link, filters ...LinkFilter) []Link {
+	var filtered []Link
+
+	chain := buildLinkFilterChain(filters...)
+
+	for _, link := range links {
+		chain.Filter(link, func(link Link) {
+			filtered = append(filtered, link)
+		})
+	}
+
+	return filtered
+}
+
 func buildLinkFilterChain(filters ...LinkFilter) LinkFilter {
 	if len(filters) > 1 {
 		return LinkFilterFunc(func(link Link, next func(Link)) {
@@ -224,7 +309,7 @@ func LinkDuplicatesFilter(size int) LinkFilter {
 	foundURLs := make(map[string]bool)
 	var seqURLs []string
 
-	return LinkFilterFunc(func(link Link, next func(Link)) {
+	filter := LinkFilterFunc(func(link Link, next func(Link)) {
 		if foundURLs[link.URL] {
 			return
 		}
@@ -240,6 +325,10 @@ func LinkDuplicatesFilter(size int) LinkFilter {
 
 		next(link)
 	})
+
+	return linkFilter{filter, func(link Link) string {
+		return fmt.Sprintf("duplicate of one of the last %d links", size)
+	}}
 }
 
 // LinkDomainFilter filters links based on the given domains.
@@ -250,7 +339,7 @@ func LinkDomainFilter(domains ...string) LinkFilter {
 		validDomains = append(validDomains, strings.ToLower(strings.TrimSpace(domain)))
 	}
 
-	return LinkFilterFunc(func(link Link, next func(Link)) {
+	filter := LinkFilterFunc(func(link Link, next func(Link)) {
 		u, err := url.Parse(link.URL)
 
 		if err != nil {
@@ -266,6 +355,14 @@ func LinkDomainFilter(domains ...string) LinkFilter {
 			}
 		}
 	})
+
+	return linkFilter{filter, func(link Link) string {
+		u, err := url.Parse(link.URL)
+		if err != nil {
+			return fmt.Sprintf("invalid URL %q", link.URL)
+		}
+		return fmt.Sprintf("domain %q is not one of %s", u.Hostname(), strings.Join(validDomains, ", "))
+	}}
 }
 
 // LinkURLRegexFilter filters links based their URLs matching the given regular expressions.
@@ -280,14 +377,18 @@ func LinkURLRegexFilter(exprs ...string) (LinkFilter, error) {
 		regexes = append(regexes, regex)
 	}
 
-	return LinkFilterFunc(func(link Link, next func(Link)) {
+	filter := LinkFilterFunc(func(link Link, next func(Link)) {
 		for _, expr := range regexes {
 			if expr.MatchString(strings.TrimSpace(link.URL)) {
 				next(link)
 				break
 			}
 		}
-	}), nil
+	})
+
+	return linkFilter{filter, func(link Link) string {
+		return fmt.Sprintf("URL matches none of the %d expressions", len(regexes))
+	}}, nil
 }
 
 // LinkFilenameAsTitleFilter extracts the filename from the URL and sets it as the new link title.
@@ -327,7 +428,7 @@ func LinkFilenameAsTitleFilter(trimExt bool) LinkFilter {
 // This is sometime needed for easier filtering down the filter chain.
 func LinkUploadedExpandFilenameFilter(source Source) LinkFilter {
 
-	return LinkFilterFunc(func(link Link, next func(Link)) {
+	filter := LinkFilterFunc(func(link Link, next func(Link)) {
 		u, err := url.Parse(strings.TrimSpace(link.URL))
 
 		// TODO: accept or reject non-parsable URLs?
@@ -378,6 +479,13 @@ func LinkUploadedExpandFilenameFilter(source Source) LinkFilter {
 		link.URL = fmt.Sprintf("%s://%s/file/%s/%s", u.Scheme, u.Hostname(), id, filename)
 		next(link)
 	})
+
+	return linkFilter{filter, func(link Link) string {
+		if _, err := url.Parse(strings.TrimSpace(link.URL)); err != nil {
+			return fmt.Sprintf("invalid URL %q", link.URL)
+		}
+		return "could not get the file name of the uploaded file"
+	}}
 }
 
 func parseULFilename(r io.Reader) string {
@@ -160,6 +160,24 @@ func TestFilterLinks(t *testing.T) {
 	}
 }
 
+func TestFilterLinkSlice(t *testing.T) {
+	links := []Link{
+		{Title: "a", URL: "http://example.com/a"},
+		{Title: "b", URL: "http://example.org/b"},
+		{Title: "c", URL: "http://example.com/c"},
+	}
+
+	filtered := FilterLinkSlice(links, LinkDomainFilter("example.com"))
+
+	if len(filtered) != 2 || filtered[0].Title != "a" || filtered[1].Title != "c" {
+		t.Errorf("unexpected filtered links: %v", filtered)
+	}
+
+	if all := FilterLinkSlice(links); len(all) != len(links) {
+		t.Errorf("empty filter chain should pass all links. expected %d, got %d", len(links), len(all))
+	}
+}
+
 func Test_buildLinkFilterChain(t *testing.T) {
 	filterAppendA := LinkFilterFunc(func(link Link, next func(Link)) {
 		link.Title += "A"
@@ -495,20 +513,20 @@ func TestLinkDuplicatesFilter(t *testing.T) {
 		{
 			desc:     "unique urls",
 			filter:   LinkDuplicatesFilter(100),
-			input:    []Link{{"", "A"}, {"", "B"}, {"", "C"}},
-			expected: []Link{{"", "A"}, {"", "B"}, {"", "C"}},
+			input:    []Link{{URL: "A"}, {URL: "B"}, {URL: "C"}},
+			expected: []Link{{URL: "A"}, {URL: "B"}, {URL: "C"}},
 		},
 		{
 			desc:     "some duplicate urls with different titles",
 			filter:   LinkDuplicatesFilter(100),
-			input:    []Link{{"", "A"}, {"", "B"}, {"a", "A"}, {"b", "A"}, {"c", "A"}},
-			expected: []Link{{"", "A"}, {"", "B"}},
+			input:    []Link{{URL: "A"}, {URL: "B"}, {Title: "a", URL: "A"}, {Title: "b", URL: "A"}, {Title: "c", URL: "A"}},
+			expected: []Link{{URL: "A"}, {URL: "B"}},
 		},
 		{
 			desc:     "sliding search window overflow",
 			filter:   LinkDuplicatesFilter(1),
-			input:    []Link{{"", "A"}, {"", "B"}, {"a", "A"}, {"b", "A"}, {"c", "A"}},
-			expected: []Link{{"", "A"}, {"", "B"}, {"a", "A"}},
+			input:    []Link{{URL: "A"}, {URL: "B"}, {Title: "a", URL: "A"}, {Title: "b", URL: "A"}, {Title: "c", URL: "A"}},
+			expected: []Link{{URL: "A"}, {URL: "B"}, {Title: "a", URL: "A"}},
 		},
 	}
 
@@ -5,17 +5,17 @@
 package felix
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"net/http"
-	"text/template"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
-type feed struct {
-	PubDate time.Time
-	Items   []Item
-}
-
 // StringHandler simply serves the given static string.
 func StringHandler(s string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -24,59 +24,275 @@ func StringHandler(s string) http.Handler {
 	})
 }
 
-// FeedHandler serves the found links (up to maxAge) as an RSS feed.
-func FeedHandler(ds Datastore, maxAge time.Duration) http.Handler {
+// FeedHandler serves the found links (up to the configured maximum age) as an RSS, Atom or JSON feed.
+// The format is negotiated by the "format" parameter, path suffix or Accept header and defaults to RSS.
+// The stored links are passed through the given filters before they are added to the feed.
+//
+// Conditional requests (If-None-Match, If-Modified-Since) are answered with 304 Not Modified
+// if the feed did not change since, without reading the links from the datastore. The feed changes
+// when the stored links change and when one of its links leaves the maximum age window (see feedState).
+func FeedHandler(ds Datastore, output OutputConfig, filters ...LinkFilter) http.Handler {
+	state := &feedState{maxAge: output.MaxAge}
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format, ok := negotiateFeedFormat(r)
+		if !ok {
+			http.Error(w, "unknown feed format", http.StatusBadRequest)
+			return
+		}
 
-		links, err := ds.GetLinks(maxAge)
+		lastChange, err := ds.LastChange()
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		var items []Item
-		for _, link := range links {
-			items = append(items, Item{
-				Title:   link.Title,
-				URL:     link.URL,
-				PubDate: time.Now(),
-			})
+		if output.CacheMaxAge > 0 {
+			w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(output.CacheMaxAge.Seconds())))
+		}
+		w.Header().Add("Vary", "Accept")
+
+		now := time.Now()
+		if lastModified, ok := state.lastModified(lastChange, now); ok {
+			setFeedValidators(w, r, format, output, lastModified)
+			if notModified(r, w.Header().Get("ETag"), lastModified) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		links, err := ds.GetLinks(output.MaxAge)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		f := &feed{
+			Title:       output.Title,
+			Description: output.Description,
+			Link:        output.Link,
+			URL:         requestURL(r),
+			TTL:         output.TTL,
+			PubDate:     time.Now(),
+		}
+
+		if f.Link == "" {
+			f.Link = f.URL
+		}
+
+		for _, link := range FilterLinkSlice(links, filters...) {
+			if output.HideHandled && link.Status.Handled() {
+				continue
+			}
+			f.Links = append(f.Links, link)
+		}
+
+		// Newest links first
+		sort.SliceStable(f.Links, func(i, j int) bool { return f.Links[i].Added.After(f.Links[j].Added) })
+
+		if output.Limit > 0 && len(f.Links) > output.Limit {
+			f.Links = f.Links[:output.Limit]
+		}
+
+		lastModified := state.update(lastChange, f.Links, now)
+		setFeedValidators(w, r, format, output, lastModified)
+		if notModified(r, w.Header().Get("ETag"), lastModified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		var buf bytes.Buffer
+		if err := format.encode(&buf, f); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", format.contentType)
+		w.Write(buf.Bytes())
+	})
+}
+
+// feedState tracks when the links of a feed leave its maximum age window (expire), which changes
+// the feed without a change of the stored links. Without a maximum age, the feed only changes with
+// the stored links.
+type feedState struct {
+	maxAge time.Duration
+
+	mu       sync.Mutex
+	known    bool
+	checked  time.Time
+	changed  time.Time
+	modified time.Time
+	// expiries of the links in the feed, oldest first
+	expiries []time.Time
+}
+
+// lastModified returns the time of the last modification of the feed, if it is known without reading the links:
+// the stored links did not change and no link of the feed expired since the last update.
+func (s *feedState) lastModified(lastChange, now time.Time) (time.Time, bool) {
+	if s.maxAge <= 0 {
+		return lastChange, true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.known || !s.changed.Equal(lastChange) || (len(s.expiries) > 0 && !now.Before(s.expiries[0])) {
+		return time.Time{}, false
+	}
+	return s.modified, true
+}
+
+// update records the links of the feed at now and returns the time of its last modification.
+// That is the last change of the stored links or the last expiry of a link of the feed, if it was later.
+// If links could have expired unnoticed (e.g. on the first update), now is used instead.
+func (s *feedState) update(lastChange time.Time, links []Link, now time.Time) time.Time {
+	if s.maxAge <= 0 {
+		return lastChange
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	modified := lastChange
+	if !s.known || now.Sub(s.checked) >= s.maxAge {
+		// Links added after the last update could have been added and expired since
+		modified = now
+	} else {
+		if s.changed.Equal(lastChange) && s.modified.After(modified) {
+			modified = s.modified
+		}
+		for _, e := range s.expiries {
+			if !e.After(now) && e.After(modified) {
+				modified = e
+			}
+		}
+	}
+
+	expiries := make([]time.Time, len(links))
+	for i, link := range links {
+		expiries[i] = link.Added.Add(s.maxAge)
+	}
+	sort.Slice(expiries, func(i, j int) bool { return expiries[i].Before(expiries[j]) })
+
+	s.known, s.checked, s.changed, s.modified, s.expiries = true, now, lastChange, modified, expiries
+	return modified
+}
+
+// setFeedValidators sets the ETag and Last-Modified headers of the feed.
+func setFeedValidators(w http.ResponseWriter, r *http.Request, format feedFormat, output OutputConfig, lastModified time.Time) {
+	w.Header().Set("ETag", feedETag(r, format, output, lastModified))
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// feedETag returns a weak entity tag of the feed. It identifies the links of the feed by the time of
+// their last modification (see feedState), so it can be computed without reading the links from the datastore.
+func feedETag(r *http.Request, format feedFormat, output OutputConfig, lastModified time.Time) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|%s|%+v|%d", format.name, requestURL(r), output, lastModified.UnixNano())
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// notModified reports whether the conditional request can be answered with 304 Not Modified.
+// If-None-Match takes precedence over If-Modified-Since, see RFC 7232, section 6.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			// Weak comparison
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
 		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		// Last-Modified has a resolution of seconds
+		return err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+
+	return false
+}
 
-		feed := &feed{
-			PubDate: time.Now(),
-			Items:   items,
+// requestURL returns the absolute URL of the request.
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.Path
+}
+
+// LinkStatusHandler sets the status of the link given by the "url" parameter.
+// Only POST requests are accepted.
+func LinkStatusHandler(ds Datastore, status LinkStatus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
 		}
 
-		w.Header().Set("Content-Type", "application/rss+xml")
-		if err := feedTemplate.Execute(w, feed); err != nil {
+		url := r.FormValue("url")
+		if url == "" {
+			http.Error(w, "missing url parameter", http.StatusBadRequest)
+			return
+		}
+
+		err := ds.SetLinkStatus(url, status)
+		if err == ErrNotFound {
+			http.Error(w, "link not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+
+		w.WriteHeader(http.StatusNoContent)
 	})
 }
 
-var feedTemplate = template.Must(template.New("feed").Funcs(funcMap).Parse(templateString))
+// fetchStatus is the fetch status of a single URL, as served by APIFeedsHandler and shown on the dashboard.
+type fetchStatus struct {
+	URL         string
+	LastSuccess *time.Time
+	LastResult  *FetchResult
+}
+
+func newFetchStatus(url string, history []FetchResult) fetchStatus {
+	status := fetchStatus{URL: url}
+
+	if len(history) > 0 {
+		status.LastResult = &history[0]
+	}
 
-var funcMap = template.FuncMap{
-	"formatTime": func(t time.Time) string { return t.Format("Mon, 02 Jan 2006 15:04:05 -0700") },
+	for i := range history {
+		if history[i].Success() {
+			status.LastSuccess = &history[i].Start
+			break
+		}
+	}
+
+	return status
 }
 
-var templateString = `<?xml version="1.0" encoding="UTF-8"?><rss version="2.0">
-<channel>
-	<title>felix</title>
-	<description>felix feed</description>
-	<link>http://example.com</link>
-	<pubDate>{{.PubDate | formatTime}}</pubDate>
-	{{range .Items}}
-	<item>
-		<title>{{.Title}}</title>
-		<guid>{{.URL}}</guid>
-		<link>{{.URL}}</link>
-		<description>{{.Title}}</description>
-		<pubDate>{{.PubDate | formatTime}}</pubDate>
-	</item>
-	{{end}}
-</channel>
-</rss>
-`
+// writeJSON serves v encoded as JSON.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -8,6 +8,9 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
 
 	"time"
@@ -91,7 +94,7 @@ func TestFeedHandler(t *testing.T) {
 			w := httptest.NewRecorder()
 
 			ds := &mockDatastore{links: tC.links, err: tC.err}
-			FeedHandler(ds, 0).ServeHTTP(w, req)
+			FeedHandler(ds, OutputConfig{}).ServeHTTP(w, req)
 
 			resp := w.Result()
 
@@ -118,13 +121,369 @@ func TestFeedHandler(t *testing.T) {
 	}
 }
 
+func TestFeedHandler_Format(t *testing.T) {
+	testCases := []struct {
+		target      string
+		status      int
+		contentType string
+	}{
+		{"/", http.StatusOK, "application/rss+xml"},
+		{"/feed.atom", http.StatusOK, "application/atom+xml"},
+		{"/?format=json", http.StatusOK, "application/feed+json"},
+		{"/?format=foo", http.StatusBadRequest, ""},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.target, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tC.target, nil)
+			w := httptest.NewRecorder()
+
+			FeedHandler(&mockDatastore{links: feedTestLinks}, OutputConfig{}).ServeHTTP(w, req)
+
+			if w.Code != tC.status {
+				t.Errorf("unexpected status code. expected %v, got %v", tC.status, w.Code)
+			}
+
+			if ct := w.Header().Get("Content-Type"); tC.contentType != "" && ct != tC.contentType {
+				t.Errorf("unexpected content type. expected %q, got %q", tC.contentType, ct)
+			}
+		})
+	}
+}
+
+func TestFeedHandler_Channel(t *testing.T) {
+	output := OutputConfig{
+		Title:       "Tom & Jerry",
+		Description: "<all> the links",
+		Link:        "http://example.com/?a=1&b=2",
+		TTL:         90 * time.Minute,
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+
+	FeedHandler(&mockDatastore{links: feedTestLinks}, output).ServeHTTP(w, req)
+	body := w.Body.String()
+
+	feed, err := gofeed.NewParser().ParseString(body)
+	if err != nil {
+		t.Fatal("could not parse output feed:", err)
+	}
+
+	if feed.Title != output.Title || feed.Description != output.Description || feed.Link != output.Link {
+		t.Errorf("unexpected channel. expected %q, %q, %q, got %q, %q, %q",
+			output.Title, output.Description, output.Link, feed.Title, feed.Description, feed.Link)
+	}
+
+	if !strings.Contains(body, "<ttl>90</ttl>") {
+		t.Error("channel ttl missing")
+	}
+}
+
+func TestFeedHandler_HideHandled(t *testing.T) {
+	links := []Link{
+		{Title: "new", URL: "http://example.com/1", Status: LinkNew},
+		{Title: "seen", URL: "http://example.com/2", Status: LinkSeen},
+		{Title: "downloaded", URL: "http://example.com/3", Status: LinkDownloaded},
+		{Title: "rejected", URL: "http://example.com/4", Status: LinkRejected},
+	}
+
+	for _, hide := range []bool{false, true} {
+		req := httptest.NewRequest("GET", "/", nil)
+		w := httptest.NewRecorder()
+
+		FeedHandler(&mockDatastore{links: links}, OutputConfig{HideHandled: hide}).ServeHTTP(w, req)
+
+		feed, err := gofeed.NewParser().Parse(w.Result().Body)
+		if err != nil {
+			t.Fatal("could not parse output feed:", err)
+		}
+
+		expected := len(links)
+		if hide {
+			expected = 2
+		}
+
+		if len(feed.Items) != expected {
+			t.Errorf("hideHandled=%v: unexpected number of feed items. expected %d, got %d", hide, expected, len(feed.Items))
+		}
+	}
+}
+
+func TestFeedHandler_Output(t *testing.T) {
+	now := time.Now()
+	links := []Link{
+		{Title: "show.720p.mkv", URL: "http://example.com/1", Added: now.Add(-3 * time.Hour)},
+		{Title: "show.1080p.mkv", URL: "http://example.com/2", Added: now.Add(-2 * time.Hour)},
+		{Title: "other.1080p.mkv", URL: "http://example.org/3", Added: now.Add(-1 * time.Hour)},
+		{Title: "show.1080p.mp4", URL: "http://example.com/4", Added: now},
+	}
+
+	domainFilter := LinkDomainFilter("example.com")
+
+	testCases := []struct {
+		desc     string
+		output   OutputConfig
+		filters  []LinkFilter
+		expected []string
+	}{
+		{"all links", OutputConfig{}, nil, []string{"show.1080p.mp4", "other.1080p.mkv", "show.1080p.mkv", "show.720p.mkv"}},
+		{"filtered", OutputConfig{}, []LinkFilter{domainFilter}, []string{"show.1080p.mp4", "show.1080p.mkv", "show.720p.mkv"}},
+		{"limit", OutputConfig{Limit: 2}, nil, []string{"show.1080p.mp4", "other.1080p.mkv"}},
+		{"filtered and limit", OutputConfig{Limit: 2}, []LinkFilter{domainFilter}, []string{"show.1080p.mp4", "show.1080p.mkv"}},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			w := httptest.NewRecorder()
+
+			FeedHandler(&mockDatastore{links: links}, tC.output, tC.filters...).ServeHTTP(w, req)
+
+			feed, err := gofeed.NewParser().Parse(w.Result().Body)
+			if err != nil {
+				t.Fatal("could not parse output feed:", err)
+			}
+
+			var titles []string
+			for _, item := range feed.Items {
+				titles = append(titles, item.Title)
+			}
+
+			if !reflect.DeepEqual(titles, tC.expected) {
+				t.Errorf("unexpected feed items. expected %v, got %v", tC.expected, titles)
+			}
+		})
+	}
+}
+
+func TestFeedHandler_Caching(t *testing.T) {
+	changed := time.Date(2017, 7, 1, 12, 30, 15, 500, time.UTC)
+	output := OutputConfig{CacheMaxAge: 65 * time.Minute}
+
+	// Initial request to get the validators
+	ds := &mockDatastore{links: feedTestLinks, changed: changed}
+	w := httptest.NewRecorder()
+	FeedHandler(ds, output).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	etag := w.Header().Get("ETag")
+	lastModified := w.Header().Get("Last-Modified")
+
+	if etag == "" || lastModified != "Sat, 01 Jul 2017 12:30:15 GMT" {
+		t.Fatalf("missing or invalid validators. ETag: %q, Last-Modified: %q", etag, lastModified)
+	}
+
+	if cc := w.Header().Get("Cache-Control"); cc != "max-age=3900" {
+		t.Errorf("unexpected Cache-Control header. expected %q, got %q", "max-age=3900", cc)
+	}
+
+	testCases := []struct {
+		desc    string
+		target  string
+		header  map[string]string
+		changed time.Time
+		status  int
+	}{
+		{"matching etag", "/", map[string]string{"If-None-Match": etag}, changed, http.StatusNotModified},
+		{"matching etag in list", "/", map[string]string{"If-None-Match": `"foo", ` + etag}, changed, http.StatusNotModified},
+		{"wildcard etag", "/", map[string]string{"If-None-Match": "*"}, changed, http.StatusNotModified},
+		{"etag after change", "/", map[string]string{"If-None-Match": etag}, changed.Add(1 * time.Second), http.StatusOK},
+		{"etag of other format", "/?format=atom", map[string]string{"If-None-Match": etag}, changed, http.StatusOK},
+		{"not modified since", "/", map[string]string{"If-Modified-Since": lastModified}, changed, http.StatusNotModified},
+		{"modified since", "/", map[string]string{"If-Modified-Since": lastModified}, changed.Add(1 * time.Second), http.StatusOK},
+		{"etag takes precedence", "/", map[string]string{"If-None-Match": `"foo"`, "If-Modified-Since": lastModified}, changed, http.StatusOK},
+		{"unknown last change", "/", map[string]string{"If-Modified-Since": lastModified}, time.Time{}, http.StatusOK},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			ds := &mockDatastore{links: feedTestLinks, changed: tC.changed}
+			req := httptest.NewRequest("GET", tC.target, nil)
+			for k, v := range tC.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+
+			FeedHandler(ds, output).ServeHTTP(w, req)
+
+			if w.Code != tC.status {
+				t.Errorf("unexpected status code. expected %v, got %v", tC.status, w.Code)
+			}
+
+			if tC.status == http.StatusNotModified && (ds.getLinks != 0 || w.Body.Len() != 0) {
+				t.Error("not modified response should not read links or contain a body")
+			}
+
+			if w.Header().Get("ETag") == "" {
+				t.Error("missing ETag header")
+			}
+		})
+	}
+}
+
+func TestFeedHandler_CachingExpiry(t *testing.T) {
+	changed := time.Now().Add(-2 * time.Hour)
+	output := OutputConfig{MaxAge: 1 * time.Hour}
+	ds := &mockDatastore{
+		links:   []Link{{Title: "A", URL: "http://example.com/a", Added: time.Now().Add(-1*time.Hour + 100*time.Millisecond)}},
+		changed: changed,
+	}
+	handler := FeedHandler(ds, output)
+
+	get := func(etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	etag := get("").Header().Get("ETag")
+
+	ds.getLinks = 0
+	if w := get(etag); w.Code != http.StatusNotModified || ds.getLinks != 0 {
+		t.Fatalf("unexpected response before the link expired. expected %v without reading links, got %v (%v reads)", http.StatusNotModified, w.Code, ds.getLinks)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	w := get(etag)
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status code after the link expired. expected %v, got %v", http.StatusOK, w.Code)
+	}
+	if w.Header().Get("ETag") == etag {
+		t.Error("ETag should change when a link leaves the maximum age window")
+	}
+}
+
+func TestLinkStatusHandler(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		method string
+		url    string
+		err    error
+		status int
+	}{
+		{
+			desc:   "mark existing link",
+			method: "POST",
+			url:    "http://example.com",
+			status: http.StatusNoContent,
+		},
+		{
+			desc:   "unknown link",
+			method: "POST",
+			url:    "http://example.org",
+			err:    ErrNotFound,
+			status: http.StatusNotFound,
+		},
+		{
+			desc:   "missing url",
+			method: "POST",
+			status: http.StatusBadRequest,
+		},
+		{
+			desc:   "invalid method",
+			method: "GET",
+			url:    "http://example.com",
+			status: http.StatusMethodNotAllowed,
+		},
+		{
+			desc:   "datastore error",
+			method: "POST",
+			url:    "http://example.com",
+			err:    errors.New("expected test failure"),
+			status: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tC.method, "/?url="+url.QueryEscape(tC.url), nil)
+			w := httptest.NewRecorder()
+
+			ds := &mockDatastore{err: tC.err}
+			LinkStatusHandler(ds, LinkDownloaded).ServeHTTP(w, req)
+
+			if w.Code != tC.status {
+				t.Errorf("unexpected status code. expected %v, got %v", tC.status, w.Code)
+			}
+
+			if tC.status == http.StatusNoContent && ds.status[tC.url] != LinkDownloaded {
+				t.Errorf("link status not updated. expected %v, got %v", LinkDownloaded, ds.status[tC.url])
+			}
+		})
+	}
+}
+
 type mockDatastore struct {
 	Datastore
 
-	links []Link
-	err   error
+	items   []Item
+	links   []Link
+	err     error
+	status  map[string]LinkStatus
+	history map[string][]FetchResult
+	feeds   map[string]FeedConfig
+	changed time.Time
+	// getLinks counts the calls of GetLinks
+	getLinks int
+}
+
+func (m *mockDatastore) LastChange() (time.Time, error) {
+	return m.changed, m.err
+}
+
+func (m *mockDatastore) StoreFeed(fc FeedConfig) error {
+	if m.feeds == nil {
+		m.feeds = make(map[string]FeedConfig)
+	}
+	m.feeds[fc.URL] = fc
+	return m.err
+}
+
+func (m *mockDatastore) GetFeeds() ([]FeedConfig, error) {
+	var feeds []FeedConfig
+	for _, fc := range m.feeds {
+		feeds = append(feeds, fc)
+	}
+	return feeds, m.err
+}
+
+func (m *mockDatastore) RemoveFeed(url string) error {
+	if _, ok := m.feeds[url]; !ok {
+		return ErrNotFound
+	}
+	delete(m.feeds, url)
+	return m.err
+}
+
+func (m *mockDatastore) GetItems(maxAge time.Duration) ([]Item, error) {
+	return m.items, m.err
+}
+
+func (m *mockDatastore) LastAttempt(key string) (time.Time, int, error) {
+	return time.Time{}, 0, m.err
+}
+
+func (m *mockDatastore) FetchHistory(key string) ([]FetchResult, error) {
+	return m.history[key], m.err
 }
 
 func (m *mockDatastore) GetLinks(maxAge time.Duration) ([]Link, error) {
+	m.getLinks++
 	return m.links, m.err
 }
+
+func (m *mockDatastore) SetLinkStatus(url string, status LinkStatus) error {
+	if m.err != nil {
+		return m.err
+	}
+	if m.status == nil {
+		m.status = make(map[string]LinkStatus)
+	}
+	m.status[url] = status
+	return nil
+}
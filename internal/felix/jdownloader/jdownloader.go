@@ -0,0 +1,336 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package jdownloader implements a felix.LinkSink that writes new links into the folderwatch
+// directory of JDownloader, either as .crawljob files or as plain link lists.
+package jdownloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/martinplaner/felix/internal/felix"
+	"github.com/pkg/errors"
+)
+
+// Available file formats.
+const (
+	FormatCrawljob = "crawljob"
+	FormatList     = "list"
+)
+
+// Config contains the configuration of a JDownloader sink.
+type Config struct {
+	// Dir is the watched directory.
+	Dir string `yaml:"dir"`
+	// Format is either "crawljob" (default) or "list" (one URL per line).
+	Format string `yaml:"format"`
+	// Ext is the extension of the written files, defaults to ".crawljob" or ".txt", depending on the format.
+	Ext string `yaml:"ext"`
+	// BatchWindow collects all links within the window into a single file.
+	// Every link is written to its own file right away if it is 0.
+	BatchWindow time.Duration `yaml:"batchWindow"`
+	// DryRun only logs the files that would be written.
+	DryRun bool `yaml:"dryRun"`
+
+	// Crawljob flags
+	AutoStart        bool     `yaml:"autoStart"`
+	AutoConfirm      bool     `yaml:"autoConfirm"`
+	Extract          bool     `yaml:"extract"`
+	ExtractPasswords []string `yaml:"extractPasswords"`
+	DownloadFolder   string   `yaml:"downloadFolder"`
+	Priority         string   `yaml:"priority"`
+	Comment          string   `yaml:"comment"`
+	// TrimExt removes the file extension from the title derived from the URL.
+	TrimExt bool `yaml:"trimExt"`
+}
+
+// ItemGetter returns the stored item with the given URL, e.g. from a felix.Datastore.
+type ItemGetter interface {
+	GetItem(url string) (felix.Item, error)
+}
+
+// Sink writes links into the watched directory of JDownloader.
+type Sink struct {
+	name   string
+	config Config
+	queue  felix.SinkQueue
+	items  ItemGetter
+	title  felix.LinkFilter
+	log    felix.Logger
+
+	mu     sync.Mutex
+	timer  *time.Timer
+	closed bool
+}
+
+var _ felix.LinkSink = new(Sink)
+
+// New creates a new JDownloader sink with the given name. The links of the current batch are stored in
+// the queue under the name, so they are not lost if the batch could not be written or on restarts.
+// The package name of a link is the title of its source item, as returned by items.
+func New(name string, config Config, queue felix.SinkQueue, items ItemGetter) (*Sink, error) {
+	if config.Dir == "" && !config.DryRun {
+		return nil, errors.New("missing dir")
+	}
+
+	switch config.Format {
+	case "", FormatCrawljob:
+		config.Format = FormatCrawljob
+		if config.Ext == "" {
+			config.Ext = ".crawljob"
+		}
+	case FormatList:
+		if config.Ext == "" {
+			config.Ext = ".txt"
+		}
+	default:
+		return nil, errors.Errorf("unknown format %q", config.Format)
+	}
+
+	return &Sink{
+		name:   name,
+		config: config,
+		queue:  queue,
+		items:  items,
+		title:  felix.LinkFilenameAsTitleFilter(config.TrimExt),
+		log:    &felix.NopLogger{},
+	}, nil
+}
+
+// SetLogger sets the logger that is used by the sink.
+func (s *Sink) SetLogger(log felix.Logger) {
+	s.log = log
+}
+
+// queueName is the name of the queue of the current batch.
+// It is separate from the retry queue of the sink itself.
+func (s *Sink) queueName() string {
+	return s.name + "/batch"
+}
+
+// Send writes the link into the watched directory.
+// If a batch window is configured, the link is added to the current batch, which is
+// written when the window has passed.
+func (s *Sink) Send(ctx context.Context, link felix.Link) error {
+	if s.config.BatchWindow <= 0 {
+		return s.write([]felix.Link{link})
+	}
+
+	if err := s.queue.QueueLink(s.queueName(), link); err != nil {
+		return errors.Wrap(err, "could not add link to batch")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedule()
+	return nil
+}
+
+// Start schedules the batch that was not written before a restart, if there is one.
+func (s *Sink) Start() {
+	if s.config.BatchWindow <= 0 {
+		return
+	}
+
+	links, err := s.queue.QueuedLinks(s.queueName())
+	if err != nil {
+		s.log.Error("could not get batch links", "err", err, "sink", s.name)
+		return
+	}
+
+	if len(links) > 0 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.schedule()
+	}
+}
+
+// schedule starts the timer of the current batch, unless it is already running or the sink is closed.
+// If the batch could not be written, it is retried after another window. s.mu must be held.
+func (s *Sink) schedule() {
+	if s.timer != nil || s.closed {
+		return
+	}
+
+	s.timer = time.AfterFunc(s.config.BatchWindow, func() {
+		if err := s.Flush(); err != nil {
+			s.log.Error("could not write batch of links", "err", err, "dir", s.config.Dir, "retry", s.config.BatchWindow)
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.schedule()
+		}
+	})
+}
+
+// Flush writes all links of the current batch.
+// The links are kept for the next batch if they could not be written.
+func (s *Sink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+
+	if s.config.BatchWindow <= 0 {
+		return nil
+	}
+
+	links, err := s.queue.QueuedLinks(s.queueName())
+	if err != nil {
+		return errors.Wrap(err, "could not get batch links")
+	}
+
+	if len(links) == 0 {
+		return nil
+	}
+
+	if err := s.write(links); err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		if err := s.queue.DequeueLink(s.queueName(), link.URL); err != nil {
+			return errors.Wrap(err, "could not remove link from batch")
+		}
+	}
+	return nil
+}
+
+// Close writes the current batch. Links that could not be written stay in the queue
+// and are written after a restart (see Start).
+func (s *Sink) Close() error {
+	err := s.Flush()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+
+	return err
+}
+
+// write atomically writes the links into a new file in the watched directory.
+func (s *Sink) write(links []felix.Link) error {
+	content := s.render(links)
+	name, err := newFileName(s.config.Ext)
+	if err != nil {
+		return err
+	}
+
+	if s.config.DryRun {
+		s.log.Info("dry run, not writing file", "file", filepath.Join(s.config.Dir, name), "links", len(links), "content", string(content))
+		return nil
+	}
+
+	// Write to a temporary file first, so the folderwatch never sees incomplete files
+	tmp, err := ioutil.TempFile(s.config.Dir, ".felix-")
+	if err != nil {
+		return errors.Wrap(err, "could not create temporary file")
+	}
+
+	_, err = tmp.Write(content)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(s.config.Dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, "could not write file")
+	}
+
+	s.log.Info("wrote links", "file", name, "links", len(links))
+	return nil
+}
+
+func (s *Sink) render(links []felix.Link) []byte {
+	var b bytes.Buffer
+
+	if s.config.Format == FormatList {
+		for _, link := range links {
+			fmt.Fprintln(&b, link.URL)
+		}
+		return b.Bytes()
+	}
+
+	for i, link := range links {
+		if i > 0 {
+			// Jobs are separated by an empty line
+			b.WriteString("\n")
+		}
+
+		prop := func(key, value string) {
+			if value != "" {
+				// Values must fit into a single line
+				fmt.Fprintf(&b, "%s=%s\n", key, strings.Join(strings.Fields(value), " "))
+			}
+		}
+		flag := func(key string, value bool) {
+			prop(key, strings.ToUpper(fmt.Sprint(value)))
+		}
+
+		prop("text", link.URL)
+		prop("packageName", s.packageName(link))
+		prop("filename", s.filename(link))
+		prop("comment", s.config.Comment)
+		prop("downloadFolder", s.config.DownloadFolder)
+		prop("priority", s.config.Priority)
+		prop("extractPasswords", strings.Join(s.config.ExtractPasswords, ","))
+		flag("enabled", true)
+		flag("autoStart", s.config.AutoStart)
+		flag("autoConfirm", s.config.AutoConfirm)
+		flag("extractAfterDownload", s.config.Extract)
+	}
+
+	return b.Bytes()
+}
+
+// filename returns the title of the link as determined by felix.LinkFilenameAsTitleFilter.
+func (s *Sink) filename(link felix.Link) string {
+	title := link.Title
+	s.title.Filter(link, func(l felix.Link) {
+		title = l.Title
+	})
+	return title
+}
+
+// packageName returns the title of the source item of the link, or its title, if there is no such item.
+func (s *Sink) packageName(link felix.Link) string {
+	if s.items != nil && link.Source != "" {
+		item, err := s.items.GetItem(link.Source)
+		if err == nil && item.Title != "" {
+			return item.Title
+		}
+		if err != nil && err != felix.ErrNotFound {
+			s.log.Error("could not get source item", "err", err, "url", link.Source)
+		}
+	}
+	return link.Title
+}
+
+// newFileName returns a new unique file name with the given extension.
+func newFileName(ext string) (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "could not generate file name")
+	}
+	return fmt.Sprintf("felix-%d-%s%s", time.Now().UnixNano(), hex.EncodeToString(b), ext), nil
+}
@@ -0,0 +1,277 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jdownloader
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/martinplaner/felix/internal/felix"
+)
+
+func TestSink_Send(t *testing.T) {
+	items := itemMap{
+		"http://example.com/page": {URL: "http://example.com/page", Title: "Some Item"},
+	}
+
+	testCases := []struct {
+		desc     string
+		config   Config
+		links    []felix.Link
+		expected string
+	}{
+		{
+			desc:   "crawljob",
+			config: Config{AutoStart: true, Extract: true, TrimExt: true},
+			links: []felix.Link{
+				{URL: "http://example.com/file.part1.rar", Source: "http://example.com/page"},
+			},
+			expected: `text=http://example.com/file.part1.rar
+packageName=Some Item
+filename=file.part1
+enabled=TRUE
+autoStart=TRUE
+autoConfirm=FALSE
+extractAfterDownload=TRUE
+`,
+		},
+		{
+			desc:   "crawljob without source item",
+			config: Config{DownloadFolder: "/downloads", Comment: "multi\nline"},
+			links: []felix.Link{
+				{URL: "http://example.com/dir/", Title: "Link Title", Source: "http://example.com/feed"},
+			},
+			expected: `text=http://example.com/dir/
+packageName=Link Title
+filename=Link Title
+comment=multi line
+downloadFolder=/downloads
+enabled=TRUE
+autoStart=FALSE
+autoConfirm=FALSE
+extractAfterDownload=FALSE
+`,
+		},
+		{
+			desc:   "list",
+			config: Config{Format: FormatList},
+			links: []felix.Link{
+				{URL: "http://example.com/a"},
+			},
+			expected: "http://example.com/a\n",
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+
+			tC.config.Dir = dir
+			sink, err := New("jd", tC.config, nil, items)
+			if err != nil {
+				t.Fatalf("could not create sink: %v", err)
+			}
+
+			for _, link := range tC.links {
+				if err := sink.Send(context.Background(), link); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			files := readFiles(t, dir)
+			if len(files) != 1 {
+				t.Fatalf("unexpected number of files. expected %v, got %v", 1, len(files))
+			}
+			for _, content := range files {
+				if content != tC.expected {
+					t.Errorf("unexpected file content. expected:\n%s\ngot:\n%s", tC.expected, content)
+				}
+			}
+		})
+	}
+}
+
+func TestSink_Batch(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	queue := &memQueue{}
+	sink, err := New("jd", Config{Dir: dir, Format: FormatList, BatchWindow: 1 * time.Hour}, queue, nil)
+	if err != nil {
+		t.Fatalf("could not create sink: %v", err)
+	}
+
+	sink.Send(context.Background(), felix.Link{URL: "http://example.com/a"})
+	sink.Send(context.Background(), felix.Link{URL: "http://example.com/b"})
+
+	if files := readFiles(t, dir); len(files) != 0 {
+		t.Fatalf("links should not be written before the batch window has passed, got %v", files)
+	}
+	if links := queue.links("jd/batch"); len(links) != 2 {
+		t.Fatalf("links of the batch should be queued, got %v", links)
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files := readFiles(t, dir)
+	if len(files) != 1 {
+		t.Fatalf("unexpected number of files. expected %v, got %v", 1, len(files))
+	}
+	for name, content := range files {
+		if filepath.Ext(name) != ".txt" {
+			t.Errorf("unexpected file extension of %v", name)
+		}
+		if expected := "http://example.com/a\nhttp://example.com/b\n"; content != expected {
+			t.Errorf("unexpected file content. expected %q, got %q", expected, content)
+		}
+	}
+}
+
+func TestSink_BatchRetry(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	watched := filepath.Join(dir, "watched")
+
+	queue := &memQueue{}
+	queue.QueueLink("jd/batch", felix.Link{URL: "http://example.com/a"})
+
+	sink, err := New("jd", Config{Dir: watched, Format: FormatList, BatchWindow: 10 * time.Millisecond}, queue, nil)
+	if err != nil {
+		t.Fatalf("could not create sink: %v", err)
+	}
+	defer sink.Close()
+
+	// The batch left over from a restart is scheduled, but cannot be written yet
+	sink.Start()
+	time.Sleep(50 * time.Millisecond)
+	if links := queue.links("jd/batch"); len(links) != 1 {
+		t.Fatalf("links that could not be written should stay queued, got %v", links)
+	}
+
+	if err := os.Mkdir(watched, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(1 * time.Second)
+	for len(queue.links("jd/batch")) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("batch was not retried")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if files := readFiles(t, watched); len(files) != 1 {
+		t.Errorf("unexpected number of files. expected %v, got %v", 1, len(files))
+	}
+}
+
+func TestSink_DryRun(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	sink, err := New("jd", Config{Dir: dir, DryRun: true}, nil, nil)
+	if err != nil {
+		t.Fatalf("could not create sink: %v", err)
+	}
+
+	if err := sink.Send(context.Background(), felix.Link{URL: "http://example.com/a"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if files := readFiles(t, dir); len(files) != 0 {
+		t.Errorf("dry run should not write files, got %v", files)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("jd", Config{}, nil, nil); err == nil {
+		t.Error("expected error for missing dir")
+	}
+	if _, err := New("jd", Config{Dir: ".", Format: "xml"}, nil, nil); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+type itemMap map[string]felix.Item
+
+func (m itemMap) GetItem(url string) (felix.Item, error) {
+	item, ok := m[url]
+	if !ok {
+		return felix.Item{}, felix.ErrNotFound
+	}
+	return item, nil
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "felix_jdownloader")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	return dir
+}
+
+// readFiles returns the contents of all files in dir by name.
+func readFiles(t *testing.T, dir string) map[string]string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("could not read dir: %v", err)
+	}
+
+	files := make(map[string]string)
+	for _, info := range infos {
+		b, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			t.Fatalf("could not read file: %v", err)
+		}
+		files[info.Name()] = string(b)
+	}
+	return files
+}
+
+// memQueue is an in-memory felix.SinkQueue.
+type memQueue struct {
+	mu    sync.Mutex
+	queue map[string][]felix.Link
+}
+
+func (q *memQueue) links(sink string) []felix.Link {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]felix.Link(nil), q.queue[sink]...)
+}
+
+func (q *memQueue) QueueLink(sink string, link felix.Link) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.queue == nil {
+		q.queue = make(map[string][]felix.Link)
+	}
+	q.queue[sink] = append(q.queue[sink], link)
+	return nil
+}
+
+func (q *memQueue) QueuedLinks(sink string) ([]felix.Link, error) {
+	return q.links(sink), nil
+}
+
+func (q *memQueue) DequeueLink(sink string, url string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var links []felix.Link
+	for _, l := range q.queue[sink] {
+		if l.URL != url {
+			links = append(links, l)
+		}
+	}
+	q.queue[sink] = links
+	return nil
+}
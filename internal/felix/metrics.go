@@ -0,0 +1,253 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/martinplaner/felix/internal/felix/metrics"
+	"github.com/pkg/errors"
+)
+
+var (
+	fetchesTotal = metrics.NewCounterVec("felix_fetches_total",
+		"Number of fetch attempts by feed, result and HTTP status code.", "feed", "result", "status")
+	fetchDuration = metrics.NewHistogramVec("felix_fetch_duration_seconds",
+		"Duration of fetch attempts, including all followed URLs.", metrics.DefBuckets, "feed")
+	emittedItems = metrics.NewCounterVec("felix_emitted_items_total",
+		"Number of items emitted by fetchers.", "feed")
+	emittedLinks = metrics.NewCounterVec("felix_emitted_links_total",
+		"Number of links emitted by fetchers.", "feed")
+	filterPassed = metrics.NewCounterVec("felix_filter_passed_total",
+		"Number of entries passed on by a filter, by its position in the chain (starting at 1).", "chain", "position", "filter")
+	filterDropped = metrics.NewCounterVec("felix_filter_dropped_total",
+		"Number of entries dropped by a filter, by its position in the chain (starting at 1).", "chain", "position", "filter")
+	sourceRequests = metrics.NewCounterVec("felix_source_requests_total",
+		"Number of HTTP requests made by the source, by status code.", "status")
+	sourceDuration = metrics.NewHistogram("felix_source_request_duration_seconds",
+		"Duration of HTTP requests made by the source, including reading the body.", metrics.DefBuckets)
+	sourceBytes = metrics.NewCounter("felix_source_response_bytes_total",
+		"Number of (decoded) response body bytes read by the source.")
+	sinkDeliveries = metrics.NewCounterVec("felix_sink_deliveries_total",
+		"Number of link deliveries to sinks by result (success, error, permanent).", "sink", "result")
+	sinkDuration = metrics.NewHistogramVec("felix_sink_delivery_duration_seconds",
+		"Duration of link deliveries to sinks, including retries of the sink itself.", metrics.DefBuckets, "sink")
+	httpDuration = metrics.NewHistogramVec("felix_http_request_duration_seconds",
+		"Latency of HTTP requests served by felix, by handler and status code.", metrics.DefBuckets, "handler", "code")
+)
+
+// MetricsHandler serves all collected metrics in the Prometheus text format.
+func MetricsHandler() http.Handler {
+	return metrics.Handler(metrics.DefaultRegistry)
+}
+
+// observeFetch records the metrics of a single fetch attempt for the given feed label.
+func observeFetch(feed string, result FetchResult) {
+	r := "success"
+	if !result.Success() {
+		r = "error"
+	}
+
+	fetchesTotal.With(feed, r, strconv.Itoa(result.Status)).Inc()
+	fetchDuration.With(feed).Observe(result.Duration.Seconds())
+	emittedItems.With(feed).Add(float64(result.Items))
+	emittedLinks.With(feed).Add(float64(result.Links))
+}
+
+// observeSource records the metrics of a single source request.
+func observeSource(start time.Time, code int, n int64, err error) {
+	status := strconv.Itoa(code)
+	if err != nil && code == 0 {
+		status = "error"
+		if nerr, ok := errors.Cause(err).(net.Error); ok && nerr.Timeout() {
+			status = "timeout"
+		}
+	}
+
+	sourceRequests.With(status).Inc()
+	sourceDuration.Observe(time.Since(start).Seconds())
+	sourceBytes.Add(float64(n))
+}
+
+// observeSink records the metrics of a single delivery to a sink.
+func observeSink(sink string, start time.Time, err error) {
+	result := "success"
+	if IsPermanent(err) {
+		result = "permanent"
+	} else if err != nil {
+		result = "error"
+	}
+
+	sinkDeliveries.With(sink, result).Inc()
+	sinkDuration.With(sink).Observe(time.Since(start).Seconds())
+}
+
+// Namer is an optional interface for filters to provide a name for metrics and diagnostics.
+type Namer interface {
+	Name() string
+}
+
+// filterName returns the name of the given filter, if it implements Namer, or a placeholder otherwise.
+func filterName(f interface{}) string {
+	if n, ok := f.(Namer); ok {
+		return n.Name()
+	}
+	return "unnamed"
+}
+
+// internal helper type to attach a name to an item filter, keeping its textual representation
+type namedItemFilter struct {
+	ItemFilter
+	name string
+}
+
+func (f namedItemFilter) Name() string {
+	return f.name
+}
+
+func (f namedItemFilter) String() string {
+	if s, ok := f.ItemFilter.(Stringer); ok {
+		return s.String()
+	}
+	return ""
+}
+
+func (f namedItemFilter) ExplainItem(item Item) string {
+	if e, ok := f.ItemFilter.(ItemExplainer); ok {
+		return e.ExplainItem(item)
+	}
+	return ""
+}
+
+// NamedItemFilter attaches the given name (e.g. the configured filter type) to the item filter.
+// The name is used to label the filter metrics.
+func NamedItemFilter(name string, f ItemFilter) ItemFilter {
+	return namedItemFilter{f, name}
+}
+
+// internal helper type to attach a name to a link filter, keeping its textual representation
+type namedLinkFilter struct {
+	LinkFilter
+	name string
+}
+
+func (f namedLinkFilter) Name() string {
+	return f.name
+}
+
+func (f namedLinkFilter) String() string {
+	if s, ok := f.LinkFilter.(Stringer); ok {
+		return s.String()
+	}
+	return ""
+}
+
+func (f namedLinkFilter) ExplainLink(link Link) string {
+	if e, ok := f.LinkFilter.(LinkExplainer); ok {
+		return e.ExplainLink(link)
+	}
+	return ""
+}
+
+// NamedLinkFilter attaches the given name (e.g. the configured filter type) to the link filter.
+// The name is used to label the filter metrics.
+func NamedLinkFilter(name string, f LinkFilter) LinkFilter {
+	return namedLinkFilter{f, name}
+}
+
+// instrumentItemFilters wraps the filters to count the passed and dropped items of each one.
+func instrumentItemFilters(filters []ItemFilter) []ItemFilter {
+	instrumented := make([]ItemFilter, len(filters))
+	for i, f := range filters {
+		f := f
+		passed := filterPassed.With("item", strconv.Itoa(i+1), filterName(f))
+		dropped := filterDropped.With("item", strconv.Itoa(i+1), filterName(f))
+		instrumented[i] = ItemFilterFunc(func(item Item, next func(Item)) {
+			ok := false
+			f.Filter(item, func(item Item) {
+				ok = true
+				passed.Inc()
+				next(item)
+			})
+			if !ok {
+				dropped.Inc()
+			}
+		})
+	}
+	return instrumented
+}
+
+// instrumentLinkFilters wraps the filters to count the passed and dropped links of each one.
+func instrumentLinkFilters(filters []LinkFilter) []LinkFilter {
+	instrumented := make([]LinkFilter, len(filters))
+	for i, f := range filters {
+		f := f
+		passed := filterPassed.With("link", strconv.Itoa(i+1), filterName(f))
+		dropped := filterDropped.With("link", strconv.Itoa(i+1), filterName(f))
+		instrumented[i] = LinkFilterFunc(func(link Link, next func(Link)) {
+			ok := false
+			f.Filter(link, func(link Link) {
+				ok = true
+				passed.Inc()
+				next(link)
+			})
+			if !ok {
+				dropped.Inc()
+			}
+		})
+	}
+	return instrumented
+}
+
+// InstrumentHandler records the latency of all requests served by h, labeled with the given handler name.
+func InstrumentHandler(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+		if sw.code == 0 {
+			sw.code = http.StatusOK
+		}
+		httpDuration.With(name, strconv.Itoa(sw.code)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusWriter records the status code of the response.
+// It implements http.Flusher and http.Hijacker, if the underlying ResponseWriter does.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("hijacking not supported")
+}
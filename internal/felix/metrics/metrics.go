@@ -0,0 +1,428 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package metrics implements simple counters, gauges and histograms
+// that are exposed in the Prometheus text format.
+//
+// See https://prometheus.io/docs/instrumenting/exposition_formats/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets, suitable for durations in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// DefaultRegistry is the registry used by the package level constructors.
+var DefaultRegistry = NewRegistry()
+
+// collector is a metric family that can write itself in the text exposition format.
+type collector interface {
+	desc() *desc
+	write(w io.Writer) error
+}
+
+// Registry is a set of metric families.
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]collector
+}
+
+// NewRegistry creates a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: make(map[string]collector),
+	}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := c.desc().name
+	if _, ok := r.collectors[name]; ok {
+		panic(fmt.Sprintf("metrics: duplicate metric %q", name))
+	}
+	r.collectors[name] = c
+}
+
+// WriteTo writes all metrics of the registry, sorted by name, in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	collectors := r.collectors
+	r.mu.RUnlock()
+
+	sort.Strings(names)
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, name := range names {
+		c := collectors[name]
+		d := c.desc()
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.typ)
+		if err := c.write(cw); err != nil {
+			return cw.n, err
+		}
+	}
+
+	return cw.n, cw.w.(*bufio.Writer).Flush()
+}
+
+// Handler serves the metrics of the registry in the text exposition format.
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// desc describes a metric family.
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+// key returns the map key of the given label values.
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelString formats the label pairs of the given values, including extra pairs (e.g. "le").
+func (d *desc) labelString(values []string, extra ...string) string {
+	var pairs []string
+	for i, l := range d.labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", l, escapeLabel(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", extra[i], extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	mu     sync.Mutex
+	value  float64
+	labels []string
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds v to the counter. Negative values are ignored.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	c.mu.Lock()
+	c.value += v
+	c.mu.Unlock()
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+// CounterVec is a family of counters, partitioned by label values.
+type CounterVec struct {
+	d        *desc
+	mu       sync.Mutex
+	counters map[string]*Counter
+}
+
+// NewCounterVec creates and registers a new CounterVec with the given label names.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		d:        &desc{name: name, help: help, typ: "counter", labels: labels},
+		counters: make(map[string]*Counter),
+	}
+	r.register(c)
+	return c
+}
+
+// NewCounterVec creates a new CounterVec in the DefaultRegistry.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return DefaultRegistry.NewCounterVec(name, help, labels...)
+}
+
+// NewCounter creates a new Counter without labels in the DefaultRegistry.
+func NewCounter(name, help string) *Counter {
+	return DefaultRegistry.NewCounterVec(name, help).With()
+}
+
+// With returns the counter for the given label values, creating it if necessary.
+func (c *CounterVec) With(values ...string) *Counter {
+	key := c.d.key(values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	counter, ok := c.counters[key]
+	if !ok {
+		counter = &Counter{labels: values}
+		c.counters[key] = counter
+	}
+	return counter
+}
+
+func (c *CounterVec) desc() *desc {
+	return c.d
+}
+
+func (c *CounterVec) write(w io.Writer) error {
+	for _, counter := range c.sorted() {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.d.name, c.d.labelString(counter.labels), formatFloat(counter.Value())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CounterVec) sorted() []*Counter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, len(c.counters))
+	for k := range c.counters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	counters := make([]*Counter, 0, len(keys))
+	for _, k := range keys {
+		counters = append(counters, c.counters[k])
+	}
+	return counters
+}
+
+// GaugeFunc is a gauge whose values are determined by a function at collection time.
+type GaugeFunc struct {
+	d  *desc
+	fn func() map[string]float64
+}
+
+// NewGaugeFunc creates and registers a new gauge without labels, whose value is returned by fn.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	return r.NewGaugeVecFunc(name, help, "", func() map[string]float64 {
+		return map[string]float64{"": fn()}
+	})
+}
+
+// NewGaugeVecFunc creates and registers a new gauge family with a single label.
+// fn returns the values of the gauges by label value. If label is empty, the gauge has no labels.
+func (r *Registry) NewGaugeVecFunc(name, help, label string, fn func() map[string]float64) *GaugeFunc {
+	g := &GaugeFunc{
+		d:  &desc{name: name, help: help, typ: "gauge"},
+		fn: fn,
+	}
+	if label != "" {
+		g.d.labels = []string{label}
+	}
+	r.register(g)
+	return g
+}
+
+// NewGaugeFunc creates a new GaugeFunc in the DefaultRegistry.
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	return DefaultRegistry.NewGaugeFunc(name, help, fn)
+}
+
+// NewGaugeVecFunc creates a new GaugeFunc with a single label in the DefaultRegistry.
+func NewGaugeVecFunc(name, help, label string, fn func() map[string]float64) *GaugeFunc {
+	return DefaultRegistry.NewGaugeVecFunc(name, help, label, fn)
+}
+
+func (g *GaugeFunc) desc() *desc {
+	return g.d
+}
+
+func (g *GaugeFunc) write(w io.Writer) error {
+	values := g.fn()
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		var labels []string
+		if len(g.d.labels) > 0 {
+			labels = []string{k}
+		}
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", g.d.name, g.d.labelString(labels), formatFloat(values[k])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Histogram counts observations in configurable buckets.
+type Histogram struct {
+	mu     sync.Mutex
+	upper  []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+	labels []string
+}
+
+// Observe adds a single observation to the histogram.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, upper := range h.upper {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// HistogramVec is a family of histograms, partitioned by label values.
+type HistogramVec struct {
+	d          *desc
+	buckets    []float64
+	mu         sync.Mutex
+	histograms map[string]*Histogram
+}
+
+// NewHistogramVec creates and registers a new HistogramVec with the given buckets (upper bounds) and label names.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+
+	h := &HistogramVec{
+		d:          &desc{name: name, help: help, typ: "histogram", labels: labels},
+		buckets:    b,
+		histograms: make(map[string]*Histogram),
+	}
+	r.register(h)
+	return h
+}
+
+// NewHistogramVec creates a new HistogramVec in the DefaultRegistry.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return DefaultRegistry.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// NewHistogram creates a new Histogram without labels in the DefaultRegistry.
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return DefaultRegistry.NewHistogramVec(name, help, buckets).With()
+}
+
+// With returns the histogram for the given label values, creating it if necessary.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	key := h.d.key(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	histogram, ok := h.histograms[key]
+	if !ok {
+		histogram = &Histogram{
+			upper:  h.buckets,
+			counts: make([]uint64, len(h.buckets)),
+			labels: values,
+		}
+		h.histograms[key] = histogram
+	}
+	return histogram
+}
+
+func (h *HistogramVec) desc() *desc {
+	return h.d
+}
+
+func (h *HistogramVec) write(w io.Writer) error {
+	h.mu.Lock()
+	keys := make([]string, 0, len(h.histograms))
+	for k := range h.histograms {
+		keys = append(keys, k)
+	}
+	histograms := h.histograms
+	h.mu.Unlock()
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		hist := histograms[k]
+		hist.mu.Lock()
+		for i, upper := range hist.upper {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.d.name, h.d.labelString(hist.labels, "le", formatFloat(upper)), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.d.name, h.d.labelString(hist.labels, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.d.name, h.d.labelString(hist.labels), formatFloat(hist.sum))
+		_, err := fmt.Fprintf(w, "%s_count%s %d\n", h.d.name, h.d.labelString(hist.labels), hist.count)
+		hist.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	// Quotes are escaped by %q
+	labelEscaper = strings.NewReplacer("\n", " ")
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+
+	c := r.NewCounterVec("test_total", "Test counter.", "a", "b")
+	c.With("x", "y").Inc()
+	c.With("x", "y").Add(2)
+	c.With("q\"uote", "").Inc()
+
+	r.NewGaugeVecFunc("test_gauge", "Test gauge.", "bucket", func() map[string]float64 {
+		return map[string]float64{"two": 2, "one": 1}
+	})
+	r.NewGaugeFunc("test_plain", "Plain gauge.", func() float64 { return 0.5 })
+
+	h := r.NewHistogramVec("test_seconds", "Test histogram.", []float64{1, 0.1}, "l")
+	h.With("v").Observe(0.05)
+	h.With("v").Observe(0.5)
+	h.With("v").Observe(5)
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `# HELP test_gauge Test gauge.
+# TYPE test_gauge gauge
+test_gauge{bucket="one"} 1
+test_gauge{bucket="two"} 2
+# HELP test_plain Plain gauge.
+# TYPE test_plain gauge
+test_plain 0.5
+# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{l="v",le="0.1"} 1
+test_seconds_bucket{l="v",le="1"} 2
+test_seconds_bucket{l="v",le="+Inf"} 3
+test_seconds_sum{l="v"} 5.55
+test_seconds_count{l="v"} 3
+# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{a="q\"uote",b=""} 1
+test_total{a="x",b="y"} 3
+`
+
+	if buf.String() != expected {
+		t.Errorf("unexpected output. expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestRegistry_Duplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("dup_total", "")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on duplicate registration")
+		}
+	}()
+	r.NewCounterVec("dup_total", "")
+}
+
+func TestCounterVec_WrongLabels(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("labels_total", "", "a")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on wrong number of label values")
+		}
+	}()
+	c.With("a", "b")
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("handler_total", "").With().Inc()
+
+	w := httptest.NewRecorder()
+	Handler(r).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type: %q", ct)
+	}
+
+	if !strings.Contains(w.Body.String(), "handler_total 1\n") {
+		t.Errorf("unexpected body: %q", w.Body.String())
+	}
+}
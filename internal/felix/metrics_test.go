@@ -0,0 +1,79 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInstrumentLinkFilters(t *testing.T) {
+	dropOdd := LinkFilterFunc(func(link Link, next func(Link)) {
+		if len(link.URL)%2 == 0 {
+			next(link)
+		}
+	})
+
+	filters := instrumentLinkFilters([]LinkFilter{
+		NamedLinkFilter("test_pass", LinkFilterFunc(func(link Link, next func(Link)) { next(link) })),
+		NamedLinkFilter("test_odd", dropOdd),
+	})
+
+	passed0 := filterPassed.With("link", "1", "test_pass")
+	passed1 := filterPassed.With("link", "2", "test_odd")
+	dropped1 := filterDropped.With("link", "2", "test_odd")
+	before := []float64{passed0.Value(), passed1.Value(), dropped1.Value()}
+
+	links := FilterLinkSlice([]Link{{URL: "a"}, {URL: "bb"}, {URL: "ccc"}}, filters...)
+
+	if len(links) != 1 {
+		t.Errorf("unexpected number of links. expected %v, got %v", 1, len(links))
+	}
+
+	after := []float64{passed0.Value(), passed1.Value(), dropped1.Value()}
+	expected := []float64{3, 1, 2}
+	for i := range expected {
+		if after[i]-before[i] != expected[i] {
+			t.Errorf("unexpected counter %d. expected %v, got %v", i, expected[i], after[i]-before[i])
+		}
+	}
+}
+
+func TestNamedFilter(t *testing.T) {
+	f := NamedItemFilter("title", ItemTitleFilter("some title"))
+
+	if name := filterName(f); name != "title" {
+		t.Errorf("unexpected name. expected %v, got %v", "title", name)
+	}
+
+	if s := FilterString([]ItemFilter{f}, nil); !strings.Contains(s, "some title") {
+		t.Errorf("filter string was not preserved, got %q", s)
+	}
+
+	if name := filterName(LinkDomainFilter()); name != "unnamed" {
+		t.Errorf("unexpected name. expected %v, got %v", "unnamed", name)
+	}
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	h := InstrumentHandler("/test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("instrumented response writer does not implement http.Flusher")
+		}
+		http.Error(w, "nope", http.StatusTeapot)
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+
+	w := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	expected := `felix_http_request_duration_seconds_count{handler="/test",code="418"}`
+	if !strings.Contains(w.Body.String(), expected) {
+		t.Errorf("could not find %q in metrics output", expected)
+	}
+}
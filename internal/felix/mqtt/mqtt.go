@@ -0,0 +1,453 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package mqtt implements a felix.LinkSink that publishes new links and items to an MQTT 3.1.1 broker.
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/martinplaner/felix/internal/felix"
+	"github.com/pkg/errors"
+)
+
+// Default values of the MQTT configuration.
+const (
+	DefaultBroker     = "tcp://localhost:1883"
+	DefaultClientID   = "felix"
+	DefaultLinkTopic  = "felix/links"
+	DefaultItemTopic  = "felix/items"
+	DefaultKeepAlive  = 1 * time.Minute
+	DefaultTimeout    = 10 * time.Second
+	DefaultMinBackoff = 1 * time.Second
+	DefaultMaxBackoff = 2 * time.Minute
+)
+
+// Config contains the configuration of an MQTT sink.
+type Config struct {
+	// Broker is the address of the broker, e.g. tcp://localhost:1883 or localhost:1883.
+	Broker   string `yaml:"broker"`
+	ClientID string `yaml:"clientID"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// LinkTopic and ItemTopic are text/templates of the topics, see felix.SinkTemplate.
+	// They are executed with the Link or Item respectively. Items are not published if ItemTopic is "-".
+	LinkTopic string `yaml:"linkTopic"`
+	ItemTopic string `yaml:"itemTopic"`
+	// QoS is the quality of service level of the published messages (0, 1 or 2).
+	QoS    byte `yaml:"qos"`
+	Retain bool `yaml:"retain"`
+	// KeepAlive is the interval in which the connection is checked with ping requests.
+	KeepAlive time.Duration `yaml:"keepAlive"`
+	// Timeout of connecting and of waiting for the acknowledgement of a message.
+	Timeout time.Duration `yaml:"timeout"`
+	// MinBackoff and MaxBackoff limit the (exponentially growing) time between two connection attempts.
+	MinBackoff time.Duration `yaml:"minBackoff"`
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+}
+
+// Sink publishes links and items as JSON messages.
+type Sink struct {
+	config    Config
+	addr      string
+	linkTopic *template.Template
+	itemTopic *template.Template
+	log       felix.Logger
+
+	mu      sync.Mutex // guards the connection state
+	conn    *conn
+	backoff time.Duration
+	retryAt time.Time
+	closed  bool
+}
+
+var (
+	_ felix.LinkSink = new(Sink)
+	_ felix.ItemSink = new(Sink)
+)
+
+// New creates a new MQTT sink. Unset config values are replaced by their defaults.
+// The connection to the broker is established with the first message.
+func New(config Config) (*Sink, error) {
+	if config.Broker == "" {
+		config.Broker = DefaultBroker
+	}
+	if config.ClientID == "" {
+		config.ClientID = DefaultClientID
+	}
+	if config.LinkTopic == "" {
+		config.LinkTopic = DefaultLinkTopic
+	}
+	if config.ItemTopic == "" {
+		config.ItemTopic = DefaultItemTopic
+	}
+	if config.QoS > 2 {
+		return nil, errors.Errorf("invalid qos %d", config.QoS)
+	}
+	if config.KeepAlive <= 0 {
+		config.KeepAlive = DefaultKeepAlive
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = DefaultMinBackoff
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = DefaultMaxBackoff
+		if config.MaxBackoff < config.MinBackoff {
+			config.MaxBackoff = config.MinBackoff
+		}
+	}
+
+	addr, err := brokerAddr(config.Broker)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sink{
+		config: config,
+		addr:   addr,
+		log:    &felix.NopLogger{},
+	}
+
+	if s.linkTopic, err = felix.SinkTemplate("linkTopic", config.LinkTopic); err != nil {
+		return nil, errors.Wrap(err, "could not parse link topic")
+	}
+	if config.ItemTopic != "-" {
+		if s.itemTopic, err = felix.SinkTemplate("itemTopic", config.ItemTopic); err != nil {
+			return nil, errors.Wrap(err, "could not parse item topic")
+		}
+	}
+
+	return s, nil
+}
+
+// brokerAddr returns the host:port of the broker address.
+func brokerAddr(broker string) (string, error) {
+	if !strings.Contains(broker, "://") {
+		broker = "tcp://" + broker
+	}
+
+	u, err := url.Parse(broker)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid broker address")
+	}
+	if u.Scheme != "tcp" && u.Scheme != "mqtt" {
+		return "", errors.Errorf("unsupported broker scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return "", errors.New("missing broker host")
+	}
+	if u.Port() == "" {
+		return net.JoinHostPort(u.Hostname(), "1883"), nil
+	}
+	return u.Host, nil
+}
+
+// SetLogger sets the logger that is used by the sink.
+func (s *Sink) SetLogger(log felix.Logger) {
+	s.log = log
+}
+
+// Send publishes the link as JSON to the link topic.
+func (s *Sink) Send(ctx context.Context, link felix.Link) error {
+	return s.publish(ctx, s.linkTopic, link)
+}
+
+// SendItem publishes the item as JSON to the item topic.
+func (s *Sink) SendItem(ctx context.Context, item felix.Item) error {
+	if s.itemTopic == nil {
+		return nil
+	}
+	return s.publish(ctx, s.itemTopic, item)
+}
+
+// Close disconnects from the broker.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.conn == nil {
+		return nil
+	}
+
+	err := s.conn.write(packet{typ: typeDisconnect})
+	s.conn.close()
+	s.conn = nil
+	return err
+}
+
+func (s *Sink) publish(ctx context.Context, tmpl *template.Template, v interface{}) error {
+	var topic bytes.Buffer
+	if err := tmpl.Execute(&topic, v); err != nil {
+		return felix.Permanent(errors.Wrap(err, "could not execute topic template"))
+	}
+	if topic.Len() == 0 || strings.ContainsAny(topic.String(), "+#") {
+		return felix.Permanent(errors.Errorf("invalid topic %q", topic.String()))
+	}
+
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return felix.Permanent(errors.Wrap(err, "could not encode message"))
+	}
+
+	c, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	if err := c.publish(ctx, topic.String(), payload, s.config.QoS, s.config.Retain); err != nil {
+		s.disconnect(c, err)
+		return errors.Wrap(err, "could not publish message")
+	}
+
+	return nil
+}
+
+// connect returns the current connection or establishes a new one.
+// Failed attempts are not repeated before the backoff has elapsed, which doubles with every failure.
+func (s *Sink) connect(ctx context.Context) (*conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, errors.New("sink closed")
+	}
+	if s.conn != nil {
+		return s.conn, nil
+	}
+	if wait := time.Until(s.retryAt); wait > 0 {
+		return nil, errors.Errorf("not connected, next attempt in %v", wait.Round(time.Millisecond))
+	}
+
+	c, err := dial(ctx, s.addr, s.config)
+	if err != nil {
+		if s.backoff == 0 {
+			s.backoff = s.config.MinBackoff
+		} else if s.backoff *= 2; s.backoff > s.config.MaxBackoff {
+			s.backoff = s.config.MaxBackoff
+		}
+		s.retryAt = time.Now().Add(s.backoff)
+		return nil, errors.Wrap(err, "could not connect to broker")
+	}
+
+	s.log.Info("connected to mqtt broker", "broker", s.config.Broker)
+	s.backoff = 0
+	s.retryAt = time.Time{}
+	s.conn = c
+
+	go func() {
+		err := c.run(s.config.KeepAlive)
+		s.disconnect(c, err)
+	}()
+
+	return c, nil
+}
+
+// disconnect closes the connection after an error, so the next message reconnects.
+func (s *Sink) disconnect(c *conn, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != c {
+		// Already replaced or closed
+		return
+	}
+
+	s.log.Warn("disconnected from mqtt broker", "err", err, "broker", s.config.Broker)
+	c.close()
+	s.conn = nil
+}
+
+// conn is a single connection to the broker.
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+
+	wmu sync.Mutex // serializes writes
+
+	mu      sync.Mutex // guards the fields below
+	nextID  uint16
+	pending map[uint16]chan struct{}
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// dial connects to the broker and waits for the acknowledgement of the connection.
+func dial(ctx context.Context, addr string, config Config) (*conn, error) {
+	d := net.Dialer{Timeout: config.Timeout}
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conn{
+		nc:      nc,
+		r:       bufio.NewReader(nc),
+		pending: make(map[uint16]chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	keepAlive := uint16(config.KeepAlive / time.Second)
+	if config.KeepAlive > 0xffff*time.Second {
+		keepAlive = 0xffff
+	} else if keepAlive == 0 {
+		// 0 would turn off the keep alive mechanism of the broker
+		keepAlive = 1
+	}
+
+	nc.SetDeadline(time.Now().Add(config.Timeout))
+	if err := c.write(connectPacket(config.ClientID, config.Username, config.Password, keepAlive)); err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	p, err := readPacket(c.r)
+	if err == nil && (p.typ != typeConnack || len(p.body) != 2) {
+		err = errors.Errorf("unexpected packet type %d", p.typ)
+	}
+	if err == nil {
+		err = connackError(p.body[1])
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	nc.SetDeadline(time.Time{})
+
+	return c, nil
+}
+
+func (c *conn) write(p packet) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return writePacket(c.nc, p)
+}
+
+func (c *conn) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.nc.Close()
+	})
+}
+
+// publish sends the message and waits for its acknowledgement (QoS 1 and 2).
+// With QoS 2, the acknowledgement is the PUBCOMP that completes the handshake.
+func (c *conn) publish(ctx context.Context, topic string, payload []byte, qos byte, retain bool) error {
+	if qos == 0 {
+		return c.write(publishPacket(topic, payload, qos, retain, 0))
+	}
+
+	c.mu.Lock()
+	c.nextID++
+	if c.nextID == 0 {
+		// 0 is not a valid packet id
+		c.nextID++
+	}
+	id := c.nextID
+	ack := make(chan struct{})
+	c.pending[id] = ack
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(publishPacket(topic, payload, qos, retain, id)); err != nil {
+		return err
+	}
+
+	select {
+	case <-ack:
+		return nil
+	case <-c.done:
+		return errors.New("connection closed")
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "no acknowledgement")
+	}
+}
+
+// run reads the incoming packets and sends ping requests until the connection fails.
+func (c *conn) run(keepAlive time.Duration) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.read(keepAlive)
+	}()
+
+	// Ping well before the broker considers the connection lost (after 1.5 times the keep alive)
+	ticker := time.NewTicker(keepAlive / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-errc:
+			return err
+		case <-c.done:
+			return errors.New("connection closed")
+		case <-ticker.C:
+			if err := c.write(packet{typ: typePingreq}); err != nil {
+				return errors.Wrap(err, "could not send ping request")
+			}
+		}
+	}
+}
+
+func (c *conn) read(keepAlive time.Duration) error {
+	for {
+		// Without any packet (at least a ping response) for this long, the connection is considered lost
+		c.nc.SetReadDeadline(time.Now().Add(keepAlive + keepAlive/2))
+
+		p, err := readPacket(c.r)
+		if err != nil {
+			return err
+		}
+
+		switch p.typ {
+		case typePuback, typePubcomp:
+			id, err := packetID(p)
+			if err != nil {
+				return err
+			}
+			c.ack(id)
+		case typePubrec:
+			id, err := packetID(p)
+			if err != nil {
+				return err
+			}
+			if err := c.write(idPacket(typePubrel, id)); err != nil {
+				return err
+			}
+		case typePingresp:
+		default:
+			return errors.Errorf("unexpected packet type %d", p.typ)
+		}
+	}
+}
+
+func (c *conn) ack(id uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ack, ok := c.pending[id]; ok {
+		close(ack)
+		delete(c.pending, id)
+	}
+}
@@ -0,0 +1,389 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/martinplaner/felix/internal/felix"
+)
+
+// message is a message received by the test broker.
+type message struct {
+	topic   string
+	payload string
+	qos     byte
+	retain  bool
+}
+
+// broker is a minimal in-process MQTT broker that records all published messages.
+type broker struct {
+	ln     net.Listener
+	refuse byte // return code of CONNACK
+
+	mu       sync.Mutex
+	conns    []net.Conn
+	connects int
+	pings    int
+	username string
+	password string
+	messages []message
+}
+
+func newBroker(t *testing.T) *broker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &broker{ln: ln}
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			b.conns = append(b.conns, nc)
+			b.mu.Unlock()
+			go b.serve(nc)
+		}
+	}()
+
+	return b
+}
+
+func (b *broker) addr() string {
+	return b.ln.Addr().String()
+}
+
+// drop closes all client connections.
+func (b *broker) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, nc := range b.conns {
+		nc.Close()
+	}
+	b.conns = nil
+}
+
+func (b *broker) close() {
+	b.ln.Close()
+	b.drop()
+}
+
+func (b *broker) received() []message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]message(nil), b.messages...)
+}
+
+func (b *broker) serve(nc net.Conn) {
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+
+	p, err := readPacket(r)
+	if err != nil || p.typ != typeConnect {
+		return
+	}
+	b.connect(p)
+	if err := writePacket(nc, packet{typ: typeConnack, body: []byte{0, b.refuse}}); err != nil || b.refuse != 0 {
+		return
+	}
+
+	for {
+		p, err := readPacket(r)
+		if err != nil {
+			return
+		}
+
+		switch p.typ {
+		case typePublish:
+			m, id := parsePublish(p)
+			b.mu.Lock()
+			b.messages = append(b.messages, m)
+			b.mu.Unlock()
+
+			switch m.qos {
+			case 1:
+				writePacket(nc, idPacket(typePuback, id))
+			case 2:
+				writePacket(nc, idPacket(typePubrec, id))
+			}
+		case typePubrel:
+			id, _ := packetID(p)
+			writePacket(nc, idPacket(typePubcomp, id))
+		case typePingreq:
+			b.mu.Lock()
+			b.pings++
+			b.mu.Unlock()
+			writePacket(nc, packet{typ: typePingresp})
+		case typeDisconnect:
+			return
+		}
+	}
+}
+
+// connect records the CONNECT packet. The protocol name and level are skipped.
+func (b *broker) connect(p packet) {
+	flags := p.body[7]
+	fields := readStrings(p.body[10:])
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.connects++
+	b.username, b.password = "", ""
+	if flags&0x80 != 0 && len(fields) > 1 {
+		b.username = fields[1]
+	}
+	if flags&0x40 != 0 && len(fields) > 2 {
+		b.password = fields[2]
+	}
+}
+
+func readStrings(b []byte) []string {
+	var s []string
+	for len(b) >= 2 {
+		n := int(binary.BigEndian.Uint16(b))
+		s = append(s, string(b[2:2+n]))
+		b = b[2+n:]
+	}
+	return s
+}
+
+func parsePublish(p packet) (message, uint16) {
+	m := message{qos: (p.flags >> 1) & 0x03, retain: p.flags&0x01 != 0}
+	n := int(binary.BigEndian.Uint16(p.body))
+	m.topic = string(p.body[2 : 2+n])
+	rest := p.body[2+n:]
+
+	var id uint16
+	if m.qos > 0 {
+		id = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+	m.payload = string(rest)
+	return m, id
+}
+
+// waitFor polls cond until it is true or the timeout is reached.
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSink_Send(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		qos    byte
+		retain bool
+	}{
+		{desc: "qos 0", qos: 0},
+		{desc: "qos 1", qos: 1, retain: true},
+		{desc: "qos 2", qos: 2},
+	}
+
+	link := felix.Link{Title: "Title", URL: "http://example.com/file", Source: "http://example.org/page"}
+	item := felix.Item{Title: "Item", URL: "http://example.org/page"}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			b := newBroker(t)
+			defer b.close()
+
+			sink, err := New(Config{
+				Broker:    b.addr(),
+				Username:  "user",
+				Password:  "pass",
+				LinkTopic: "felix/links/{{host .URL}}",
+				QoS:       tC.qos,
+				Retain:    tC.retain,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sink.Close()
+
+			if err := sink.Send(context.Background(), link); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := sink.SendItem(context.Background(), item); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			waitFor(t, func() bool { return len(b.received()) == 2 })
+			messages := b.received()
+
+			expectedTopics := []string{"felix/links/example.com", DefaultItemTopic}
+			for i, m := range messages {
+				if m.topic != expectedTopics[i] {
+					t.Errorf("unexpected topic. expected %v, got %v", expectedTopics[i], m.topic)
+				}
+				if m.qos != tC.qos {
+					t.Errorf("unexpected qos. expected %v, got %v", tC.qos, m.qos)
+				}
+				if m.retain != tC.retain {
+					t.Errorf("unexpected retain flag. expected %v, got %v", tC.retain, m.retain)
+				}
+			}
+
+			var got felix.Link
+			if err := json.Unmarshal([]byte(messages[0].payload), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != link {
+				t.Errorf("unexpected link. expected %v, got %v", link, got)
+			}
+
+			b.mu.Lock()
+			username, password := b.username, b.password
+			b.mu.Unlock()
+
+			if username != "user" || password != "pass" {
+				t.Errorf("unexpected credentials. expected %v:%v, got %v:%v", "user", "pass", username, password)
+			}
+		})
+	}
+}
+
+func TestSink_SendInvalidTopic(t *testing.T) {
+	sink, err := New(Config{LinkTopic: "felix/{{.Title}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sink.Send(context.Background(), felix.Link{Title: "#"})
+	if !felix.IsPermanent(err) {
+		t.Errorf("unexpected error. expected permanent error, got %v", err)
+	}
+}
+
+func TestSink_SendItemDisabled(t *testing.T) {
+	sink, err := New(Config{Broker: "127.0.0.1:1", ItemTopic: "-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.SendItem(context.Background(), felix.Item{URL: "http://example.com"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSink_Reconnect(t *testing.T) {
+	b := newBroker(t)
+	defer b.close()
+
+	sink, err := New(Config{Broker: b.addr(), QoS: 1, MinBackoff: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	link := felix.Link{URL: "http://example.com/file"}
+	if err := sink.Send(context.Background(), link); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b.drop()
+
+	waitFor(t, func() bool {
+		return sink.Send(context.Background(), link) == nil
+	})
+
+	b.mu.Lock()
+	connects := b.connects
+	b.mu.Unlock()
+
+	if connects != 2 {
+		t.Errorf("unexpected number of connects. expected %v, got %v", 2, connects)
+	}
+}
+
+func TestSink_Backoff(t *testing.T) {
+	b := newBroker(t)
+	b.refuse = 5
+	defer b.close()
+
+	sink, err := New(Config{Broker: b.addr(), MinBackoff: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	link := felix.Link{URL: "http://example.com/file"}
+	if err := sink.Send(context.Background(), link); err == nil || !strings.Contains(err.Error(), "not authorized") {
+		t.Errorf("unexpected error. expected %v, got %v", "not authorized", err)
+	}
+	if err := sink.Send(context.Background(), link); err == nil || !strings.Contains(err.Error(), "next attempt") {
+		t.Errorf("unexpected error. expected %v, got %v", "next attempt", err)
+	}
+
+	b.mu.Lock()
+	connects := b.connects
+	b.mu.Unlock()
+
+	if connects != 1 {
+		t.Errorf("unexpected number of connects. expected %v, got %v", 1, connects)
+	}
+}
+
+func TestSink_KeepAlive(t *testing.T) {
+	b := newBroker(t)
+	defer b.close()
+
+	sink, err := New(Config{Broker: b.addr(), KeepAlive: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	if err := sink.Send(context.Background(), felix.Link{URL: "http://example.com/file"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	waitFor(t, func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.pings >= 2
+	})
+}
+
+func TestBrokerAddr(t *testing.T) {
+	testCases := []struct {
+		broker   string
+		expected string
+		err      bool
+	}{
+		{broker: "tcp://localhost:1883", expected: "localhost:1883"},
+		{broker: "mqtt://example.com", expected: "example.com:1883"},
+		{broker: "example.com:1884", expected: "example.com:1884"},
+		{broker: "ssl://example.com:8883", err: true},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.broker, func(t *testing.T) {
+			addr, err := brokerAddr(tC.broker)
+			if (err != nil) != tC.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if addr != tC.expected {
+				t.Errorf("unexpected address. expected %v, got %v", tC.expected, addr)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Control packet types of MQTT 3.1.1 as used by the sink.
+// See http://docs.oasis-open.org/mqtt/mqtt/v3.1.1/os/mqtt-v3.1.1-os.html#_Toc398718021
+const (
+	typeConnect    byte = 1
+	typeConnack    byte = 2
+	typePublish    byte = 3
+	typePuback     byte = 4
+	typePubrec     byte = 5
+	typePubrel     byte = 6
+	typePubcomp    byte = 7
+	typePingreq    byte = 12
+	typePingresp   byte = 13
+	typeDisconnect byte = 14
+)
+
+// maxRemainingLength is the maximum length of a packet after the fixed header.
+const maxRemainingLength = 268435455
+
+// packet is a raw MQTT control packet.
+type packet struct {
+	typ   byte
+	flags byte
+	body  []byte
+}
+
+// writePacket writes the packet with its fixed header to w.
+func writePacket(w io.Writer, p packet) error {
+	if len(p.body) > maxRemainingLength {
+		return errors.New("packet too large")
+	}
+
+	buf := []byte{p.typ<<4 | p.flags&0x0f}
+	n := len(p.body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if n == 0 {
+			break
+		}
+	}
+
+	_, err := w.Write(append(buf, p.body...))
+	return err
+}
+
+// readPacket reads a single packet from r.
+func readPacket(r *bufio.Reader) (packet, error) {
+	var p packet
+
+	h, err := r.ReadByte()
+	if err != nil {
+		return p, err
+	}
+	p.typ, p.flags = h>>4, h&0x0f
+
+	n, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return p, errors.New("malformed remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return p, err
+		}
+		n += int(b&0x7f) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+
+	p.body = make([]byte, n)
+	_, err = io.ReadFull(r, p.body)
+	return p, err
+}
+
+// appendString appends the length-prefixed UTF-8 string s.
+func appendString(b []byte, s string) []byte {
+	b = appendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// connectPacket returns a CONNECT packet with a clean session.
+func connectPacket(clientID, username, password string, keepAlive uint16) packet {
+	var flags byte = 0x02 // clean session
+	if username != "" {
+		flags |= 0x80
+	}
+	if password != "" {
+		flags |= 0x40
+	}
+
+	b := appendString(nil, "MQTT")
+	b = append(b, 4, flags) // protocol level 4 = 3.1.1
+	b = appendUint16(b, keepAlive)
+	b = appendString(b, clientID)
+	if username != "" {
+		b = appendString(b, username)
+	}
+	if password != "" {
+		b = appendString(b, password)
+	}
+
+	return packet{typ: typeConnect, body: b}
+}
+
+// publishPacket returns a PUBLISH packet. The packet id is only used for QoS 1 and 2.
+func publishPacket(topic string, payload []byte, qos byte, retain bool, id uint16) packet {
+	flags := qos << 1
+	if retain {
+		flags |= 0x01
+	}
+
+	b := appendString(nil, topic)
+	if qos > 0 {
+		b = appendUint16(b, id)
+	}
+
+	return packet{typ: typePublish, flags: flags, body: append(b, payload...)}
+}
+
+// idPacket returns a packet that only consists of a packet id, e.g. PUBACK or PUBREL.
+func idPacket(typ byte, id uint16) packet {
+	var flags byte
+	if typ == typePubrel {
+		flags = 0x02
+	}
+	return packet{typ: typ, flags: flags, body: appendUint16(nil, id)}
+}
+
+// packetID returns the packet id at the beginning of the body.
+func packetID(p packet) (uint16, error) {
+	if len(p.body) < 2 {
+		return 0, errors.New("missing packet id")
+	}
+	return binary.BigEndian.Uint16(p.body), nil
+}
+
+// connackError returns the error for the return code of a CONNACK packet, or nil if the connection was accepted.
+func connackError(code byte) error {
+	switch code {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("connection refused: unacceptable protocol version")
+	case 2:
+		return errors.New("connection refused: identifier rejected")
+	case 3:
+		return errors.New("connection refused: server unavailable")
+	case 4:
+		return errors.New("connection refused: bad user name or password")
+	case 5:
+		return errors.New("connection refused: not authorized")
+	}
+	return errors.Errorf("connection refused: return code %d", code)
+}
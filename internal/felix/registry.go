@@ -0,0 +1,70 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"sort"
+	"sync"
+)
+
+// FetcherRegistry keeps track of all running fetchers.
+type FetcherRegistry struct {
+	mu       sync.RWMutex
+	fetchers map[string]*Fetcher
+}
+
+// NewFetcherRegistry creates a new, empty FetcherRegistry.
+func NewFetcherRegistry() *FetcherRegistry {
+	return &FetcherRegistry{
+		fetchers: make(map[string]*Fetcher),
+	}
+}
+
+// Run registers the fetcher, starts it and blocks until it stops. The fetcher is unregistered afterwards.
+func (r *FetcherRegistry) Run(f *Fetcher, quit <-chan struct{}) {
+	r.mu.Lock()
+	r.fetchers[f.URL()] = f
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		// Only remove the entry if it was not replaced by another fetcher for the same URL
+		if r.fetchers[f.URL()] == f {
+			delete(r.fetchers, f.URL())
+		}
+		r.mu.Unlock()
+	}()
+
+	f.Start(quit)
+}
+
+// Get returns the running fetcher for the given URL, if any.
+func (r *FetcherRegistry) Get(url string) (*Fetcher, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	f, ok := r.fetchers[url]
+	return f, ok
+}
+
+// Fetchers returns all running fetchers, sorted by URL.
+func (r *FetcherRegistry) Fetchers() []*Fetcher {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	fetchers := make([]*Fetcher, 0, len(r.fetchers))
+	for _, f := range r.fetchers {
+		fetchers = append(fetchers, f)
+	}
+
+	sort.Slice(fetchers, func(i, j int) bool { return fetchers[i].URL() < fetchers[j].URL() })
+	return fetchers
+}
+
+// Len returns the number of running fetchers.
+func (r *FetcherRegistry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.fetchers)
+}
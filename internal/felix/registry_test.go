@@ -0,0 +1,81 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFetcherRegistry(t *testing.T) {
+	registry := NewFetcherRegistry()
+
+	stopB := startRegisteredFetcher(t, registry, "http://example.com/b")
+	stopA := startRegisteredFetcher(t, registry, "http://example.com/a")
+
+	fetchers := registry.Fetchers()
+	if len(fetchers) != 2 || fetchers[0].URL() != "http://example.com/a" || fetchers[1].URL() != "http://example.com/b" {
+		t.Fatalf("expected registered fetchers sorted by URL, got %v", fetchers)
+	}
+
+	f, ok := registry.Get("http://example.com/a")
+	if !ok {
+		t.Fatal("could not get registered fetcher")
+	}
+
+	if next := f.NextAttempt(); next.Before(time.Now()) {
+		t.Errorf("expected next attempt in the future, got %v", next)
+	}
+
+	stopA()
+
+	if _, ok := registry.Get("http://example.com/a"); ok || registry.Len() != 1 {
+		t.Error("stopped fetcher should be unregistered")
+	}
+
+	stopB()
+
+	if registry.Len() != 0 {
+		t.Errorf("expected empty registry, got %d fetchers", registry.Len())
+	}
+}
+
+// startRegisteredFetcher starts a waiting fetcher for url in the registry and returns a function to stop it.
+func startRegisteredFetcher(t *testing.T, registry *FetcherRegistry, url string) func() {
+	t.Helper()
+
+	f := NewFetcher(url, nil, nil, waitAttempter(1*time.Hour), nil, nil)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		registry.Run(f, quit)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(1 * time.Second)
+	for f.NextAttempt().IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatal("fetcher did not start")
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+
+	return func() {
+		close(quit)
+		<-done
+	}
+}
+
+// waitAttempter always schedules the next attempt after the given duration.
+type waitAttempter time.Duration
+
+func (a waitAttempter) Next(key string) (bool, time.Duration, error) {
+	return true, time.Duration(a), nil
+}
+
+func (waitAttempter) Inc(key string) error {
+	return nil
+}
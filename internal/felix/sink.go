@@ -0,0 +1,384 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// sinkBuffer is the number of links buffered per sink before they are queued right away.
+const sinkBuffer = 64
+
+// LinkSink delivers newly stored links to an external system, e.g. a webhook or a download manager.
+type LinkSink interface {
+	// Send delivers the link. Links that could not be delivered are queued and retried later,
+	// unless the returned error is a PermanentError.
+	Send(ctx context.Context, link Link) error
+}
+
+// LinkSinkFunc is an adapter to allow the use of ordinary functions as sinks.
+type LinkSinkFunc func(ctx context.Context, link Link) error
+
+// Send calls the underlying LinkSinkFunc.
+func (f LinkSinkFunc) Send(ctx context.Context, link Link) error {
+	return f(ctx, link)
+}
+
+// ConcurrentSink is an optional interface for sinks that can deliver multiple links at once.
+// Concurrency returns the maximum number of concurrent calls to Send.
+type ConcurrentSink interface {
+	LinkSink
+	Concurrency() int
+}
+
+// ItemSink is an optional interface for sinks that are also notified of newly stored items.
+// Items are delivered on a best effort basis, they are not queued if the delivery fails.
+type ItemSink interface {
+	SendItem(ctx context.Context, item Item) error
+}
+
+// PermanentError is returned by a LinkSink if retrying the delivery is pointless,
+// e.g. because the link was rejected by the receiver.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Permanent marks err as a PermanentError.
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether the cause of err is a PermanentError.
+func IsPermanent(err error) bool {
+	_, ok := errors.Cause(err).(*PermanentError)
+	return ok
+}
+
+// SinkQueue persists links whose delivery to a sink failed, so they can be retried later (even after a restart).
+type SinkQueue interface {
+	// QueueLink adds the link to the queue of the given sink, replacing a queued link with the same URL.
+	QueueLink(sink string, link Link) error
+	// QueuedLinks returns all queued links of the given sink, oldest first.
+	QueuedLinks(sink string) ([]Link, error)
+	// DequeueLink removes the link with the given URL from the queue of the given sink.
+	DequeueLink(sink string, url string) error
+}
+
+// SinkIDStore stores the IDs that external systems assigned to delivered links (e.g. download IDs),
+// so sinks can recognize links that were already delivered.
+type SinkIDStore interface {
+	// StoreSinkID stores the ID of the link with the given URL for the given sink.
+	StoreSinkID(sink, url, id string) error
+	// SinkID returns the stored ID of the link with the given URL for the given sink or ErrNotFound.
+	SinkID(sink, url string) (string, error)
+}
+
+// SinkDispatcher passes newly stored links to all registered sinks.
+// Every sink is served by its own goroutines, so a slow sink does not hold up the others.
+// Links that could not be delivered are stored in the SinkQueue and retried periodically.
+type SinkDispatcher struct {
+	queue    SinkQueue
+	interval time.Duration
+	log      Logger
+	sinks    []*sinkWorker
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex // guards closed and the item channels
+	closed bool
+}
+
+type sinkWorker struct {
+	name  string
+	sink  LinkSink
+	links chan Link
+	items chan Item // nil if the sink is no ItemSink
+}
+
+// NewSinkDispatcher creates a new SinkDispatcher, which retries queued links in the given interval.
+func NewSinkDispatcher(queue SinkQueue, retryInterval time.Duration) *SinkDispatcher {
+	if retryInterval <= 0 {
+		retryInterval = DefaultSinkRetryInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &SinkDispatcher{
+		queue:    queue,
+		interval: retryInterval,
+		log:      &NopLogger{},
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// SetLogger sets the logger that is used by the dispatcher.
+func (d *SinkDispatcher) SetLogger(log Logger) {
+	d.log = log
+}
+
+// Add registers the sink with the given (unique) name. The name identifies the queue of the sink.
+// All sinks must be added before the dispatcher is started.
+func (d *SinkDispatcher) Add(name string, sink LinkSink) {
+	w := &sinkWorker{
+		name:  name,
+		sink:  sink,
+		links: make(chan Link, sinkBuffer),
+	}
+	if _, ok := sink.(ItemSink); ok {
+		w.items = make(chan Item, sinkBuffer)
+	}
+	d.sinks = append(d.sinks, w)
+}
+
+// Len returns the number of registered sinks.
+func (d *SinkDispatcher) Len() int {
+	return len(d.sinks)
+}
+
+// Start starts delivering links to the registered sinks. Queued links are retried right away.
+func (d *SinkDispatcher) Start() {
+	for _, w := range d.sinks {
+		n := 1
+		if cs, ok := w.sink.(ConcurrentSink); ok && cs.Concurrency() > 1 {
+			n = cs.Concurrency()
+		}
+
+		for i := 0; i < n; i++ {
+			d.wg.Add(1)
+			// Only a single goroutine retries queued links, so they are not delivered twice
+			go func(w *sinkWorker, retry bool) {
+				d.run(w, retry)
+				d.wg.Done()
+			}(w, i == 0)
+		}
+	}
+}
+
+// Send passes the link to all registered sinks. It does not block.
+// If the buffer of a sink is full, the link is queued instead.
+func (d *SinkDispatcher) Send(link Link) {
+	for _, w := range d.sinks {
+		select {
+		case w.links <- link:
+		default:
+			d.enqueue(w, link)
+		}
+	}
+}
+
+// SendItem passes the item to all registered sinks that implement ItemSink. It does not block.
+// If the buffer of a sink is full or the dispatcher is already closed, the item is dropped.
+func (d *SinkDispatcher) SendItem(item Item) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return
+	}
+
+	for _, w := range d.sinks {
+		if w.items == nil {
+			continue
+		}
+		select {
+		case w.items <- item:
+		default:
+			d.log.Warn("sink buffer full, dropping item", "sink", w.name, "url", item.URL)
+		}
+	}
+}
+
+// Close cancels all running deliveries and waits for the sinks to finish.
+// Links that were not delivered yet are queued. Sinks that implement io.Closer are closed afterwards,
+// e.g. to flush buffered links. Send must not be called after Close.
+func (d *SinkDispatcher) Close() {
+	d.cancel()
+	d.shutdown()
+}
+
+// Drain waits until all links that were passed to Send are delivered (or queued after a failed delivery)
+// and closes the sinks like Close. Send must not be called after Drain.
+func (d *SinkDispatcher) Drain() {
+	d.shutdown()
+	d.cancel()
+}
+
+// shutdown closes the link channels, waits for the workers to finish and closes the sinks.
+func (d *SinkDispatcher) shutdown() {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+
+	for _, w := range d.sinks {
+		close(w.links)
+		if w.items != nil {
+			close(w.items)
+		}
+	}
+	d.wg.Wait()
+
+	for _, w := range d.sinks {
+		if c, ok := w.sink.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				d.log.Error("could not close sink", "err", err, "sink", w.name)
+			}
+		}
+	}
+}
+
+func (d *SinkDispatcher) run(w *sinkWorker, retry bool) {
+	items := w.items
+	var tick <-chan time.Time
+	if retry {
+		d.retry(w)
+
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case link, ok := <-w.links:
+			if !ok {
+				d.drainItems(w, items)
+				return
+			}
+			if d.ctx.Err() != nil {
+				// Shutting down, keep the link for later
+				d.enqueue(w, link)
+				continue
+			}
+			d.deliver(w, link)
+		case item, ok := <-items:
+			if !ok {
+				items = nil
+				continue
+			}
+			if d.ctx.Err() != nil {
+				continue
+			}
+			d.deliverItem(w, item)
+		case <-tick:
+			d.retry(w)
+		}
+	}
+}
+
+// deliver sends the link to the sink and queues it on failure.
+func (d *SinkDispatcher) deliver(w *sinkWorker, link Link) {
+	start := time.Now()
+	err := w.sink.Send(d.ctx, link)
+	observeSink(w.name, start, err)
+
+	if err == nil {
+		d.log.Info("delivered link", "sink", w.name, "url", link.URL)
+		return
+	}
+
+	if IsPermanent(err) {
+		d.log.Error("could not deliver link, dropping it", "err", err, "sink", w.name, "url", link.URL)
+		return
+	}
+
+	d.log.Error("could not deliver link, queueing it", "err", err, "sink", w.name, "url", link.URL)
+	d.enqueue(w, link)
+}
+
+// drainItems delivers the remaining items after the dispatcher was drained. They are dropped after Close.
+func (d *SinkDispatcher) drainItems(w *sinkWorker, items <-chan Item) {
+	if items == nil {
+		return
+	}
+	for item := range items {
+		if d.ctx.Err() == nil {
+			d.deliverItem(w, item)
+		}
+	}
+}
+
+// deliverItem sends the item to the sink. Failed deliveries are only logged.
+func (d *SinkDispatcher) deliverItem(w *sinkWorker, item Item) {
+	start := time.Now()
+	err := w.sink.(ItemSink).SendItem(d.ctx, item)
+	observeSink(w.name, start, err)
+
+	if err != nil {
+		d.log.Error("could not deliver item", "err", err, "sink", w.name, "url", item.URL)
+		return
+	}
+
+	d.log.Info("delivered item", "sink", w.name, "url", item.URL)
+}
+
+// retry sends all queued links of the sink, oldest first. It stops at the first failed delivery,
+// since the receiver is most likely still unavailable.
+func (d *SinkDispatcher) retry(w *sinkWorker) {
+	links, err := d.queue.QueuedLinks(w.name)
+	if err != nil {
+		d.log.Error("could not get queued links", "err", err, "sink", w.name)
+		return
+	}
+
+	for _, link := range links {
+		if d.ctx.Err() != nil {
+			return
+		}
+
+		start := time.Now()
+		err := w.sink.Send(d.ctx, link)
+		observeSink(w.name, start, err)
+
+		if err != nil && !IsPermanent(err) {
+			d.log.Error("could not deliver queued link", "err", err, "sink", w.name, "url", link.URL, "queued", len(links))
+			return
+		}
+
+		if err != nil {
+			d.log.Error("could not deliver queued link, dropping it", "err", err, "sink", w.name, "url", link.URL)
+		} else {
+			d.log.Info("delivered queued link", "sink", w.name, "url", link.URL)
+		}
+
+		if err := d.queue.DequeueLink(w.name, link.URL); err != nil {
+			d.log.Error("could not dequeue link", "err", err, "sink", w.name, "url", link.URL)
+			return
+		}
+	}
+}
+
+func (d *SinkDispatcher) enqueue(w *sinkWorker, link Link) {
+	if err := d.queue.QueueLink(w.name, link); err != nil {
+		d.log.Error("could not queue link", "err", err, "sink", w.name, "url", link.URL)
+	}
+}
+
+// SinkTemplate parses a text template for sink payloads (e.g. a request body or command argument).
+// The template is executed with the Link and provides these additional functions:
+//
+//	host  returns the host of the given URL
+//	json  returns the given value encoded as JSON
+func SinkTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(template.FuncMap{
+		"host": LinkHost,
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+}
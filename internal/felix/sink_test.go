@@ -0,0 +1,268 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSinkDispatcher(t *testing.T) {
+	queue := &memQueue{}
+	delivered := make(chan Link, 10)
+
+	var mu sync.Mutex
+	fail := true
+	sink := LinkSinkFunc(func(ctx context.Context, link Link) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if link.URL == "rejected" {
+			return Permanent(errors.New("rejected"))
+		}
+		if fail {
+			return errors.New("unavailable")
+		}
+		delivered <- link
+		return nil
+	})
+
+	d := NewSinkDispatcher(queue, 10*time.Millisecond)
+	d.Add("test", sink)
+	d.Start()
+
+	d.Send(Link{URL: "link1"})
+	d.Send(Link{URL: "rejected"})
+
+	waitFor(t, func() bool { return len(queue.links("test")) == 1 })
+
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+
+	select {
+	case link := <-delivered:
+		if link.URL != "link1" {
+			t.Errorf("unexpected delivered link. expected %v, got %v", "link1", link.URL)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("queued link was not retried")
+	}
+
+	waitFor(t, func() bool { return len(queue.links("test")) == 0 })
+
+	d.Send(Link{URL: "link2"})
+	<-delivered
+	d.Close()
+
+	if len(queue.links("test")) != 0 {
+		t.Errorf("unexpected queued links: %v", queue.links("test"))
+	}
+}
+
+func TestSinkDispatcher_Close(t *testing.T) {
+	queue := &memQueue{}
+	block := make(chan struct{})
+	sink := LinkSinkFunc(func(ctx context.Context, link Link) error {
+		select {
+		case <-block:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	d := NewSinkDispatcher(queue, 1*time.Hour)
+	d.Add("test", sink)
+	d.Start()
+
+	d.Send(Link{URL: "link1"})
+	d.Send(Link{URL: "link2"})
+	d.Close()
+
+	if links := queue.links("test"); len(links) != 2 {
+		t.Errorf("undelivered links were not queued on close, got %v", links)
+	}
+}
+
+func TestSinkDispatcher_Drain(t *testing.T) {
+	queue := &memQueue{}
+	var mu sync.Mutex
+	var delivered []string
+	sink := LinkSinkFunc(func(ctx context.Context, link Link) error {
+		time.Sleep(5 * time.Millisecond)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		mu.Lock()
+		delivered = append(delivered, link.URL)
+		mu.Unlock()
+		return nil
+	})
+
+	d := NewSinkDispatcher(queue, 1*time.Hour)
+	d.Add("test", sink)
+	d.Start()
+
+	d.Send(Link{URL: "link1"})
+	d.Send(Link{URL: "link2"})
+	d.Drain()
+
+	if len(delivered) != 2 {
+		t.Errorf("unexpected number of delivered links. expected %v, got %v", 2, len(delivered))
+	}
+	if len(queue.links("test")) != 0 {
+		t.Errorf("unexpected queued links: %v", queue.links("test"))
+	}
+}
+
+func TestSinkDispatcher_Concurrency(t *testing.T) {
+	running := make(chan struct{}, 2)
+	release := make(chan struct{})
+	sink := concurrentSink{LinkSinkFunc(func(ctx context.Context, link Link) error {
+		running <- struct{}{}
+		<-release
+		return nil
+	}), 2}
+
+	d := NewSinkDispatcher(&memQueue{}, 1*time.Hour)
+	d.Add("test", sink)
+	d.Start()
+
+	d.Send(Link{URL: "link1"})
+	d.Send(Link{URL: "link2"})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-running:
+		case <-time.After(1 * time.Second):
+			t.Fatal("links were not delivered concurrently")
+		}
+	}
+
+	close(release)
+	d.Close()
+}
+
+type concurrentSink struct {
+	LinkSink
+	n int
+}
+
+func (s concurrentSink) Concurrency() int {
+	return s.n
+}
+
+func TestSinkDispatcher_SendItem(t *testing.T) {
+	items := make(chan Item, 1)
+	sink := itemSink{LinkSinkFunc(func(ctx context.Context, link Link) error { return nil }), items}
+
+	d := NewSinkDispatcher(&memQueue{}, 1*time.Hour)
+	d.Add("items", sink)
+	d.Add("links", LinkSinkFunc(func(ctx context.Context, link Link) error { return nil }))
+	d.Start()
+
+	d.SendItem(Item{URL: "item1"})
+
+	select {
+	case item := <-items:
+		if item.URL != "item1" {
+			t.Errorf("unexpected delivered item. expected %v, got %v", "item1", item.URL)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("item was not delivered")
+	}
+
+	d.Close()
+	// Items are dropped after Close
+	d.SendItem(Item{URL: "item2"})
+}
+
+type itemSink struct {
+	LinkSink
+	items chan Item
+}
+
+func (s itemSink) SendItem(ctx context.Context, item Item) error {
+	s.items <- item
+	return nil
+}
+
+func TestSinkTemplate(t *testing.T) {
+	tmpl, err := SinkTemplate("test", `{{.Title}}|{{host .URL}}|{{json .Title}}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, Link{Title: `a "b"`, URL: "http://example.com:8080/file"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `a "b"|example.com|"a \"b\""`
+	if b.String() != expected {
+		t.Errorf("unexpected output. expected %v, got %v", expected, b.String())
+	}
+}
+
+// memQueue is an in-memory SinkQueue.
+type memQueue struct {
+	mu    sync.Mutex
+	queue map[string][]Link
+}
+
+func (q *memQueue) links(sink string) []Link {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]Link(nil), q.queue[sink]...)
+}
+
+func (q *memQueue) QueueLink(sink string, link Link) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.queue == nil {
+		q.queue = make(map[string][]Link)
+	}
+	for i, l := range q.queue[sink] {
+		if l.URL == link.URL {
+			q.queue[sink][i] = link
+			return nil
+		}
+	}
+	q.queue[sink] = append(q.queue[sink], link)
+	return nil
+}
+
+func (q *memQueue) QueuedLinks(sink string) ([]Link, error) {
+	return q.links(sink), nil
+}
+
+func (q *memQueue) DequeueLink(sink string, url string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	links := q.queue[sink][:0]
+	for _, l := range q.queue[sink] {
+		if l.URL != url {
+			links = append(links, l)
+		}
+	}
+	q.queue[sink] = links
+	return nil
+}
+
+// waitFor waits up to one second for cond to become true.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(1 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(1 * time.Millisecond)
+	}
+}
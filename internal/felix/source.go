@@ -7,9 +7,11 @@ package felix
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"golang.org/x/net/html/charset"
 
@@ -41,7 +43,16 @@ type httpSource struct {
 
 var _ Source = new(httpSource)
 
-func (s *httpSource) Get(ctx context.Context, url string) (io.Reader, error) {
+func (s *httpSource) Get(ctx context.Context, url string) (r io.Reader, err error) {
+	start := time.Now()
+	var (
+		code int
+		n    int64
+	)
+	defer func() {
+		observeSource(start, code, n, err)
+	}()
+
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 
 	if err != nil {
@@ -55,23 +66,64 @@ func (s *httpSource) Get(ctx context.Context, url string) (io.Reader, error) {
 	}
 
 	defer resp.Body.Close()
+	code = resp.StatusCode
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("http request unsuccessful")
+		return nil, &StatusError{Code: resp.StatusCode}
 	}
 
 	contentType := resp.Header.Get("Content-Type")
-	r, err := charset.NewReader(resp.Body, contentType)
+	cr, err := charset.NewReader(resp.Body, contentType)
 
 	if err != nil {
 		return nil, errors.Wrap(err, "could not create UTF-8 charset reader")
 	}
 
-	b, err := ioutil.ReadAll(r)
+	b, err := ioutil.ReadAll(cr)
+	n = int64(len(b))
 
 	if err != nil {
 		return nil, errors.Wrap(err, "could not read response body")
 	}
 
-	return bytes.NewReader(b), nil
+	return &statusReader{Reader: bytes.NewReader(b), code: resp.StatusCode}, nil
+}
+
+// StatusError is returned by the HTTP Source for unsuccessful requests.
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("http request unsuccessful: %d %s", e.Code, http.StatusText(e.Code))
+}
+
+// StatusCode returns the HTTP status code of the response.
+func (e *StatusError) StatusCode() int {
+	return e.Code
+}
+
+// statusReader is the response body of a successful HTTP request.
+type statusReader struct {
+	*bytes.Reader
+	code int
+}
+
+// StatusCode returns the HTTP status code of the response.
+func (r *statusReader) StatusCode() int {
+	return r.code
+}
+
+// statusCode returns the HTTP status code of the given response body or error,
+// if available. Otherwise it returns 0.
+func statusCode(v interface{}) int {
+	if err, ok := v.(error); ok {
+		v = errors.Cause(err)
+	}
+	if sc, ok := v.(interface {
+		StatusCode() int
+	}); ok {
+		return sc.StatusCode()
+	}
+	return 0
 }
@@ -61,3 +61,29 @@ func TestSource(t *testing.T) {
 	}
 
 }
+
+func TestSource_StatusCode(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	defer ts.Close()
+
+	source := NewHTTPSource(nil)
+
+	r, err := source.Get(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+	if code := statusCode(r); code != http.StatusOK {
+		t.Errorf("unexpected status code of response. expected %d, got %d", http.StatusOK, code)
+	}
+
+	_, err = source.Get(context.Background(), ts.URL+"/missing")
+	if code := statusCode(err); code != http.StatusNotFound {
+		t.Errorf("unexpected status code of error. expected %d, got %d", http.StatusNotFound, code)
+	}
+}
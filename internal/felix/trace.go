@@ -0,0 +1,423 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FilterDecision is what a filter did with an entry, see TraceStep.
+type FilterDecision string
+
+// Possible filter decisions.
+const (
+	// FilterAccepted means the entry was passed to the next filter unchanged.
+	FilterAccepted FilterDecision = "accepted"
+	// FilterModified means the entry was changed, expanded into several entries or partly dropped.
+	FilterModified FilterDecision = "modified"
+	// FilterDropped means nothing was passed to the next filter.
+	FilterDropped FilterDecision = "dropped"
+)
+
+// defaultDropReason is used for filters that do not implement ItemExplainer or LinkExplainer.
+const defaultDropReason = "not passed to the next filter"
+
+// TraceStep is the decision of a single filter of the chain about an entry.
+type TraceStep struct {
+	// Position is the position of the filter in the chain, starting at 1.
+	Position int            `json:"position"`
+	Filter   string         `json:"filter"`
+	Decision FilterDecision `json:"decision"`
+	Reason   string         `json:"reason,omitempty"`
+}
+
+func (s TraceStep) String() string {
+	if s.Reason == "" {
+		return fmt.Sprintf("#%d %s: %s", s.Position, s.Filter, s.Decision)
+	}
+	return fmt.Sprintf("#%d %s: %s (%s)", s.Position, s.Filter, s.Decision, s.Reason)
+}
+
+// ItemTrace is the path of a single item through an item filter chain, see TraceItems.
+type ItemTrace struct {
+	Item Item `json:"item"`
+	// Output contains the items that passed the whole chain.
+	Output []Item `json:"output,omitempty"`
+	// Steps contains the decisions of all filters the item (or the items derived from it) reached.
+	Steps []TraceStep `json:"steps"`
+	// DroppedBy is the name of the filter that dropped the item (see Namer), if it did not pass the chain.
+	DroppedBy string `json:"droppedBy,omitempty"`
+	// DroppedAt is the position of the dropping filter in the chain, starting at 1.
+	DroppedAt int `json:"droppedAt,omitempty"`
+}
+
+// Dropped reports whether the item did not pass the chain.
+func (t ItemTrace) Dropped() bool {
+	return t.DroppedAt > 0
+}
+
+// TraceItems passes the items one by one through the filter chain, like FilterItems,
+// and records for every item what each filter did with it.
+func TraceItems(items []Item, filters ...ItemFilter) []ItemTrace {
+	rec := &itemRecorder{}
+	chain := buildItemFilterChain(rec.wrap(filters)...)
+
+	traces := make([]ItemTrace, 0, len(items))
+	for _, item := range items {
+		var output []Item
+		rec.start(len(filters))
+		chain.Filter(item, func(item Item) {
+			output = append(output, item)
+		})
+		traces = append(traces, rec.stop(item, output, filters))
+	}
+
+	return traces
+}
+
+// itemCall is a single call of an item filter with the items it passed to the next filter.
+type itemCall struct {
+	in  Item
+	out []Item
+}
+
+// itemRecorder records the calls of every filter of a chain while recording is started.
+type itemRecorder struct {
+	calls [][]itemCall
+}
+
+// wrap wraps the filters to record their calls.
+func (r *itemRecorder) wrap(filters []ItemFilter) []ItemFilter {
+	wrapped := make([]ItemFilter, len(filters))
+	for i, f := range filters {
+		i, f := i, f
+		wrapped[i] = ItemFilterFunc(func(item Item, next func(Item)) {
+			if r.calls == nil {
+				f.Filter(item, next)
+				return
+			}
+			c := len(r.calls[i])
+			r.calls[i] = append(r.calls[i], itemCall{in: item})
+			f.Filter(item, func(item Item) {
+				r.calls[i][c].out = append(r.calls[i][c].out, item)
+				next(item)
+			})
+		})
+	}
+	return wrapped
+}
+
+func (r *itemRecorder) start(n int) {
+	r.calls = make([][]itemCall, n)
+}
+
+// stop stops recording and returns the trace of the recorded calls.
+func (r *itemRecorder) stop(item Item, output []Item, filters []ItemFilter) ItemTrace {
+	t := ItemTrace{Item: item, Output: output}
+
+	for i, calls := range r.calls {
+		if len(calls) == 0 {
+			break
+		}
+
+		step := TraceStep{Position: i + 1, Filter: filterName(filters[i]), Decision: FilterAccepted}
+		var dropped []Item
+		var changes []string
+		for _, c := range calls {
+			switch {
+			case len(c.out) == 0:
+				dropped = append(dropped, c.in)
+			case len(c.out) > 1:
+				changes = append(changes, fmt.Sprintf("expanded into %d items", len(c.out)))
+			case !reflect.DeepEqual(c.in, c.out[0]):
+				changes = append(changes, itemChanges(c.in, c.out[0]))
+			}
+		}
+
+		switch {
+		case len(dropped) == len(calls):
+			step.Decision, step.Reason = FilterDropped, explainItem(filters[i], dropped[0])
+		case len(dropped) > 0:
+			reason := fmt.Sprintf("dropped %d of %d items: %s", len(dropped), len(calls), explainItem(filters[i], dropped[0]))
+			step.Decision, step.Reason = FilterModified, strings.Join(append(changes, reason), "; ")
+		case len(changes) > 0:
+			step.Decision, step.Reason = FilterModified, strings.Join(changes, "; ")
+		}
+		t.Steps = append(t.Steps, step)
+	}
+
+	if len(output) == 0 && len(t.Steps) > 0 {
+		last := t.Steps[len(t.Steps)-1]
+		t.DroppedBy, t.DroppedAt = last.Filter, last.Position
+	}
+
+	r.calls = nil
+	return t
+}
+
+func explainItem(f ItemFilter, item Item) string {
+	if e, ok := f.(ItemExplainer); ok {
+		if reason := e.ExplainItem(item); reason != "" {
+			return reason
+		}
+	}
+	return defaultDropReason
+}
+
+// itemChanges describes the differences between the input and the output item of a filter.
+func itemChanges(in, out Item) string {
+	var changes []string
+	if in.Title != out.Title {
+		changes = append(changes, fmt.Sprintf("title %q -> %q", in.Title, out.Title))
+	}
+	if in.URL != out.URL {
+		changes = append(changes, fmt.Sprintf("url %q -> %q", in.URL, out.URL))
+	}
+	if !in.PubDate.Equal(out.PubDate) {
+		changes = append(changes, fmt.Sprintf("pubDate %v -> %v", in.PubDate, out.PubDate))
+	}
+	if len(changes) == 0 {
+		return "changed"
+	}
+	return strings.Join(changes, ", ")
+}
+
+// LinkTrace is the path of a single link through a link filter chain, see TraceLinks.
+type LinkTrace struct {
+	Link Link `json:"link"`
+	// Output contains the links that passed the whole chain, which may differ from the input,
+	// e.g. with a new title or expanded into several links.
+	Output []Link `json:"output,omitempty"`
+	// Steps contains the decisions of all filters the link (or the links derived from it) reached.
+	Steps []TraceStep `json:"steps"`
+	// DroppedBy is the name of the filter that dropped the link (see Namer), if it did not pass the chain.
+	DroppedBy string `json:"droppedBy,omitempty"`
+	// DroppedAt is the position of the dropping filter in the chain, starting at 1.
+	DroppedAt int `json:"droppedAt,omitempty"`
+}
+
+// Dropped reports whether the link (or all links derived from it) did not pass the chain.
+func (t LinkTrace) Dropped() bool {
+	return t.DroppedAt > 0
+}
+
+// TraceLinks passes the links one by one through the filter chain, like FilterLinks,
+// and records for every link what each filter did with it.
+func TraceLinks(links []Link, filters ...LinkFilter) []LinkTrace {
+	rec := &linkRecorder{}
+	chain := buildLinkFilterChain(rec.wrap(filters)...)
+
+	traces := make([]LinkTrace, 0, len(links))
+	for _, link := range links {
+		var output []Link
+		rec.start(len(filters))
+		chain.Filter(link, func(link Link) {
+			output = append(output, link)
+		})
+		traces = append(traces, rec.stop(link, output, filters))
+	}
+
+	return traces
+}
+
+// linkCall is a single call of a link filter with the links it passed to the next filter.
+type linkCall struct {
+	in  Link
+	out []Link
+}
+
+// linkRecorder records the calls of every filter of a chain while recording is started.
+type linkRecorder struct {
+	calls [][]linkCall
+}
+
+// wrap wraps the filters to record their calls.
+func (r *linkRecorder) wrap(filters []LinkFilter) []LinkFilter {
+	wrapped := make([]LinkFilter, len(filters))
+	for i, f := range filters {
+		i, f := i, f
+		wrapped[i] = LinkFilterFunc(func(link Link, next func(Link)) {
+			if r.calls == nil {
+				f.Filter(link, next)
+				return
+			}
+			c := len(r.calls[i])
+			r.calls[i] = append(r.calls[i], linkCall{in: link})
+			f.Filter(link, func(link Link) {
+				r.calls[i][c].out = append(r.calls[i][c].out, link)
+				next(link)
+			})
+		})
+	}
+	return wrapped
+}
+
+func (r *linkRecorder) start(n int) {
+	r.calls = make([][]linkCall, n)
+}
+
+// stop stops recording and returns the trace of the recorded calls.
+func (r *linkRecorder) stop(link Link, output []Link, filters []LinkFilter) LinkTrace {
+	t := LinkTrace{Link: link, Output: output}
+
+	for i, calls := range r.calls {
+		if len(calls) == 0 {
+			break
+		}
+
+		step := TraceStep{Position: i + 1, Filter: filterName(filters[i]), Decision: FilterAccepted}
+		var dropped []Link
+		var changes []string
+		for _, c := range calls {
+			switch {
+			case len(c.out) == 0:
+				dropped = append(dropped, c.in)
+			case len(c.out) > 1:
+				changes = append(changes, fmt.Sprintf("expanded into %d links", len(c.out)))
+			case !reflect.DeepEqual(c.in, c.out[0]):
+				changes = append(changes, linkChanges(c.in, c.out[0]))
+			}
+		}
+
+		switch {
+		case len(dropped) == len(calls):
+			step.Decision, step.Reason = FilterDropped, explainLink(filters[i], dropped[0])
+		case len(dropped) > 0:
+			reason := fmt.Sprintf("dropped %d of %d links: %s", len(dropped), len(calls), explainLink(filters[i], dropped[0]))
+			step.Decision, step.Reason = FilterModified, strings.Join(append(changes, reason), "; ")
+		case len(changes) > 0:
+			step.Decision, step.Reason = FilterModified, strings.Join(changes, "; ")
+		}
+		t.Steps = append(t.Steps, step)
+	}
+
+	if len(output) == 0 && len(t.Steps) > 0 {
+		last := t.Steps[len(t.Steps)-1]
+		t.DroppedBy, t.DroppedAt = last.Filter, last.Position
+	}
+
+	r.calls = nil
+	return t
+}
+
+func explainLink(f LinkFilter, link Link) string {
+	if e, ok := f.(LinkExplainer); ok {
+		if reason := e.ExplainLink(link); reason != "" {
+			return reason
+		}
+	}
+	return defaultDropReason
+}
+
+// linkChanges describes the differences between the input and the output link of a filter.
+func linkChanges(in, out Link) string {
+	var changes []string
+	if in.Title != out.Title {
+		changes = append(changes, fmt.Sprintf("title %q -> %q", in.Title, out.Title))
+	}
+	if in.URL != out.URL {
+		changes = append(changes, fmt.Sprintf("url %q -> %q", in.URL, out.URL))
+	}
+	if in.Source != out.Source {
+		changes = append(changes, fmt.Sprintf("source %q -> %q", in.Source, out.Source))
+	}
+	if len(changes) == 0 {
+		return "changed"
+	}
+	return strings.Join(changes, ", ")
+}
+
+// FilterTracer selects the entries that are traced by FilterItemsTraced and FilterLinksTraced
+// and receives their traces.
+type FilterTracer interface {
+	// Sample reports whether the next entry should be traced.
+	Sample() bool
+	TraceItem(t ItemTrace)
+	TraceLink(t LinkTrace)
+}
+
+// TraceLog is a FilterTracer that writes the traces of a random sample of all entries
+// as JSON Lines to a writer or, without a writer, to the Logger.
+type TraceLog struct {
+	rate float64
+	w    io.Writer
+	log  Logger
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewTraceLog creates a new TraceLog that traces the given fraction (0 to 1) of all entries.
+// If w is nil, the traces are logged instead.
+func NewTraceLog(rate float64, w io.Writer) *TraceLog {
+	return &TraceLog{
+		rate: rate,
+		w:    w,
+		log:  &NopLogger{},
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// SetLogger sets the logger for the traces (without a writer) and write errors.
+func (l *TraceLog) SetLogger(log Logger) {
+	l.log = log
+}
+
+// Sample implements FilterTracer.
+func (l *TraceLog) Sample() bool {
+	if l.rate >= 1 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rand.Float64() < l.rate
+}
+
+// TraceItem implements FilterTracer.
+func (l *TraceLog) TraceItem(t ItemTrace) {
+	l.write("item", t.Item.URL, t.Dropped(), t.Steps, t)
+}
+
+// TraceLink implements FilterTracer.
+func (l *TraceLog) TraceLink(t LinkTrace) {
+	l.write("link", t.Link.URL, t.Dropped(), t.Steps, t)
+}
+
+// traceRecord is a single line of the trace log.
+type traceRecord struct {
+	Time  time.Time   `json:"time"`
+	Type  string      `json:"type"`
+	Trace interface{} `json:"trace"`
+}
+
+func (l *TraceLog) write(typ, url string, dropped bool, steps []TraceStep, trace interface{}) {
+	if l.w == nil {
+		s := make([]string, len(steps))
+		for i, step := range steps {
+			s[i] = step.String()
+		}
+		l.log.Info("filter trace", "type", typ, "url", url, "dropped", dropped, "steps", strings.Join(s, "; "))
+		return
+	}
+
+	b, err := json.Marshal(traceRecord{Time: time.Now(), Type: typ, Trace: trace})
+	if err != nil {
+		l.log.Error("could not encode filter trace", "url", url, "err", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(append(b, '\n')); err != nil {
+		l.log.Error("could not write filter trace", "url", url, "err", err)
+	}
+}
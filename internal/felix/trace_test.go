@@ -0,0 +1,194 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTraceItems(t *testing.T) {
+	filters := []ItemFilter{
+		NamedItemFilter("title", ItemTitleFilter("Wanted")),
+	}
+
+	traces := TraceItems([]Item{{Title: "Wanted S01E01"}, {Title: "Other"}}, filters...)
+
+	if len(traces) != 2 {
+		t.Fatalf("unexpected number of traces. expected %v, got %v", 2, len(traces))
+	}
+	if traces[0].Dropped() || len(traces[0].Output) != 1 {
+		t.Errorf("unexpected trace of passed item: %+v", traces[0])
+	}
+	if !traces[1].Dropped() || traces[1].DroppedBy != "title" || traces[1].DroppedAt != 1 {
+		t.Errorf("unexpected trace of dropped item: %+v", traces[1])
+	}
+
+	expected := []TraceStep{{Position: 1, Filter: "title", Decision: FilterDropped, Reason: `title "Other" does not contain all words of any of the 1 titles`}}
+	if !reflect.DeepEqual(traces[1].Steps, expected) {
+		t.Errorf("unexpected steps. expected %v, got %v", expected, traces[1].Steps)
+	}
+}
+
+func TestTraceLinks(t *testing.T) {
+	expand := LinkFilterFunc(func(link Link, next func(Link)) {
+		next(Link{URL: link.URL + "/1"})
+		next(Link{URL: link.URL + "/2"})
+	})
+	dropSecond := LinkFilterFunc(func(link Link, next func(Link)) {
+		if link.URL != "drop/2" {
+			next(link)
+		}
+	})
+
+	filters := []LinkFilter{
+		NamedLinkFilter("expand", expand),
+		NamedLinkFilter("duplicates", LinkDuplicatesFilter(10)),
+		dropSecond,
+	}
+
+	testCases := []struct {
+		desc      string
+		link      Link
+		output    []Link
+		droppedBy string
+		droppedAt int
+		steps     []TraceStep
+	}{
+		{
+			desc:   "expanded",
+			link:   Link{URL: "a"},
+			output: []Link{{URL: "a/1"}, {URL: "a/2"}},
+			steps: []TraceStep{
+				{Position: 1, Filter: "expand", Decision: FilterModified, Reason: "expanded into 2 links"},
+				{Position: 2, Filter: "duplicates", Decision: FilterAccepted},
+				{Position: 3, Filter: "unnamed", Decision: FilterAccepted},
+			},
+		},
+		{
+			desc:      "duplicate",
+			link:      Link{URL: "a"},
+			droppedBy: "duplicates",
+			droppedAt: 2,
+			steps: []TraceStep{
+				{Position: 1, Filter: "expand", Decision: FilterModified, Reason: "expanded into 2 links"},
+				{Position: 2, Filter: "duplicates", Decision: FilterDropped, Reason: "duplicate of one of the last 10 links"},
+			},
+		},
+		{
+			desc:   "partially dropped",
+			link:   Link{URL: "drop"},
+			output: []Link{{URL: "drop/1"}},
+			steps: []TraceStep{
+				{Position: 1, Filter: "expand", Decision: FilterModified, Reason: "expanded into 2 links"},
+				{Position: 2, Filter: "duplicates", Decision: FilterAccepted},
+				{Position: 3, Filter: "unnamed", Decision: FilterModified, Reason: "dropped 1 of 2 links: not passed to the next filter"},
+			},
+		},
+	}
+
+	var links []Link
+	for _, tC := range testCases {
+		links = append(links, tC.link)
+	}
+	traces := TraceLinks(links, filters...)
+
+	for i, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got := traces[i]
+			if !reflect.DeepEqual(got.Output, tC.output) {
+				t.Errorf("unexpected output. expected %v, got %v", tC.output, got.Output)
+			}
+			if got.DroppedBy != tC.droppedBy || got.DroppedAt != tC.droppedAt {
+				t.Errorf("unexpected dropping filter. expected %v (%v), got %v (%v)", tC.droppedBy, tC.droppedAt, got.DroppedBy, got.DroppedAt)
+			}
+			if !reflect.DeepEqual(got.Steps, tC.steps) {
+				t.Errorf("unexpected steps. expected %v, got %v", tC.steps, got.Steps)
+			}
+		})
+	}
+
+	if traces := TraceLinks([]Link{{URL: "x"}}, dropSecond, LinkFilterFunc(func(Link, func(Link)) {})); traces[0].DroppedBy != "unnamed" {
+		t.Errorf("unexpected name of unnamed filter. expected %v, got %v", "unnamed", traces[0].DroppedBy)
+	}
+}
+
+func TestTraceLinks_Modified(t *testing.T) {
+	traces := TraceLinks([]Link{{URL: "http://example.com/file.zip"}}, NamedLinkFilter("filenameastitle", LinkFilenameAsTitleFilter(true)))
+
+	expected := TraceStep{Position: 1, Filter: "filenameastitle", Decision: FilterModified, Reason: `title "" -> "file"`}
+	if len(traces[0].Steps) != 1 || traces[0].Steps[0] != expected {
+		t.Errorf("unexpected steps. expected %v, got %v", expected, traces[0].Steps)
+	}
+}
+
+func TestFilterLinksTraced(t *testing.T) {
+	in := make(chan Link, 2)
+	out := make(chan Link, 2)
+	in <- Link{URL: "http://example.com/a"}
+	in <- Link{URL: "http://other.com/b"}
+	close(in)
+
+	var b bytes.Buffer
+	FilterLinksTraced(in, out, NewTraceLog(1, &b), NamedLinkFilter("domain", LinkDomainFilter("example.com")))
+
+	var links []Link
+	for link := range out {
+		links = append(links, link)
+	}
+	if len(links) != 1 {
+		t.Errorf("unexpected number of links. expected %v, got %v", 1, len(links))
+	}
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected number of trace lines. expected %v, got %v", 2, len(lines))
+	}
+
+	var record struct {
+		Type  string    `json:"type"`
+		Trace LinkTrace `json:"trace"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil {
+		t.Fatalf("could not decode trace line: %v", err)
+	}
+	if record.Type != "link" || record.Trace.DroppedBy != "domain" {
+		t.Errorf("unexpected trace record: %+v", record)
+	}
+	expected := `domain "other.com" is not one of example.com`
+	if len(record.Trace.Steps) != 1 || record.Trace.Steps[0].Reason != expected {
+		t.Errorf("unexpected steps. expected reason %v, got %v", expected, record.Trace.Steps)
+	}
+}
+
+func TestTraceLog_Sample(t *testing.T) {
+	testCases := []struct {
+		desc string
+		rate float64
+		min  int
+		max  int
+	}{
+		{desc: "none", rate: 0, min: 0, max: 0},
+		{desc: "all", rate: 1, min: 1000, max: 1000},
+		{desc: "half", rate: 0.5, min: 350, max: 650},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			l := NewTraceLog(tC.rate, nil)
+			n := 0
+			for i := 0; i < 1000; i++ {
+				if l.Sample() {
+					n++
+				}
+			}
+			if n < tC.min || n > tC.max {
+				t.Errorf("unexpected number of samples. expected %v to %v, got %v", tC.min, tC.max, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package felix
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigError is a single problem of the configuration at the given YAML path, e.g. "feeds[1].url".
+type ConfigError struct {
+	Path string
+	Err  error
+}
+
+func (e ConfigError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+// ConfigErrors contains all problems found while checking a configuration.
+type ConfigErrors []ConfigError
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Add adds err at the given path. The problems of nested ConfigErrors are added relative to the path.
+func (e *ConfigErrors) Add(path string, err error) {
+	if errs, ok := err.(ConfigErrors); ok {
+		for _, ce := range errs {
+			*e = append(*e, ConfigError{Path: joinPath(path, ce.Path), Err: ce.Err})
+		}
+		return
+	}
+	*e = append(*e, ConfigError{Path: path, Err: err})
+}
+
+// Err returns the ConfigErrors as error or nil if there are none.
+func (e ConfigErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func joinPath(parent, child string) string {
+	if parent == "" || child == "" {
+		return parent + child
+	}
+	if strings.HasPrefix(child, "[") {
+		return parent + child
+	}
+	return parent + "." + child
+}
+
+// ValidateHTTPURL returns an error if s is not an absolute http or https URL.
+func ValidateHTTPURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return errors.Errorf("invalid URL %q", s)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Errorf("invalid URL %q, expected an absolute http or https URL", s)
+	}
+	return nil
+}
+
+// Validate checks the configuration for problems that can be found without knowledge of the
+// available feed, filter and sink types: unknown keys, negative durations and zero intervals (see ConfigFromFile),
+// invalid URLs and conflicting settings. The configurations of feeds, filters and sinks are checked
+// by their own types, e.g. via FilterConfig.Unmarshal.
+func (c Config) Validate() error {
+	errs := append(ConfigErrors(nil), c.problems...)
+
+	if c.Port < 0 || c.Port > 65535 {
+		errs.Add("port", errors.Errorf("invalid port %d", c.Port))
+	}
+	if _, err := c.TLS(); err != nil {
+		errs.Add("tlsKeyFile", err)
+	}
+	if c.Auth.Username != "" && c.Auth.Password == "" {
+		errs.Add("auth.password", errors.New("missing password for username"))
+	} else if c.Auth.Username == "" && c.Auth.Password != "" {
+		errs.Add("auth.username", errors.New("missing username for password"))
+	}
+	if c.FeedOutputLink != "" {
+		if err := ValidateHTTPURL(c.FeedOutputLink); err != nil {
+			errs.Add("feedOutputLink", err)
+		}
+	}
+
+	if c.FilterTrace.Rate < 0 || c.FilterTrace.Rate > 1 {
+		errs.Add("filterTrace.rate", errors.Errorf("rate must be between 0 and 1, got %v", c.FilterTrace.Rate))
+	}
+
+	feeds := make(map[string]bool)
+	for i, fc := range c.Feeds {
+		path := fmt.Sprintf("feeds[%d]", i)
+		if fc.Type == "" {
+			errs.Add(path+".type", errors.New("missing feed type"))
+		}
+		if err := ValidateHTTPURL(fc.URL); err != nil {
+			errs.Add(path+".url", err)
+		} else if feeds[fc.URL] {
+			errs.Add(path+".url", errors.Errorf("duplicate feed %q", fc.URL))
+		}
+		feeds[fc.URL] = true
+	}
+
+	paths := make(map[string]bool)
+	outputs := c.FeedOutputs()
+	// The default output feed comes first, unless one of the configured outputs replaces it
+	offset := len(outputs) - len(c.Outputs)
+	for i, o := range outputs {
+		path := fmt.Sprintf("outputs[%d]", i-offset)
+		for _, p := range FeedPaths(o.Path) {
+			if paths[p] {
+				errs.Add(path+".path", errors.Errorf("duplicate output path %q", p))
+				break
+			}
+			paths[p] = true
+		}
+		if i >= offset && o.Link != "" {
+			if err := ValidateHTTPURL(o.Link); err != nil {
+				errs.Add(path+".link", err)
+			}
+		}
+	}
+
+	sinks := make(map[string]bool)
+	for i, sc := range c.Sinks {
+		path := fmt.Sprintf("sinks[%d]", i)
+		if sc.Type == "" {
+			errs.Add(path+".type", errors.New("missing sink type"))
+		}
+		if sinks[sc.Name] {
+			errs.Add(path+".name", errors.Errorf("duplicate sink name %q", sc.Name))
+		}
+		sinks[sc.Name] = true
+	}
+
+	return errs.Err()
+}
+
+var (
+	durationType    = reflect.TypeOf(time.Duration(0))
+	unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+)
+
+// nonZeroDurations are the paths of the config file durations for which zero is not a valid setting.
+// All other durations use their default value or documented behavior if they are zero.
+var nonZeroDurations = map[string]bool{
+	"fetchInterval":     true,
+	"cleanupInterval":   true,
+	"cleanupMaxAge":     true,
+	"feedOutputMaxAge":  true,
+	"sinkRetryInterval": true,
+}
+
+// checkNode reports the unknown keys and invalid durations (see checkDuration) of the decoded YAML node,
+// which is unmarshaled into a value of type t. Keys are compared case-insensitively if fold is set
+// (like mapstructure does). Types with a custom UnmarshalYAML method check their own values.
+func checkNode(errs *ConfigErrors, path string, node interface{}, t reflect.Type, fold bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if node == nil || reflect.PtrTo(t).Implements(unmarshalerType) {
+		return
+	}
+
+	switch {
+	case t == durationType:
+		if err := checkDuration(node, !fold && nonZeroDurations[path]); err != nil {
+			errs.Add(path, err)
+		}
+
+	case t.Kind() == reflect.Struct:
+		m, ok := toStringMap(node)
+		if !ok {
+			return
+		}
+		for _, key := range sortedKeys(m) {
+			f, ok := structField(t, key, fold)
+			if !ok {
+				errs.Add(joinPath(path, key), errors.New("unknown key"))
+				continue
+			}
+			checkNode(errs, joinPath(path, key), m[key], f.Type, fold)
+		}
+
+	case t.Kind() == reflect.Slice:
+		if s, ok := node.([]interface{}); ok {
+			for i, value := range s {
+				checkNode(errs, fmt.Sprintf("%s[%d]", path, i), value, t.Elem(), fold)
+			}
+		}
+
+	case t.Kind() == reflect.Map:
+		if m, ok := toStringMap(node); ok {
+			for _, key := range sortedKeys(m) {
+				checkNode(errs, joinPath(path, key), m[key], t.Elem(), fold)
+			}
+		}
+	}
+}
+
+// sortedKeys returns the keys of m in sorted order, so problems are reported in a stable order.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkDuration returns an error if the YAML value is not a duration, is negative or is zero if nonZero is set.
+func checkDuration(node interface{}, nonZero bool) error {
+	var d time.Duration
+	switch v := node.(type) {
+	case int:
+		d = time.Duration(v)
+	case string:
+		var err error
+		if d, err = time.ParseDuration(v); err != nil {
+			return errors.Errorf("invalid duration %q", v)
+		}
+	default:
+		return errors.Errorf("invalid duration %v", v)
+	}
+
+	if d < 0 {
+		return errors.Errorf("duration must not be negative, got %v", node)
+	}
+	if d == 0 && nonZero {
+		return errors.Errorf("duration must be positive, got %v", node)
+	}
+	return nil
+}
+
+// structField returns the field of the struct type t that the key is decoded into.
+// The key is the yaml tag name of the field or the lowercase field name if there is none.
+func structField(t reflect.Type, key string, fold bool) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+
+		if name == key || (fold && strings.EqualFold(name, key)) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// toStringMap converts a decoded YAML mapping to a map with string keys.
+func toStringMap(node interface{}) (map[string]interface{}, bool) {
+	switch m := node.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		sm := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			sm[fmt.Sprint(k)] = v
+		}
+		return sm, true
+	}
+	return nil, false
+}
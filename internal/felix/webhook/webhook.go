@@ -0,0 +1,189 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package webhook implements a felix.LinkSink that posts new links to an HTTP endpoint.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/martinplaner/felix/internal/felix"
+	"github.com/pkg/errors"
+)
+
+// Default values of the webhook configuration.
+const (
+	DefaultRetries         = 3
+	DefaultRetryDelay      = 1 * time.Second
+	DefaultTimeout         = 10 * time.Second
+	DefaultSignatureHeader = "X-Felix-Signature"
+)
+
+// Config contains the configuration of a webhook sink.
+type Config struct {
+	URL string `yaml:"url"`
+	// Template is a text/template for the request body, see felix.SinkTemplate.
+	// The link is sent as JSON if it is empty.
+	Template string `yaml:"template"`
+	// ContentType of the request body, defaults to "application/json".
+	ContentType string            `yaml:"contentType"`
+	Headers     map[string]string `yaml:"headers"`
+	// Secret is the key of the HMAC-SHA256 signature of the request body.
+	// The signature is sent hex encoded in the SignatureHeader, prefixed by "sha256=".
+	Secret          string `yaml:"secret"`
+	SignatureHeader string `yaml:"signatureHeader"`
+	// Retries is the number of retries of a failed request, before the link is queued.
+	// The delay between retries starts with RetryDelay and doubles with every retry.
+	Retries    int           `yaml:"retries"`
+	RetryDelay time.Duration `yaml:"retryDelay"`
+	Timeout    time.Duration `yaml:"timeout"`
+}
+
+// Sink posts links to a webhook.
+type Sink struct {
+	config Config
+	body   *template.Template
+	client *http.Client
+}
+
+var _ felix.LinkSink = new(Sink)
+
+// New creates a new webhook sink. A nil client will default to http.DefaultClient.
+// Unset config values are replaced by their defaults.
+func New(config Config, client *http.Client) (*Sink, error) {
+	if config.URL == "" {
+		return nil, errors.New("missing webhook url")
+	}
+	if err := felix.ValidateHTTPURL(config.URL); err != nil {
+		return nil, err
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	if config.ContentType == "" {
+		config.ContentType = "application/json"
+	}
+	if config.SignatureHeader == "" {
+		config.SignatureHeader = DefaultSignatureHeader
+	}
+	if config.Retries < 0 {
+		config.Retries = 0
+	} else if config.Retries == 0 {
+		config.Retries = DefaultRetries
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = DefaultRetryDelay
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	s := &Sink{
+		config: config,
+		client: client,
+	}
+
+	if config.Template != "" {
+		tmpl, err := felix.SinkTemplate("webhook", config.Template)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not parse webhook template")
+		}
+		s.body = tmpl
+	}
+
+	return s, nil
+}
+
+// Send posts the link to the webhook. Failed requests are retried with an exponential backoff.
+// Client errors (HTTP 4xx, except 408 and 429) are not retried and reported as felix.PermanentError.
+func (s *Sink) Send(ctx context.Context, link felix.Link) error {
+	body, err := s.render(link)
+	if err != nil {
+		return felix.Permanent(errors.Wrap(err, "could not render request body"))
+	}
+
+	delay := s.config.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err = s.post(ctx, body)
+		if err == nil || felix.IsPermanent(err) || attempt >= s.config.Retries {
+			return err
+		}
+
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func (s *Sink) render(link felix.Link) ([]byte, error) {
+	if s.body == nil {
+		return json.Marshal(link)
+	}
+
+	var buf bytes.Buffer
+	if err := s.body.Execute(&buf, link); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *Sink) post(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return felix.Permanent(errors.Wrap(err, "could not create request"))
+	}
+
+	req.Header.Set("Content-Type", s.config.ContentType)
+	for k, v := range s.config.Headers {
+		req.Header.Set(k, v)
+	}
+	if s.config.Secret != "" {
+		req.Header.Set(s.config.SignatureHeader, "sha256="+Sign(s.config.Secret, body))
+	}
+
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "could not post to webhook")
+	}
+	defer resp.Body.Close()
+	// Drain the body, so the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	err = &felix.StatusError{Code: resp.StatusCode}
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return felix.Permanent(err)
+	}
+	return err
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body with the given secret.
+// Receivers can use it to verify the signature header.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
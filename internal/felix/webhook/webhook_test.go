@@ -0,0 +1,158 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/martinplaner/felix/internal/felix"
+)
+
+func TestSink_Send(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		config      Config
+		expectedCT  string
+		expectedRaw string
+	}{
+		{
+			desc:        "json",
+			config:      Config{},
+			expectedCT:  "application/json",
+			expectedRaw: `{"title":"Title","url":"http://example.com/file","source":"http://example.com/page","added":"0001-01-01T00:00:00Z","status":""}`,
+		},
+		{
+			desc: "template",
+			config: Config{
+				Template:    `{{.Title}} on {{host .URL}} ({{json .URL}})`,
+				ContentType: "text/plain",
+			},
+			expectedCT:  "text/plain",
+			expectedRaw: `Title on example.com ("http://example.com/file")`,
+		},
+	}
+
+	link := felix.Link{Title: "Title", URL: "http://example.com/file", Source: "http://example.com/page"}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			var body, contentType, signature string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := ioutil.ReadAll(r.Body)
+				body = string(b)
+				contentType = r.Header.Get("Content-Type")
+				signature = r.Header.Get(DefaultSignatureHeader)
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer server.Close()
+
+			tC.config.URL = server.URL
+			tC.config.Secret = "secret"
+			sink, err := New(tC.config, nil)
+			if err != nil {
+				t.Fatalf("could not create sink: %v", err)
+			}
+
+			if err := sink.Send(context.Background(), link); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if body != tC.expectedRaw {
+				t.Errorf("unexpected body. expected %v, got %v", tC.expectedRaw, body)
+			}
+			if contentType != tC.expectedCT {
+				t.Errorf("unexpected content type. expected %v, got %v", tC.expectedCT, contentType)
+			}
+			if expected := "sha256=" + Sign("secret", []byte(body)); signature != expected {
+				t.Errorf("unexpected signature. expected %v, got %v", expected, signature)
+			}
+		})
+	}
+}
+
+func TestSink_Retry(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		codes            []int
+		expectedErr      bool
+		expectedPerm     bool
+		expectedRequests int
+	}{
+		{
+			desc:             "success after retries",
+			codes:            []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+			expectedRequests: 3,
+		},
+		{
+			desc:             "retries exhausted",
+			codes:            []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusOK},
+			expectedErr:      true,
+			expectedRequests: 3,
+		},
+		{
+			desc:             "client error is permanent",
+			codes:            []int{http.StatusBadRequest, http.StatusOK},
+			expectedErr:      true,
+			expectedPerm:     true,
+			expectedRequests: 1,
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			var mu sync.Mutex
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				w.WriteHeader(tC.codes[requests])
+				requests++
+			}))
+			defer server.Close()
+
+			sink, err := New(Config{URL: server.URL, Retries: 2, RetryDelay: 1 * time.Millisecond}, nil)
+			if err != nil {
+				t.Fatalf("could not create sink: %v", err)
+			}
+
+			err = sink.Send(context.Background(), felix.Link{URL: "http://example.com/file"})
+
+			if (err != nil) != tC.expectedErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if felix.IsPermanent(err) != tC.expectedPerm {
+				t.Errorf("unexpected permanent error. expected %v, got %v", tC.expectedPerm, felix.IsPermanent(err))
+			}
+			if requests != tC.expectedRequests {
+				t.Errorf("unexpected number of requests. expected %v, got %v", tC.expectedRequests, requests)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{}, nil); err == nil {
+		t.Error("expected error for missing url")
+	}
+
+	if _, err := New(Config{URL: "http://example.com", Template: "{{"}, nil); err == nil {
+		t.Error("expected error for invalid template")
+	}
+
+	sink, err := New(Config{URL: "http://example.com", Headers: map[string]string{"X-Test": "1"}}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if sink.config.Retries != DefaultRetries || sink.config.Timeout != DefaultTimeout {
+		t.Errorf("defaults were not applied: %+v", sink.config)
+	}
+}
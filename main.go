@@ -11,14 +11,22 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"regexp"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/martinplaner/felix/internal/felix"
+	"github.com/martinplaner/felix/internal/felix/aria2"
 	"github.com/martinplaner/felix/internal/felix/bolt"
+	"github.com/martinplaner/felix/internal/felix/command"
+	"github.com/martinplaner/felix/internal/felix/email"
 	"github.com/martinplaner/felix/internal/felix/html"
+	"github.com/martinplaner/felix/internal/felix/jdownloader"
+	"github.com/martinplaner/felix/internal/felix/metrics"
+	"github.com/martinplaner/felix/internal/felix/mqtt"
 	"github.com/martinplaner/felix/internal/felix/rss"
+	"github.com/martinplaner/felix/internal/felix/webhook"
 	"golang.org/x/net/context"
 )
 
@@ -39,24 +47,51 @@ var (
 	newLinks      = make(chan felix.Link)
 	filteredItems = make(chan felix.Item)
 	filteredLinks = make(chan felix.Link)
+	feedRegistry  = felix.NewFetcherRegistry()
+	pageRegistry  = felix.NewFetcherRegistry()
 )
 
+// commands contains the available subcommands, besides running the default service.
+var commands = map[string]func(args []string) int{
+	"export":       exportCommand,
+	"import":       importCommand,
+	"run":          runCommand,
+	"scan":         scanCommand,
+	"check-config": checkConfigCommand,
+	"explain":      explainCommand,
+}
+
 func main() {
-	printVersion()
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			// Keep stdout clean for command output
+			log.SetOutput(os.Stderr)
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
 
 	configfile := flag.String("config", "config.yml", "location of the config file")
 	datadir := flag.String("datadir", ".", "dir for auxiliary data")
 	flag.Parse()
 
+	serve(*configfile, *datadir)
+}
+
+// serve runs felix as a long-lived service with an HTTP server until it receives a shutdown signal.
+func serve(configfile, datadir string) {
+	printVersion()
+
 	// Initialize config and shared components
 
-	config, err := felix.ConfigFromFile(*configfile)
+	config := mustLoadConfig(configfile)
+	log.Info("read config from file.", "configfile", configfile)
+
+	useTLS, err := config.TLS()
 	if err != nil {
-		log.Fatal("could not read config file", "configfile", *configfile)
+		log.Fatal("invalid TLS config", "err", err)
 	}
-	log.Info("read config from file.", "configfile", *configfile)
 
-	datastorefile := filepath.Join(*datadir, "felix.db")
+	datastorefile := filepath.Join(datadir, "felix.db")
 	db, err := bolt.NewDatastore(datastorefile)
 	if err != nil {
 		log.Fatal("could not create datastore", "file", datastorefile)
@@ -67,9 +102,13 @@ func main() {
 
 	// Configure fetchers and filters
 
-	feedFetchers := initFeedFetchers(config, db)
 	itemFilters := initItemFilters(config)
-	linkFilters := initLinkFilters(config)
+	linkFilters := initLinkFilters(config.LinkFilters)
+	tracer, closeTracer := initFilterTracer(config)
+	defer closeTracer()
+
+	events := felix.NewLinkBroker()
+	sinks := initSinks(config, db)
 
 	quit := make(chan struct{})
 	var wgFeeds sync.WaitGroup
@@ -96,33 +135,89 @@ func main() {
 
 	// Start up components
 
-	for _, f := range feedFetchers {
-		wgFeeds.Add(1)
-		go func(f *felix.Fetcher) {
-			f.Start(quit)
-			wgFeeds.Done()
-		}(f)
+	feeds := felix.NewFeedManager(db, feedRegistry, newFeedFetcher(config, db))
+	feeds.SetLogger(log)
+	if err := feeds.Start(config.Feeds, quit, &wgFeeds); err != nil {
+		log.Fatal("could not start feeds", "err", err)
 	}
 
-	go felix.FilterItems(newItems, filteredItems, itemFilters...)
-	go runPageFetchers(config, db, &wgItems)
-	go felix.FilterLinks(newLinks, filteredLinks, linkFilters...)
+	sinks.Start()
+
+	go felix.FilterItemsTraced(newItems, filteredItems, tracer, itemFilters...)
+	go runPageFetchers(config, db, sinks, &wgItems)
+	go felix.FilterLinksTraced(newLinks, filteredLinks, tracer, linkFilters...)
 
 	wgFeeds.Add(1)
 	go func() {
-		periodicCleanup(db, config.CleanupInterval, config.CleanupMaxAge, quit)
+		periodicCleanup(db, config.CleanupInterval, config.CleanupRetention(), quit)
 		wgFeeds.Done()
 	}()
 
-	http.Handle("/", felix.FeedHandler(db, config.FeedOutputMaxAge))
-	http.Handle("/filters", felix.StringHandler(felix.FilterString(itemFilters, linkFilters)))
+	metrics.NewGaugeVecFunc("felix_fetchers_active", "Number of running fetchers by kind (feed, page).", "kind", func() map[string]float64 {
+		return map[string]float64{
+			"feed": float64(feedRegistry.Len()),
+			"page": float64(pageRegistry.Len()),
+		}
+	})
+
+	registerOutputs(config, db)
+	handle("/metrics", felix.MetricsHandler())
+	handle("/links/seen", felix.LinkStatusHandler(db, felix.LinkSeen))
+	handle("/links/downloaded", felix.LinkStatusHandler(db, felix.LinkDownloaded))
+	handle("/links/ignored", felix.LinkStatusHandler(db, felix.LinkRejected))
+	handle("/events", felix.EventsHandler(db, events, config.CleanupRetention().Links.MaxAge))
+	handle("/filters", felix.StringHandler(felix.FilterString(itemFilters, linkFilters)))
+	handle("/dashboard", felix.DashboardHandler(db, feeds, pageRegistry, felix.FilterString(itemFilters, linkFilters), config.CleanupRetention().Links.MaxAge))
+	handle("/api/links", felix.APILinksHandler(db, config.CleanupRetention().Links.MaxAge))
+	handle("/api/items", felix.APIItemsHandler(db, config.CleanupRetention().Items.MaxAge))
+	apiFeeds := felix.APIFeedsHandler(db, feeds, feedRegistry)
+	handle("/api/feeds", apiFeeds)
+	apiFetchers := felix.APIFetchersHandler(db, pageRegistry, config.CleanupRetention().Items.MaxAge)
+	handle("/api/fetchers", apiFetchers)
+	// Older endpoints, kept as aliases
+	handle("/status", apiFeeds)
+	handle("/fetchers", apiFetchers)
+	handle("/api/trace", felix.APITraceHandler(db, config.CleanupRetention().Links.MaxAge, func() ([]felix.ItemFilter, []felix.LinkFilter) {
+		// Requests must not fetch remote pages for every traced link
+		var linkFilters []felix.FilterConfig
+		for _, f := range config.LinkFilters {
+			if f.Type != "expanduploadedlinks" {
+				linkFilters = append(linkFilters, f)
+			}
+		}
+		return initItemFilters(config), initLinkFilters(linkFilters)
+	}))
+
+	if config.AdminToken != "" {
+		handle("/admin/feeds", felix.AdminAuth(config.AdminToken, felix.AdminFeedsHandler(feeds)))
+		handle("/admin/feeds/remove", felix.AdminAuth(config.AdminToken, felix.AdminFeedActionHandler(feeds.Remove)))
+		handle("/admin/feeds/pause", felix.AdminAuth(config.AdminToken, felix.AdminFeedActionHandler(feeds.Pause)))
+		handle("/admin/feeds/resume", felix.AdminAuth(config.AdminToken, felix.AdminFeedActionHandler(feeds.Resume)))
+		handle("/admin/fetch", felix.AdminAuth(config.AdminToken, felix.AdminFetchHandler(feedRegistry, pageRegistry)))
+	} else {
+		log.Info("admin endpoints disabled, no adminToken configured")
+	}
+
+	auth := config.HTTPAuth()
+	if !auth.Enabled() {
+		log.Info("http authentication disabled, no credentials configured")
+	}
 
-	// TODO: make host configurable
-	server := &http.Server{Addr: fmt.Sprintf("%s:%d", "", config.Port)}
+	server := &http.Server{
+		Addr: config.Addr(),
+		// Reject unauthenticated requests before they reach any handler
+		Handler: felix.Authenticate(auth, http.DefaultServeMux),
+	}
 
 	go func() {
-		log.Info("starting http server", "addr", server.Addr)
-		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Info("starting http server", "addr", server.Addr, "tls", useTLS)
+		var err error
+		if useTLS {
+			err = server.ListenAndServeTLS(config.TLSCertFile, config.TLSKeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
 			log.Error("http server error", "err", err)
 			shutdown(1)
 		}
@@ -133,12 +228,30 @@ func main() {
 		exists, err := db.StoreLink(link)
 		if err != nil {
 			log.Error("could not store link", "err", err, "link", link)
+			continue
+		}
+		if exists {
+			continue
 		}
-		if !exists {
-			log.Info("found new link", "url", link.URL)
+
+		log.Info("found new link", "url", link.URL)
+
+		// Publish the link with all datastore managed fields
+		stored, err := db.GetLink(link.URL)
+		if err != nil {
+			log.Error("could not get stored link", "err", err, "url", link.URL)
+			continue
 		}
+		events.Publish(stored)
+		sinks.Send(stored)
 	}
 
+	// Queue all links that were not delivered yet
+	sinks.Close()
+
+	// End all event streams, so the server can shut down
+	events.Close()
+
 	if err := server.Shutdown(context.Background()); err != nil {
 		log.Error("could not shutdown http server", "err", err)
 	} else {
@@ -149,24 +262,27 @@ func main() {
 	os.Exit(returnCode)
 }
 
-// run periodic datastore cleanup routine to remove entries older than maxAge
-func periodicCleanup(db felix.Datastore, interval time.Duration, maxAge time.Duration, quit <-chan struct{}) {
+// run periodic datastore cleanup routine to remove entries not covered by the retention policies
+func periodicCleanup(db felix.Datastore, interval time.Duration, retention felix.Retention, quit <-chan struct{}) {
 L:
 	for {
 		select {
 		case <-time.After(interval):
-			if err := db.Cleanup(maxAge); err != nil {
+			stats, err := db.Cleanup(retention)
+			if err != nil {
 				log.Error("could not cleanup datastore", "err", err)
+				continue
 			}
+			log.Info("cleaned up datastore", "items", stats.Items, "links", stats.Links, "attempts", stats.Attempts, "history", stats.History)
 		case <-quit:
 			break L
 		}
 	}
 }
 
-func initFeedFetchers(config felix.Config, data felix.Datastore) []*felix.Fetcher {
-	var feedFetchers []*felix.Fetcher
-	for _, fc := range config.Feeds {
+// newFeedFetcher returns a function that creates the fetcher for a feed.
+func newFeedFetcher(config felix.Config, data felix.Datastore) func(felix.FeedConfig) (*felix.Fetcher, error) {
+	return func(fc felix.FeedConfig) (*felix.Fetcher, error) {
 		switch fc.Type {
 
 		case "rss":
@@ -178,111 +294,334 @@ func initFeedFetchers(config felix.Config, data felix.Datastore) []*felix.Fetche
 			nextFetch := felix.NewAttempter(data, felix.PeriodicNextAttemptFunc(fetchInterval))
 			f := felix.NewFetcher(fc.URL, source, rss.ItemScanner, nextFetch, newItems, newLinks)
 			f.SetLogger(log)
-			feedFetchers = append(feedFetchers, f)
+			f.SetRecorder(data)
+			return f, nil
 
 		default:
-			log.Fatal("unknown feed type", "type", fc.Type)
+			return nil, fmt.Errorf("unknown feed type %q", fc.Type)
 		}
 	}
-	return feedFetchers
 }
 
 func initItemFilters(config felix.Config) []felix.ItemFilter {
 	var itemFilters []felix.ItemFilter
 	for _, f := range config.ItemFilters {
-		switch f.Type {
-
-		case "title":
-			var fc felix.ItemTitleFilterConfig
-			if err := f.Unmarshal(&fc); err != nil {
-				log.Fatal("could not decode filter config", "err", err, "type", f.Type)
-			}
+		itemFilter, err := newItemFilter(f)
+		if err != nil {
+			log.Fatal("could not create item filter", "err", err, "type", f.Type)
+		}
+		itemFilters = append(itemFilters, itemFilter)
+	}
+	return itemFilters
+}
 
-			itemFilters = append(itemFilters, felix.ItemTitleFilter(fc.Titles...))
+// newItemFilter creates the item filter of the given config.
+func newItemFilter(f felix.FilterConfig) (felix.ItemFilter, error) {
+	switch f.Type {
 
-		default:
-			log.Fatal("unsupported item filter type", "type", f.Type)
+	case "title":
+		var fc felix.ItemTitleFilterConfig
+		if err := f.Unmarshal(&fc); err != nil {
+			return nil, err
 		}
+
+		return felix.NamedItemFilter(f.Type, felix.ItemTitleFilter(fc.Titles...)), nil
+
+	default:
+		return nil, unknownType("item filter", f.Type)
 	}
-	return itemFilters
 }
 
-func initLinkFilters(config felix.Config) []felix.LinkFilter {
+func initLinkFilters(configs []felix.FilterConfig) []felix.LinkFilter {
 	var linkFilters []felix.LinkFilter
-	for _, f := range config.LinkFilters {
-		switch f.Type {
+	for _, f := range configs {
+		linkFilter, err := newLinkFilter(f)
+		if err != nil {
+			log.Fatal("could not create link filter", "err", err, "type", f.Type)
+		}
+		linkFilters = append(linkFilters, linkFilter)
+	}
+	return linkFilters
+}
 
-		case "duplicates":
-			var fc felix.LinkDuplicatesFilterConfig
-			if err := f.Unmarshal(&fc); err != nil {
-				log.Fatal("could not decode filter config", "err", err, "type", f.Type)
-			}
-			if fc.Size <= 0 {
-				fc.Size = 100
-			}
-			linkFilters = append(linkFilters, felix.LinkDuplicatesFilter(fc.Size))
+// initFilterTracer creates the sampled filter decision log of the config, or returns nil if it is disabled.
+// The returned function closes the trace file.
+func initFilterTracer(config felix.Config) (felix.FilterTracer, func()) {
+	tc := config.FilterTrace
+	if tc.Rate <= 0 {
+		return nil, func() {}
+	}
+
+	if tc.File == "" {
+		tracer := felix.NewTraceLog(tc.Rate, nil)
+		tracer.SetLogger(log)
+		log.Info("logging filter traces", "rate", tc.Rate)
+		return tracer, func() {}
+	}
+
+	f, err := os.OpenFile(tc.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Fatal("could not open filter trace file", "file", tc.File, "err", err)
+	}
+	tracer := felix.NewTraceLog(tc.Rate, f)
+	tracer.SetLogger(log)
+	log.Info("writing filter traces", "file", tc.File, "rate", tc.Rate)
+	return tracer, func() { f.Close() }
+}
+
+// newLinkFilter creates the link filter of the given config.
+func newLinkFilter(f felix.FilterConfig) (felix.LinkFilter, error) {
+	switch f.Type {
 
-		case "domain":
-			var fc felix.LinkDomainFilterConfig
-			if err := f.Unmarshal(&fc); err != nil {
-				log.Fatal("could not decode filter config", "err", err, "type", f.Type)
+	case "duplicates":
+		var fc felix.LinkDuplicatesFilterConfig
+		if err := f.Unmarshal(&fc); err != nil {
+			return nil, err
+		}
+		if fc.Size <= 0 {
+			fc.Size = 100
+		}
+
+		return felix.NamedLinkFilter(f.Type, felix.LinkDuplicatesFilter(fc.Size)), nil
+
+	case "domain":
+		var fc felix.LinkDomainFilterConfig
+		if err := f.Unmarshal(&fc); err != nil {
+			return nil, err
+		}
+
+		return felix.NamedLinkFilter(f.Type, felix.LinkDomainFilter(fc.Domains...)), nil
+
+	case "regex":
+		var fc felix.LinkURLRegexFilterConfig
+		if err := f.Unmarshal(&fc); err != nil {
+			return nil, err
+		}
+
+		// Report every invalid expression, not just the first one
+		var errs felix.ConfigErrors
+		for i, expr := range fc.Exprs {
+			if _, err := regexp.Compile(expr); err != nil {
+				errs.Add(fmt.Sprintf("exprs[%d]", i), err)
 			}
+		}
+		if err := errs.Err(); err != nil {
+			return nil, err
+		}
+
+		lurf, err := felix.LinkURLRegexFilter(fc.Exprs...)
+		if err != nil {
+			return nil, err
+		}
+
+		return felix.NamedLinkFilter(f.Type, lurf), nil
+
+	case "filenameastitle":
+		var fc felix.LinkFilenameAsTitleFilterConfig
+		if err := f.Unmarshal(&fc); err != nil {
+			return nil, err
+		}
+
+		return felix.NamedLinkFilter(f.Type, felix.LinkFilenameAsTitleFilter(fc.TrimExt)), nil
 
-			linkFilters = append(linkFilters, felix.LinkDomainFilter(fc.Domains...))
+	case "expanduploadedlinks":
+		if err := f.Unmarshal(&struct{}{}); err != nil {
+			return nil, err
+		}
+
+		return felix.NamedLinkFilter(f.Type, felix.LinkUploadedExpandFilenameFilter(source)), nil
+
+	default:
+		return nil, unknownType("link filter", f.Type)
+	}
+}
+
+// initSinks creates the dispatcher for all configured sinks.
+func initSinks(config felix.Config, db felix.Datastore) *felix.SinkDispatcher {
+	sinks := felix.NewSinkDispatcher(db, config.SinkRetryInterval)
+	sinks.SetLogger(log)
+
+	names := make(map[string]bool)
+	for _, s := range config.Sinks {
+		if names[s.Name] {
+			log.Fatal("duplicate sink name", "name", s.Name, "type", s.Type)
+		}
+		names[s.Name] = true
+
+		sink, err := newSink(config, db, s)
+		if err != nil {
+			log.Fatal("could not create sink", "err", err, "type", s.Type, "name", s.Name)
+		}
+
+		if ss, ok := sink.(interface {
+			Start()
+		}); ok {
+			// Send digests and batches left over from before a restart
+			ss.Start()
+		}
+
+		sinks.Add(s.Name, sink)
+		log.Info("delivering new links to sink", "name", s.Name, "type", s.Type)
+	}
+	return sinks
+}
+
+// newSink creates the sink of the given config. The datastore is only used by the sinks
+// and may be nil to just check the config.
+func newSink(config felix.Config, db felix.Datastore, s felix.SinkConfig) (felix.LinkSink, error) {
+	switch s.Type {
+
+	case "webhook":
+		var sc webhook.Config
+		if err := s.Unmarshal(&sc); err != nil {
+			return nil, err
+		}
 
-		case "regex":
-			var fc felix.LinkURLRegexFilterConfig
-			if err := f.Unmarshal(&fc); err != nil {
-				log.Fatal("could not decode filter config", "err", err, "type", f.Type)
+		return webhook.New(sc, nil)
+
+	case "aria2":
+		var sc aria2.Config
+		if err := s.Unmarshal(&sc); err != nil {
+			return nil, err
+		}
+		if sc.URL != "" {
+			if err := felix.ValidateHTTPURL(sc.URL); err != nil {
+				return nil, felix.ConfigErrors{{Path: "url", Err: err}}
 			}
+		}
 
-			lurf, err := felix.LinkURLRegexFilter(fc.Exprs...)
-			if err != nil {
-				log.Fatal("could not create filter", "err", err, "type", f.Type)
+		sink := aria2.New(s.Name, sc, db, nil)
+		for i, o := range sc.Outputs {
+			filters, ok := outputFilters(config, o.Name)
+			if !ok {
+				return nil, felix.ConfigErrors{{Path: fmt.Sprintf("outputs[%d].name", i), Err: fmt.Errorf("unknown output %q", o.Name)}}
 			}
+			sink.SetOutputFilters(o.Name, filters...)
+		}
 
-			linkFilters = append(linkFilters, lurf)
+		return sink, nil
 
-		case "filenameastitle":
-			var fc felix.LinkFilenameAsTitleFilterConfig
-			if err := f.Unmarshal(&fc); err != nil {
-				log.Fatal("could not decode filter config", "err", err, "type", f.Type)
-			}
+	case "jdownloader":
+		var sc jdownloader.Config
+		if err := s.Unmarshal(&sc); err != nil {
+			return nil, err
+		}
 
-			linkFilters = append(linkFilters, felix.LinkFilenameAsTitleFilter(fc.TrimExt))
+		sink, err := jdownloader.New(s.Name, sc, db, db)
+		if err != nil {
+			return nil, err
+		}
+		sink.SetLogger(log)
 
-		case "expanduploadedlinks":
-			linkFilters = append(linkFilters, felix.LinkUploadedExpandFilenameFilter(source))
+		return sink, nil
 
-		default:
-			log.Fatal("unsupported link filter type", "type", f.Type)
+	case "exec":
+		var sc command.Config
+		if err := s.Unmarshal(&sc); err != nil {
+			return nil, err
+		}
+
+		sink, err := command.New(sc)
+		if err != nil {
+			return nil, err
+		}
+		sink.SetLogger(log)
+
+		return sink, nil
+
+	case "email":
+		var sc email.Config
+		if err := s.Unmarshal(&sc); err != nil {
+			return nil, err
+		}
+
+		sink, err := email.New(s.Name, sc, db, db)
+		if err != nil {
+			return nil, err
 		}
+		sink.SetLogger(log)
+
+		return sink, nil
+
+	case "mqtt":
+		var sc mqtt.Config
+		if err := s.Unmarshal(&sc); err != nil {
+			return nil, err
+		}
+
+		sink, err := mqtt.New(sc)
+		if err != nil {
+			return nil, err
+		}
+		sink.SetLogger(log)
+
+		return sink, nil
+
+	default:
+		return nil, unknownType("sink", s.Type)
+	}
+}
+
+// unknownType returns the error for an unsupported feed, filter or sink type at the "type" key.
+func unknownType(kind, typ string) error {
+	return felix.ConfigErrors{{Path: "type", Err: fmt.Errorf("unknown %s type %q", kind, typ)}}
+}
+
+// outputFilters returns the link filters of the output feed with the given name.
+func outputFilters(config felix.Config, name string) ([]felix.LinkFilter, bool) {
+	for _, o := range config.FeedOutputs() {
+		if o.Name == name {
+			return initLinkFilters(o.Filters), true
+		}
+	}
+	return nil, false
+}
+
+// handle registers the handler for the given pattern and records its latency metrics.
+func handle(pattern string, handler http.Handler) {
+	http.Handle(pattern, felix.InstrumentHandler(pattern, handler))
+}
+
+// registerOutputs registers a feed handler for every configured output feed.
+func registerOutputs(config felix.Config, db felix.Datastore) {
+	paths := make(map[string]bool)
+	for _, o := range config.FeedOutputs() {
+		for _, f := range o.Filters {
+			// Stateful or fetching filters only make sense before links are stored
+			if f.Type == "duplicates" || f.Type == "expanduploadedlinks" {
+				log.Fatal("unsupported output filter type", "type", f.Type, "output", o.Name)
+			}
+		}
+
+		handler := felix.FeedHandler(db, o, initLinkFilters(o.Filters)...)
+		for _, p := range felix.FeedPaths(o.Path) {
+			if paths[p] {
+				log.Fatal("duplicate output path", "path", p, "output", o.Name)
+			}
+			paths[p] = true
+			handle(p, handler)
+		}
+		log.Info("serving output feed", "name", o.Name, "path", o.Path, "filters", len(o.Filters))
 	}
-	return linkFilters
 }
 
 // runPageFetchers restarts old page fetchers found in the datastore
 // as well as new ones on demand when new items are found
-func runPageFetchers(config felix.Config, db felix.Datastore, wg *sync.WaitGroup) {
+func runPageFetchers(config felix.Config, db felix.Datastore, sinks *felix.SinkDispatcher, wg *sync.WaitGroup) {
 	// TODO: refactor this mess.. erm.. component -.-
 	quit := make(chan struct{})
-	// Restore old item fetchers / scrapers
-	oldItems, err := db.GetItems(config.CleanupMaxAge)
+	// Restore old item fetchers / scrapers that did not give up yet
+	itemMaxAge := config.CleanupRetention().Items.MaxAge
+	oldItems, err := db.GetItems(itemMaxAge)
 	if err != nil {
-		log.Error("could not get items", "err", err, "cleanupMaxAge", config.CleanupMaxAge)
+		log.Error("could not get items", "err", err, "maxAge", itemMaxAge)
 	} else {
 		for _, item := range oldItems {
-			// TODO: Make maxTries configurable
+			if item.State != felix.FetcherActive {
+				continue
+			}
 			log.Info("restarting item fetcher", "url", item.URL)
-			nextFetch := felix.NewAttempter(db, felix.FibNextAttemptFunc(config.FetchInterval, 7))
-			f := felix.NewFetcher(item.URL, source, html.LinkScanner, nextFetch, newItems, newLinks)
-			f.SetLogger(log)
-
-			wg.Add(1)
-			go func() {
-				f.Start(quit)
-				wg.Done()
-			}()
+			startPageFetcher(config, db, item.URL, quit, wg)
 		}
 	}
 
@@ -299,21 +638,43 @@ func runPageFetchers(config felix.Config, db felix.Datastore, wg *sync.WaitGroup
 			continue
 		}
 
+		// Pass the item with all datastore managed fields to the sinks
+		if stored, err := db.GetItem(item.URL); err != nil {
+			log.Error("could not get stored item", "err", err, "url", item.URL)
+		} else {
+			sinks.SendItem(stored)
+		}
+
 		log.Info("starting new item fetcher", "url", item.URL)
-		nextFetch := felix.NewAttempter(db, felix.FibNextAttemptFunc(config.FetchInterval, 7))
-		f := felix.NewFetcher(item.URL, source, html.LinkScanner, nextFetch, newItems, newLinks)
-		f.SetLogger(log)
-
-		wg.Add(1)
-		go func() {
-			f.Start(quit)
-			wg.Done()
-		}()
+		startPageFetcher(config, db, item.URL, quit, wg)
 	}
 
 	close(quit)
 }
 
+func startPageFetcher(config felix.Config, db felix.Datastore, url string, quit <-chan struct{}, wg *sync.WaitGroup) {
+	f := newPageFetcher(config, db, url, newItems)
+
+	wg.Add(1)
+	go func() {
+		pageRegistry.Run(f, quit)
+		wg.Done()
+	}()
+}
+
+// newPageFetcher creates the fetcher of an item page, which emits the found items to the given channel.
+func newPageFetcher(config felix.Config, db felix.Datastore, url string, items chan<- felix.Item) *felix.Fetcher {
+	// TODO: Make maxTries configurable
+	nextFetch := felix.NewAttempter(db, felix.FibNextAttemptFunc(config.FetchInterval, 7))
+	f := felix.NewFetcher(url, source, html.LinkScanner, nextFetch, items, newLinks)
+	f.SetLogger(log)
+	f.SetRecorder(db)
+	f.SetStateRecorder(db)
+	// Share the metrics of all page fetchers, there may be many of them
+	f.SetMetricsName("pages")
+	return f
+}
+
 func printVersion() {
 	fmt.Printf("%s version:%s git:%s build:%s\n", os.Args[0], Version, GitSummary, BuildDate)
 }
@@ -0,0 +1,197 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/martinplaner/felix/internal/felix"
+)
+
+// runCommand runs felix as a service or, with -once, fetches everything a single time and exits.
+func runCommand(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	configfile := fs.String("config", "config.yml", "location of the config file")
+	datadir := fs.String("datadir", ".", "dir for auxiliary data")
+	once := fs.Bool("once", false, "fetch every feed and the pages of its new items once, store the results and exit")
+	fs.Parse(args)
+
+	if !*once {
+		log.SetOutput(os.Stdout)
+		serve(*configfile, *datadir)
+		return 0
+	}
+
+	return runOnce(*configfile, *datadir, os.Stdout)
+}
+
+// onceSummary counts the results of a single run.
+type onceSummary struct {
+	feeds       int
+	failedFeeds int
+	pages       int
+	failedPages int
+	newItems    int
+	newLinks    int
+	itemErrors  int
+	linkErrors  int
+}
+
+// failed reports whether any fetch or datastore operation failed.
+func (s onceSummary) failed() bool {
+	return s.failedFeeds+s.failedPages+s.itemErrors+s.linkErrors > 0
+}
+
+func (s onceSummary) print(w io.Writer) {
+	fmt.Fprintf(w, "feeds:      %d fetched, %d failed\n", s.feeds, s.failedFeeds)
+	fmt.Fprintf(w, "item pages: %d fetched, %d failed\n", s.pages, s.failedPages)
+	fmt.Fprintf(w, "new items:  %d\n", s.newItems)
+	fmt.Fprintf(w, "new links:  %d\n", s.newLinks)
+	if errs := s.itemErrors + s.linkErrors; errs > 0 {
+		fmt.Fprintf(w, "errors:     %d\n", errs)
+	}
+}
+
+// runOnce fetches every (not paused) feed once, regardless of the schedule of its Attempter, passes the
+// found items through the item filters and fetches the pages of the new items once. The found links are
+// passed through the link filters, stored and delivered to the sinks. Items found on item pages are not
+// followed. It prints a summary and returns 1 if any fetch or datastore operation failed.
+func runOnce(configfile, datadir string, out io.Writer) int {
+	config := mustLoadConfig(configfile)
+
+	db, err := openDatastore(datadir)
+	if err != nil {
+		log.Error("could not open datastore", "err", err)
+		return 1
+	}
+	defer db.Close()
+
+	stored, err := db.GetFeeds()
+	if err != nil {
+		log.Error("could not get stored feeds", "err", err)
+		return 1
+	}
+	feeds := felix.MergeFeeds(config.Feeds, stored)
+
+	itemFilters := initItemFilters(config)
+	linkFilters := initLinkFilters(config.LinkFilters)
+	tracer, closeTracer := initFilterTracer(config)
+	defer closeTracer()
+	newFetcher := newFeedFetcher(config, db)
+	sinks := initSinks(config, db)
+
+	var summary onceSummary
+
+	go felix.FilterItemsTraced(newItems, filteredItems, tracer, itemFilters...)
+	go felix.FilterLinksTraced(newLinks, filteredLinks, tracer, linkFilters...)
+	sinks.Start()
+
+	linksDone := make(chan struct{})
+	go func() {
+		for link := range filteredLinks {
+			exists, err := db.StoreLink(link)
+			if err != nil {
+				log.Error("could not store link", "err", err, "link", link)
+				summary.linkErrors++
+				continue
+			}
+			if exists {
+				continue
+			}
+
+			log.Info("found new link", "url", link.URL)
+			summary.newLinks++
+
+			stored, err := db.GetLink(link.URL)
+			if err != nil {
+				log.Error("could not get stored link", "err", err, "url", link.URL)
+				summary.linkErrors++
+				continue
+			}
+			sinks.Send(stored)
+		}
+		close(linksDone)
+	}()
+
+	var pages []string
+	itemsDone := make(chan struct{})
+	go func() {
+		for item := range filteredItems {
+			didExist, err := db.StoreItem(item)
+			if err != nil {
+				log.Error("could not store item", "err", err, "item", item)
+				summary.itemErrors++
+				continue
+			}
+			if didExist {
+				continue
+			}
+
+			log.Info("found new item", "url", item.URL)
+			summary.newItems++
+			pages = append(pages, item.URL)
+
+			stored, err := db.GetItem(item.URL)
+			if err != nil {
+				log.Error("could not get stored item", "err", err, "url", item.URL)
+				summary.itemErrors++
+				continue
+			}
+			sinks.SendItem(stored)
+		}
+		close(itemsDone)
+	}()
+
+	for _, fc := range feeds {
+		if fc.Paused {
+			log.Info("skipping paused feed", "url", fc.URL)
+			continue
+		}
+
+		summary.feeds++
+		f, err := newFetcher(fc)
+		if err != nil {
+			log.Error("could not create fetcher", "err", err, "url", fc.URL)
+			summary.failedFeeds++
+			continue
+		}
+		if result := f.FetchOnce(); !result.Success() {
+			summary.failedFeeds++
+		}
+	}
+
+	close(newItems)
+	<-itemsDone
+
+	// Items found on item pages are not followed in a single run
+	pageItems := make(chan felix.Item)
+	go func() {
+		for range pageItems {
+		}
+	}()
+
+	for _, url := range pages {
+		summary.pages++
+		if result := newPageFetcher(config, db, url, pageItems).FetchOnce(); !result.Success() {
+			summary.failedPages++
+		}
+	}
+
+	close(pageItems)
+	close(newLinks)
+	<-linksDone
+
+	// Deliver all new links before exiting, the undelivered ones are queued for the next run
+	sinks.Drain()
+
+	summary.print(out)
+	if summary.failed() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,225 @@
+// Copyright 2017 Martin Planer. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/martinplaner/felix/internal/felix"
+	"github.com/martinplaner/felix/internal/felix/html"
+	"github.com/martinplaner/felix/internal/felix/rss"
+	"golang.org/x/net/context"
+)
+
+// scanners contains the scanners that can be selected by scanCommand.
+var scanners = map[string]felix.Scanner{
+	"rss":  rss.ItemScanner,
+	"html": html.LinkScanner,
+}
+
+// scanCommand runs a scanner on a single URL, local file or stdin and prints everything it emits.
+// The emitted items and links can optionally be passed through the configured filter chains.
+func scanCommand(args []string) int {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	configfile := fs.String("config", "config.yml", "location of the config file (only used for filters)")
+	scannerName := fs.String("scanner", "auto", "scanner to run: rss, html or auto (detected by the content)")
+	format := fs.String("format", "table", "output format: table or json")
+	filterItems := fs.Bool("filter-items", false, "pass the items through the configured item filters")
+	filterLinks := fs.Bool("filter-links", false, "pass the links through the configured link filters")
+	fs.Usage = func() {
+		fs.Output().Write([]byte("usage: felix scan [flags] <url|file|->\n"))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || (*format != "table" && *format != "json") {
+		fs.Usage()
+		return 2
+	}
+	if _, ok := scanners[*scannerName]; !ok && *scannerName != "auto" {
+		log.Error("unknown scanner", "scanner", *scannerName)
+		return 2
+	}
+
+	var itemFilters []felix.ItemFilter
+	var linkFilters []felix.LinkFilter
+	if *filterItems || *filterLinks {
+		config := mustLoadConfig(*configfile)
+		if *filterItems {
+			itemFilters = initItemFilters(config)
+		}
+		if *filterLinks {
+			linkFilters = initLinkFilters(config.LinkFilters)
+		}
+	}
+
+	e, err := scanInput(fs.Arg(0), *scannerName)
+	if err != nil {
+		log.Error("could not scan input", "err", err, "input", fs.Arg(0))
+		return 1
+	}
+
+	result := scanResult{
+		Items:   felix.TraceItems(e.items, itemFilters...),
+		Links:   felix.TraceLinks(e.links, linkFilters...),
+		Follows: append([]string{}, e.follows...),
+	}
+	if *format == "json" {
+		err = json.NewEncoder(os.Stdout).Encode(result)
+	} else {
+		err = result.writeTable(os.Stdout, *filterItems, *filterLinks)
+	}
+	if err != nil {
+		log.Error("could not write output", "err", err)
+		return 1
+	}
+
+	return 0
+}
+
+// scanInput runs the named scanner (or the detected one for "auto") on the input (see openScanInput)
+// and returns everything it emitted.
+func scanInput(input, scannerName string) (*scanEmitter, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	r, err := openScanInput(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("could not read input: %v", err)
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	br := bufio.NewReader(r)
+	if scannerName == "auto" {
+		scannerName = detectScanner(br)
+		log.Info("detected scanner", "scanner", scannerName)
+	}
+
+	e := &scanEmitter{}
+	if input != "-" {
+		e.source = input
+	}
+	if err := scanners[scannerName].Scan(ctx, br, e); err != nil {
+		return nil, fmt.Errorf("could not run %s scanner: %v", scannerName, err)
+	}
+
+	return e, nil
+}
+
+// openScanInput returns the content of the URL (fetched via the Source), local file or stdin ("-").
+func openScanInput(ctx context.Context, input string) (io.Reader, error) {
+	switch {
+	case input == "-":
+		return os.Stdin, nil
+	case strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://"):
+		return source.Get(ctx, input)
+	default:
+		return os.Open(input)
+	}
+}
+
+// detectScanner returns rss if the content starts like an RSS, RDF or Atom feed and html otherwise.
+func detectScanner(r *bufio.Reader) string {
+	head, _ := r.Peek(1024)
+	head = bytes.ToLower(head)
+	for _, tag := range []string{"<rss", "<rdf:rdf", "<feed"} {
+		if bytes.Contains(head, []byte(tag)) {
+			return "rss"
+		}
+	}
+	return "html"
+}
+
+// scanEmitter collects everything that is emitted by a scanner.
+type scanEmitter struct {
+	source  string
+	items   []felix.Item
+	links   []felix.Link
+	follows []string
+}
+
+func (e *scanEmitter) EmitItem(item felix.Item) {
+	e.items = append(e.items, item)
+}
+
+func (e *scanEmitter) EmitLink(link felix.Link) {
+	if link.Source == "" {
+		link.Source = e.source
+	}
+	e.links = append(e.links, link)
+}
+
+func (e *scanEmitter) EmitFollow(follow string) {
+	e.follows = append(e.follows, follow)
+}
+
+// scanResult is the output of scanCommand.
+type scanResult struct {
+	Items   []felix.ItemTrace `json:"items"`
+	Links   []felix.LinkTrace `json:"links"`
+	Follows []string          `json:"follows"`
+}
+
+// writeTable writes one row per emitted entry. If the items or links were filtered, the rows include the
+// filter result and the links that left the chain, if they differ from the emitted link.
+func (s scanResult) writeTable(w io.Writer, filterItems, filterLinks bool) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	filtered := filterItems || filterLinks
+
+	if filtered {
+		fmt.Fprintln(tw, "TYPE\tTITLE\tURL\tRESULT")
+	} else {
+		fmt.Fprintln(tw, "TYPE\tTITLE\tURL")
+	}
+
+	for _, t := range s.Items {
+		row(tw, filtered, "item", t.Item.Title, t.Item.URL, traceResult(filterItems, t.DroppedBy, t.DroppedAt))
+	}
+
+	for _, t := range s.Links {
+		row(tw, filtered, "link", t.Link.Title, t.Link.URL, traceResult(filterLinks, t.DroppedBy, t.DroppedAt))
+		if !filterLinks || (len(t.Output) == 1 && t.Output[0] == t.Link) {
+			continue
+		}
+		for _, out := range t.Output {
+			row(tw, filtered, "  ->", out.Title, out.URL, "")
+		}
+	}
+
+	for _, f := range s.Follows {
+		row(tw, filtered, "follow", "", f, "")
+	}
+
+	return tw.Flush()
+}
+
+func row(w io.Writer, filtered bool, kind, title, url, result string) {
+	if filtered {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", kind, title, url, result)
+	} else {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", kind, title, url)
+	}
+}
+
+func traceResult(filtered bool, droppedBy string, droppedAt int) string {
+	if !filtered {
+		return ""
+	}
+	if droppedAt == 0 {
+		return "passed"
+	}
+	return fmt.Sprintf("dropped by %s (#%d)", droppedBy, droppedAt)
+}